@@ -1,5 +1,14 @@
 package persist
 
+import (
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"math/bits"
+	"reflect"
+	"unsafe"
+)
+
 const (
 	EGlobalManagerStateIdle   = 0 // 初始化
 	EGlobalManagerStateNormal = 1 // 正常运行
@@ -16,10 +25,11 @@ const (
 	EGlobalLoadStatePrepareUnloading = 3 // 准备导出
 	EGlobalLoadStateUnloading        = 4 // 正在导出
 
-	EGlobalOpInsert = 1 // 新建
-	EGlobalOpUpdate = 2 // 修改
-	EGlobalOpDelete = 3 // 删除
-	EGlobalOpUnload = 4 // 导出
+	EGlobalOpInsert  = 1 // 新建
+	EGlobalOpUpdate  = 2 // 修改
+	EGlobalOpDelete  = 3 // 删除
+	EGlobalOpUnload  = 4 // 导出
+	EGlobalOpReplace = 5 // 替换, 删除后新建
 
 	EGlobalCollectStateNormal    = 0 // 正常
 	EGlobalCollectStateSaveSync  = 1 // 开始退出, 清理同步队列
@@ -38,30 +48,31 @@ type GlobalFieldIndex = uint
 // GlobalBitSet 全局位图管理
 type GlobalBitSet[T any] struct {
 	set []uint64
-	t   T
+	num GlobalFieldIndex // 字段数量, 初始化时从元数据缓存获取
 }
 
 func InitGlobalBitSet[T any]() GlobalBitSet[T] {
-	t := new(T)
+	num := GlobalFieldIndex(GetGlobalMeta[T]().FieldNum)
 	return GlobalBitSet[T]{
-		set: make([]uint64, (GetFieldNum(t)>>EGlobalLog2WordSize)+1),
-		t:   *t,
+		set: make([]uint64, (num>>EGlobalLog2WordSize)+1),
+		num: num,
 	}
 }
 
 // Get 获取位 i 的值
 func (b *GlobalBitSet[T]) Get(i GlobalFieldIndex) bool {
-	globalFiledIndexLength := GlobalFieldIndex(GetFieldNum(b.t))
-	if i >= globalFiledIndexLength {
+	if i >= b.num {
 		return false
 	}
 	return b.set[i>>EGlobalLog2WordSize]&(1<<(i&(EGlobalWordSize-1))) != 0
 }
 
-// Set 设置位 i
+// Set 设置位 i, 零值位图在第一次设置时初始化
 func (b *GlobalBitSet[T]) Set(i GlobalFieldIndex) *GlobalBitSet[T] {
-	globalFiledIndexLength := GlobalFieldIndex(GetFieldNum(b.t))
-	if i >= globalFiledIndexLength {
+	if b.set == nil {
+		*b = InitGlobalBitSet[T]()
+	}
+	if i >= b.num {
 		return nil
 	}
 	b.set[i>>EGlobalLog2WordSize] |= 1 << (i & (EGlobalWordSize - 1))
@@ -70,22 +81,66 @@ func (b *GlobalBitSet[T]) Set(i GlobalFieldIndex) *GlobalBitSet[T] {
 
 // Clear 清除位 i
 func (b *GlobalBitSet[T]) Clear(i GlobalFieldIndex) *GlobalBitSet[T] {
-	globalFiledIndexLength := GlobalFieldIndex(GetFieldNum(b.t))
-	if i >= globalFiledIndexLength {
+	if i >= b.num {
 		return b
 	}
 	b.set[i>>EGlobalLog2WordSize] &^= 1 << (i & (EGlobalWordSize - 1))
 	return b
 }
 
-// Merge 合并两个位图
+// Merge 合并两个位图, 长度不同时按较长的位图扩展
 func (b *GlobalBitSet[T]) Merge(compare GlobalBitSet[T]) *GlobalBitSet[T] {
-	for i, word := range b.set {
-		b.set[i] = word | compare.set[i]
+	b.grow(compare)
+	for i, word := range compare.set {
+		b.set[i] |= word
+	}
+	return b
+}
+
+// Intersect 只保留两个位图都设置的位
+func (b *GlobalBitSet[T]) Intersect(compare GlobalBitSet[T]) *GlobalBitSet[T] {
+	for i := range b.set {
+		if i < len(compare.set) {
+			b.set[i] &= compare.set[i]
+		} else {
+			b.set[i] = 0
+		}
+	}
+	return b
+}
+
+// Difference 清除 compare 中设置的位
+func (b *GlobalBitSet[T]) Difference(compare GlobalBitSet[T]) *GlobalBitSet[T] {
+	for i := range b.set {
+		if i < len(compare.set) {
+			b.set[i] &^= compare.set[i]
+		}
 	}
 	return b
 }
 
+// Equal 两个位图设置的字段是否相同
+func (b *GlobalBitSet[T]) Equal(compare GlobalBitSet[T]) bool {
+	num := max(b.fieldNum(), compare.fieldNum())
+	for w := GlobalFieldIndex(0); w<<EGlobalLog2WordSize < num; w++ {
+		mask := wordMask(num, w)
+		if wordAt(b.set, w)&mask != wordAt(compare.set, w)&mask {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone 复制位图, 修改副本不影响原位图
+func (b *GlobalBitSet[T]) Clone() GlobalBitSet[T] {
+	cp := GlobalBitSet[T]{num: b.num}
+	if b.set != nil {
+		cp.set = make([]uint64, len(b.set))
+		copy(cp.set, b.set)
+	}
+	return cp
+}
+
 // ClearAll 清除所有位
 func (b *GlobalBitSet[T]) ClearAll() *GlobalBitSet[T] {
 	if b != nil {
@@ -96,25 +151,191 @@ func (b *GlobalBitSet[T]) ClearAll() *GlobalBitSet[T] {
 	return b
 }
 
-// SetAll 设置所有位
+// SetAll 设置所有字段对应的位, 超出字段数量的位保持为0
 func (b *GlobalBitSet[T]) SetAll() *GlobalBitSet[T] {
 	if b != nil {
+		if b.set == nil {
+			*b = InitGlobalBitSet[T]()
+		}
 		for i := range b.set {
-			b.set[i] = EGlobalAllBits
+			b.set[i] = wordMask(b.num, GlobalFieldIndex(i))
 		}
 	}
 	return b
 }
 
-// IsSetAll 是否所有位都被设置
+// IsSetAll 是否所有字段对应的位都被设置
 func (b *GlobalBitSet[T]) IsSetAll() bool {
 	if b != nil {
-		for i := range b.set {
-			if b.set[i] == EGlobalAllBits {
-				continue
+		num := b.fieldNum()
+		for w := GlobalFieldIndex(0); w<<EGlobalLog2WordSize < num; w++ {
+			mask := wordMask(num, w)
+			if wordAt(b.set, w)&mask != mask {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsEmpty 是否没有设置任何字段
+func (b *GlobalBitSet[T]) IsEmpty() bool {
+	if b != nil {
+		num := b.fieldNum()
+		for w := GlobalFieldIndex(0); w<<EGlobalLog2WordSize < num; w++ {
+			if wordAt(b.set, w)&wordMask(num, w) != 0 {
+				return false
 			}
-			return false
 		}
 	}
 	return true
 }
+
+// MarshalBinary 序列化位图: uvarint(字段数量) + uvarint(单元数量) + 单元(8字节小端)
+func (b *GlobalBitSet[T]) MarshalBinary() ([]byte, error) {
+	num := b.fieldNum()
+	words := int((num + EGlobalWordSize - 1) >> EGlobalLog2WordSize)
+	data := binary.AppendUvarint(nil, uint64(num))
+	data = binary.AppendUvarint(data, uint64(words))
+	for w := 0; w < words; w++ {
+		data = binary.LittleEndian.AppendUint64(data, wordAt(b.set, GlobalFieldIndex(w))&wordMask(num, GlobalFieldIndex(w)))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary 反序列化位图, 写入时的字段数量少于当前结构体时新增字段未设置, 多出的字段被丢弃
+func (b *GlobalBitSet[T]) UnmarshalBinary(data []byte) error {
+	r := &PersistDecoder{data: data}
+	num, err := r.uvarint()
+	if err != nil {
+		return err
+	}
+	words, err := r.length()
+	if err != nil {
+		return err
+	}
+	if uint64(words) != (num+uint64(EGlobalWordSize)-1)>>EGlobalLog2WordSize {
+		return fmt.Errorf("%w: bitset %d words for %d fields", EPersistErrorInvalidData, words, num)
+	}
+
+	*b = InitGlobalBitSet[T]()
+	for w := 0; w < words; w++ {
+		word, err := r.uint64()
+		if err != nil {
+			return err
+		}
+		if w < len(b.set) {
+			b.set[w] = word & wordMask(min(b.num, GlobalFieldIndex(num)), GlobalFieldIndex(w))
+		}
+	}
+	if r.pos != len(data) {
+		return fmt.Errorf("%w: %d trailing bytes", EPersistErrorInvalidData, len(data)-r.pos)
+	}
+	return nil
+}
+
+// fieldNum 字段数量, 零值位图从元数据获取
+func (b *GlobalBitSet[T]) fieldNum() GlobalFieldIndex {
+	if b.set == nil {
+		return GlobalFieldIndex(GetGlobalMeta[T]().FieldNum)
+	}
+	return b.num
+}
+
+// grow 扩展到与 compare 相同的长度
+func (b *GlobalBitSet[T]) grow(compare GlobalBitSet[T]) {
+	if b.set == nil {
+		*b = InitGlobalBitSet[T]()
+	}
+	if len(b.set) < len(compare.set) {
+		b.set = append(b.set, make([]uint64, len(compare.set)-len(b.set))...)
+	}
+	b.num = max(b.num, compare.num)
+}
+
+// wordAt 获取第 w 个单元, 超出长度为0
+func wordAt(set []uint64, w GlobalFieldIndex) uint64 {
+	if w >= GlobalFieldIndex(len(set)) {
+		return 0
+	}
+	return set[w]
+}
+
+// wordMask 第 w 个单元中属于前 num 个字段的位
+func wordMask(num, w GlobalFieldIndex) uint64 {
+	begin := w << EGlobalLog2WordSize
+	switch {
+	case num <= begin:
+		return 0
+	case num-begin >= EGlobalWordSize:
+		return EGlobalAllBits
+	default:
+		return 1<<(num-begin) - 1
+	}
+}
+
+// SetByName 按字段名设置位, 字段不存在时返回 nil
+func (b *GlobalBitSet[T]) SetByName(name string) *GlobalBitSet[T] {
+	idx, ok := GetGlobalMeta[T]().FieldIndex(name)
+	if !ok {
+		return nil
+	}
+	return b.Set(idx)
+}
+
+// SetField 按字段指针设置位, field 必须是 row 的字段地址, 如 bitSet.SetField(row, &row.Sort)
+// field 不是 row 的直接字段时返回 nil
+func (b *GlobalBitSet[T]) SetField(row *T, field any) *GlobalBitSet[T] {
+	v := reflect.ValueOf(field)
+	if row == nil || v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil
+	}
+	base, ptr := uintptr(unsafe.Pointer(row)), v.Pointer()
+	if ptr < base {
+		return nil
+	}
+	idx, ok := GetGlobalMeta[T]().FieldIndexByOffset(ptr-base, v.Type().Elem())
+	if !ok {
+		return nil
+	}
+	return b.Set(idx)
+}
+
+// Fields 按顺序遍历已设置的位
+func (b *GlobalBitSet[T]) Fields() iter.Seq[GlobalFieldIndex] {
+	return func(yield func(GlobalFieldIndex) bool) {
+		for w, word := range b.set {
+			for word != 0 {
+				i := GlobalFieldIndex(w)<<EGlobalLog2WordSize + GlobalFieldIndex(bits.TrailingZeros64(word))
+				if i >= b.num || !yield(i) {
+					return
+				}
+				word &= word - 1
+			}
+		}
+	}
+}
+
+// Count 已设置的位数量
+func (b *GlobalBitSet[T]) Count() (count int) {
+	for range b.Fields() {
+		count++
+	}
+	return
+}
+
+// Cols 已设置字段的数据库列名, 按 SnakeMapper 映射, 可直接传给 xorm Cols()
+// 数据库连接使用其它映射规则时使用 GlobalManager.Cols
+func (b *GlobalBitSet[T]) Cols() []string {
+	return b.ColsWith(GetGlobalMeta[T]().Columns)
+}
+
+// ColsWith 已设置字段按 columns(字段序号 -> 列名) 映射的列名, 跳过非数据库字段
+func (b *GlobalBitSet[T]) ColsWith(columns []string) (cols []string) {
+	for i := range b.Fields() {
+		if int(i) < len(columns) && columns[i] != "" {
+			cols = append(cols, columns[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package persist_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// wideGlobal 字段数量超过一个单元
+type wideGlobal struct {
+	F00, F01, F02, F03, F04, F05, F06, F07, F08, F09 int
+	F10, F11, F12, F13, F14, F15, F16, F17, F18, F19 int
+	F20, F21, F22, F23, F24, F25, F26, F27, F28, F29 int
+	F30, F31, F32, F33, F34, F35, F36, F37, F38, F39 int
+	F40, F41, F42, F43, F44, F45, F46, F47, F48, F49 int
+	F50, F51, F52, F53, F54, F55, F56, F57, F58, F59 int
+	F60, F61, F62, F63, F64, F65, F66, F67, F68, F69 int
+}
+
+// bitSetOf 创建设置了指定位的位图
+func bitSetOf[T any](fields ...persist.GlobalFieldIndex) persist.GlobalBitSet[T] {
+	bitSet := persist.InitGlobalBitSet[T]()
+	for _, i := range fields {
+		bitSet.Set(i)
+	}
+	return bitSet
+}
+
+// TestGlobalBitSet_SetAll 测试字段数量不是64倍数时的全部设置.
+func TestGlobalBitSet_SetAll(t *testing.T) {
+	bitSet := persist.InitGlobalBitSet[MetaGlobal]()
+	if !bitSet.IsEmpty() || bitSet.IsSetAll() {
+		t.Fatal("new bitSet should be empty")
+	}
+	bitSet.SetAll()
+	if !bitSet.IsSetAll() || bitSet.IsEmpty() || bitSet.Count() != 7 {
+		t.Errorf("SetAll() IsSetAll = %v, Count = %d", bitSet.IsSetAll(), bitSet.Count())
+	}
+	bitSet.Clear(6)
+	if bitSet.IsSetAll() {
+		t.Error("IsSetAll() after Clear should be false")
+	}
+
+	wide := persist.InitGlobalBitSet[wideGlobal]()
+	for i := persist.GlobalFieldIndex(0); i < 70; i++ {
+		wide.Set(i)
+	}
+	if !wide.IsSetAll() {
+		t.Error("IsSetAll() with 70 fields should be true")
+	}
+	var zero persist.GlobalBitSet[wideGlobal]
+	if zero.IsSetAll() || !zero.IsEmpty() || !zero.SetAll().IsSetAll() {
+		t.Error("zero bitSet SetAll/IsSetAll/IsEmpty mismatch")
+	}
+}
+
+// TestGlobalBitSet_Algebra 测试合并, 交集, 差集和比较.
+func TestGlobalBitSet_Algebra(t *testing.T) {
+	a := bitSetOf[wideGlobal](1, 64, 69)
+	b := bitSetOf[wideGlobal](1, 2, 69)
+
+	union := a.Clone()
+	union.Merge(b)
+	if want := bitSetOf[wideGlobal](1, 2, 64, 69); !union.Equal(want) {
+		t.Error("Merge() mismatch")
+	}
+	inter := a.Clone()
+	inter.Intersect(b)
+	if want := bitSetOf[wideGlobal](1, 69); !inter.Equal(want) {
+		t.Error("Intersect() mismatch")
+	}
+	diff := a.Clone()
+	diff.Difference(b)
+	if want := bitSetOf[wideGlobal](64); !diff.Equal(want) {
+		t.Error("Difference() mismatch")
+	}
+	if a.Equal(b) || !a.Equal(a.Clone()) {
+		t.Error("Equal() mismatch")
+	}
+
+	// 零值位图可以参与运算
+	var zero persist.GlobalBitSet[wideGlobal]
+	zero.Merge(a)
+	if !zero.Equal(a) || !a.Equal(bitSetOf[wideGlobal](1, 64, 69)) {
+		t.Error("Merge() into zero bitSet mismatch")
+	}
+	var empty persist.GlobalBitSet[wideGlobal]
+	if !empty.Equal(persist.InitGlobalBitSet[wideGlobal]()) {
+		t.Error("zero bitSet should equal empty bitSet")
+	}
+}
+
+// TestGlobalBitSet_MarshalBinary 测试位图序列化.
+func TestGlobalBitSet_MarshalBinary(t *testing.T) {
+	src := bitSetOf[wideGlobal](0, 63, 64, 69)
+	src.SetAll()
+	src.Clear(3)
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst persist.GlobalBitSet[wideGlobal]
+	if err = dst.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	if !dst.Equal(src) || dst.Count() != 69 {
+		t.Errorf("UnmarshalBinary() Count = %d", dst.Count())
+	}
+
+	// 结构体增加字段后旧数据仍可读取, 新增字段未设置
+	old := bitSetOf[MetaGlobal](0, 6)
+	old.SetAll()
+	data, _ = old.MarshalBinary()
+	var grown persist.GlobalBitSet[wideGlobal]
+	if err = grown.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if grown.Count() != 7 || grown.Get(7) {
+		t.Errorf("grown schema Count = %d", grown.Count())
+	}
+	// 字段减少时丢弃多出的位
+	data, _ = src.MarshalBinary()
+	var shrunk persist.GlobalBitSet[MetaGlobal]
+	if err = shrunk.UnmarshalBinary(data); err != nil || shrunk.Count() != 6 || shrunk.Get(3) {
+		t.Errorf("shrunk schema Count = %d, %v", shrunk.Count(), err)
+	}
+
+	for _, bad := range [][]byte{nil, {7, 2, 0}, append(data, 0)} {
+		if err = dst.UnmarshalBinary(bad); !errors.Is(err, persist.EPersistErrorInvalidData) {
+			t.Errorf("UnmarshalBinary(%x) error = %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package persist
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bomb/trace 文件格式
+//
+//	头部: persist:1;name=<PersistName>;kind=<bomb|trace>;count=<N>;time=<unix>;codec=<none|snappy|zstd>;enc=aes-gcm;kid=<KeyID>;nonce=<base64> 以一个空格结束
+//	数据: 若干记录帧, 每帧为 uvarint(记录长度) + crc32c(4字节小端) + 记录数据
+//
+// 数据区整体先按 codec 流式压缩, 再按 enc 分段加密, 读取时边解密解压边解析.
+// 记录数据为 PersistSyncToBytes 的结果, 由各 IPersist 自己的编解码器解析.
+
+const (
+	EBombMagic     = "persist:1" // 文件头魔数及版本
+	EBombKindBomb  = "bomb"      // 写回失败数据
+	EBombKindTrace = "trace"     // 追踪日志数据
+	EBombKindSpill = "spill"     // 过载溢出数据
+
+	EBombFileExt  = ".bomb"  // 写回失败文件后缀
+	ETmpFileExt   = ".tmp"   // 正在写入的临时文件后缀
+	ETraceFileExt = ".trace" // 追踪日志文件后缀
+	ESpillFileExt = ".spill" // 过载溢出文件后缀
+
+	eBombHeaderMaxSize = 4096    // 文件头最大长度
+	eBombRecordMaxSize = 1 << 30 // 单条记录最大长度
+)
+
+var (
+	gBombDir   = "./data"                          // bomb文件目录
+	crc32Table = crc32.MakeTable(crc32.Castagnoli) // 记录校验表
+)
+
+// SetBombDir 设置bomb文件目录
+func SetBombDir(dir string) {
+	gBombDir = dir
+}
+
+// GetBombDir 获取bomb文件目录
+func GetBombDir() string {
+	return gBombDir
+}
+
+// BombFilePath 写回失败文件路径
+func BombFilePath(name string) string {
+	return filepath.Join(gBombDir, name+EBombFileExt)
+}
+
+// TmpFilePath 写回失败临时文件路径
+func TmpFilePath(name string) string {
+	return filepath.Join(gBombDir, name+ETmpFileExt)
+}
+
+// TraceFilePath 追踪日志文件路径
+func TraceFilePath(name string) string {
+	return filepath.Join(gBombDir, name+ETraceFileExt)
+}
+
+// SpillFilePath 过载溢出文件路径, seq 为溢出顺序
+func SpillFilePath(name string, seq uint64) string {
+	return filepath.Join(gBombDir, fmt.Sprintf("%s.%08d%s", name, seq, ESpillFileExt))
+}
+
+// BombHeader bomb文件头
+type BombHeader struct {
+	Name  string    // persist名
+	Kind  string    // 文件类型 bomb/trace/spill
+	Count int       // 记录数量, 小于0表示未知
+	Time  time.Time // 写入时间
+
+	Compression BombCompression // 数据区压缩算法
+	Encryption  BombEncryption  // 数据区加密算法
+	KeyID       string          // 加密密钥ID, 为空时写入使用 KeyProvider 的当前密钥
+	Nonce       []byte          // 加密nonce前缀, 写入时自动生成
+}
+
+// NewBombHeader 按persist配置创建文件头, 设置了 KeyProvider 时加密
+func NewBombHeader(name, kind string, count int) BombHeader {
+	header := BombHeader{
+		Name:        name,
+		Kind:        kind,
+		Count:       count,
+		Time:        time.Now(),
+		Compression: GetBombCompression(name),
+	}
+	if GetBombKeyProvider() != nil {
+		header.Encryption = EBombEncryptionAESGCM
+	}
+	return header
+}
+
+// String 序列化文件头, 不包含结尾空格
+func (h *BombHeader) String() string {
+	var builder strings.Builder
+	builder.WriteString(EBombMagic)
+	writeBombHeaderField(&builder, "name", h.Name)
+	writeBombHeaderField(&builder, "kind", h.Kind)
+	if h.Count >= 0 {
+		writeBombHeaderField(&builder, "count", strconv.Itoa(h.Count))
+	}
+	if !h.Time.IsZero() {
+		writeBombHeaderField(&builder, "time", strconv.FormatInt(h.Time.Unix(), 10))
+	}
+	if h.Compression != "" && h.Compression != EBombCompressionNone {
+		writeBombHeaderField(&builder, "codec", string(h.Compression))
+	}
+	if h.Encryption != EBombEncryptionNone {
+		writeBombHeaderField(&builder, "enc", string(h.Encryption))
+		writeBombHeaderField(&builder, "kid", h.KeyID)
+		writeBombHeaderField(&builder, "nonce", base64.RawURLEncoding.EncodeToString(h.Nonce))
+	}
+	return builder.String()
+}
+
+// writeBombHeaderField 写入文件头字段
+func writeBombHeaderField(builder *strings.Builder, key, value string) {
+	builder.WriteByte(';')
+	builder.WriteString(key)
+	builder.WriteByte('=')
+	builder.WriteString(url.QueryEscape(value))
+}
+
+// ParseBombHeader 解析文件头, 不包含结尾空格
+func ParseBombHeader(data string) (header BombHeader, err error) {
+	fields := strings.Split(data, ";")
+	if fields[0] != EBombMagic {
+		return header, EPersistErrorInvalidBombFile
+	}
+	header.Count = -1
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return header, EPersistErrorInvalidBombFile
+		}
+		if value, err = url.QueryUnescape(value); err != nil {
+			return header, EPersistErrorInvalidBombFile
+		}
+		switch key {
+		case "name":
+			header.Name = value
+		case "kind":
+			header.Kind = value
+		case "count":
+			if header.Count, err = strconv.Atoi(value); err != nil {
+				return header, EPersistErrorInvalidBombFile
+			}
+		case "time":
+			sec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return header, EPersistErrorInvalidBombFile
+			}
+			header.Time = time.Unix(sec, 0)
+		case "codec":
+			header.Compression = BombCompression(value)
+		case "enc":
+			header.Encryption = BombEncryption(value)
+		case "kid":
+			header.KeyID = value
+		case "nonce":
+			if header.Nonce, err = base64.RawURLEncoding.DecodeString(value); err != nil {
+				return header, EPersistErrorInvalidBombFile
+			}
+		default:
+			// 忽略未知字段, 兼容新版本写入的文件
+		}
+	}
+	if header.Name == "" {
+		return header, EPersistErrorInvalidBombFile
+	}
+	switch header.Encryption {
+	case EBombEncryptionNone:
+	case EBombEncryptionAESGCM:
+		if header.KeyID == "" || len(header.Nonce) != eBombNonceSize {
+			return header, EPersistErrorInvalidBombFile
+		}
+	default:
+		return header, fmt.Errorf("%w: unknown encryption %q", EPersistErrorInvalidBombFile, header.Encryption)
+	}
+	return header, nil
+}
+
+// BombRecordError 单条记录错误, 读取可以继续
+type BombRecordError struct {
+	Index  int   // 记录序号
+	Offset int64 // 记录在数据区的偏移
+	Err    error // 底层错误
+}
+
+// Error 实现 error 接口
+func (e *BombRecordError) Error() string {
+	return fmt.Sprintf("record %d at offset %d: %v", e.Index, e.Offset, e.Err)
+}
+
+// Unwrap 返回底层错误
+func (e *BombRecordError) Unwrap() error {
+	return e.Err
+}
+
+// BombWriter bomb文件写入
+type BombWriter struct {
+	w       io.Writer
+	closers []io.Closer // 压缩层和加密层, 结束时按顺序刷新
+	frame   []byte
+	count   int
+}
+
+// NewBombWriter 创建bomb文件写入, 立即写入文件头
+func NewBombWriter(w io.Writer, header BombHeader) (*BombWriter, error) {
+	if header.Name == "" || strings.ContainsAny(header.Name, " ;") {
+		return nil, EPersistErrorInvalidBombFile
+	}
+	if header.Kind == "" {
+		header.Kind = EBombKindBomb
+	}
+
+	var aead cipher.AEAD
+	switch header.Encryption {
+	case EBombEncryptionNone:
+	case EBombEncryptionAESGCM:
+		var err error
+		if header.KeyID == "" {
+			provider := GetBombKeyProvider()
+			if provider == nil {
+				return nil, &BombKeyError{Err: fmt.Errorf("%w: no key provider", EPersistErrorKeyMissing)}
+			}
+			if header.KeyID, err = provider.CurrentKeyID(); err != nil {
+				return nil, err
+			}
+		}
+		if aead, err = newBombAEAD(header.KeyID); err != nil {
+			return nil, err
+		}
+		if header.Nonce, err = newBombNonce(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown encryption %q", EPersistErrorInvalidBombFile, header.Encryption)
+	}
+
+	head := header.String()
+	if _, err := io.WriteString(w, head+" "); err != nil {
+		return nil, err
+	}
+	bw := &BombWriter{w: w}
+	if aead != nil {
+		ew := newEncryptWriter(bw.w, aead, head, header.Nonce)
+		bw.w, bw.closers = ew, append(bw.closers, ew)
+	}
+	cw, err := newCompressWriter(bw.w, header.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if cw != nil {
+		bw.w, bw.closers = cw, append(bw.closers, cw)
+	}
+	return bw, nil
+}
+
+// Write 写入一条记录
+func (bw *BombWriter) Write(record []byte) error {
+	bw.frame = binary.AppendUvarint(bw.frame[:0], uint64(len(record)))
+	bw.frame = binary.LittleEndian.AppendUint32(bw.frame, crc32.Checksum(record, crc32Table))
+	bw.frame = append(bw.frame, record...)
+	if _, err := bw.w.Write(bw.frame); err != nil {
+		return err
+	}
+	bw.count++
+	return nil
+}
+
+// Count 已写入记录数量
+func (bw *BombWriter) Count() int {
+	return bw.count
+}
+
+// Close 结束写入, 依次刷新压缩层和加密层, 不关闭底层 io.Writer
+func (bw *BombWriter) Close() error {
+	for i := len(bw.closers) - 1; i >= 0; i-- {
+		if err := bw.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	bw.closers = nil
+	return nil
+}
+
+// BombReader bomb文件读取
+type BombReader struct {
+	Header BombHeader
+
+	r      *bufio.Reader
+	closer io.Closer // 解压层, 结束时需要释放
+	index  int
+	offset int64
+}
+
+// NewBombReader 创建bomb文件读取, 立即解析文件头
+func NewBombReader(r io.Reader) (*BombReader, error) {
+	br := bufio.NewReader(r)
+	var head []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, EPersistErrorInvalidBombFile
+		}
+		if c == ' ' {
+			break
+		}
+		head = append(head, c)
+		if len(head) > eBombHeaderMaxSize {
+			return nil, EPersistErrorInvalidBombFile
+		}
+	}
+	header, err := ParseBombHeader(string(head))
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader = br
+	if header.Encryption == EBombEncryptionAESGCM {
+		aead, err := newBombAEAD(header.KeyID)
+		if err != nil {
+			return nil, err
+		}
+		body = newDecryptReader(body, aead, string(head), header.Nonce)
+	}
+	cr, err := newDecompressReader(body, header.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if cr != nil {
+		return &BombReader{Header: header, r: bufio.NewReader(cr), closer: cr}, nil
+	}
+	if body != io.Reader(br) {
+		return &BombReader{Header: header, r: bufio.NewReader(body)}, nil
+	}
+	return &BombReader{Header: header, r: br}, nil
+}
+
+// Close 释放解压层, 不关闭底层 io.Reader
+func (br *BombReader) Close() error {
+	if br.closer != nil {
+		return br.closer.Close()
+	}
+	return nil
+}
+
+// Next 读取下一条记录, 结束时返回 io.EOF
+// 校验失败时返回 *BombRecordError, 可以继续读取; 其它错误表示文件已损坏, 不能继续读取
+// 错误中的偏移为解压后数据区的偏移
+func (br *BombReader) Next() (record []byte, err error) {
+	offset := br.offset
+	size, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, &BombRecordError{Index: br.index, Offset: offset, Err: err}
+	}
+	if size > eBombRecordMaxSize {
+		return nil, &BombRecordError{Index: br.index, Offset: offset, Err: EPersistErrorInvalidBombFile}
+	}
+	buf := make([]byte, 4+size)
+	if _, err = io.ReadFull(br.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &BombRecordError{Index: br.index, Offset: offset, Err: err}
+	}
+	br.offset += int64(uvarintLen(size)) + int64(len(buf))
+	index := br.index
+	br.index++
+
+	record = buf[4:]
+	if binary.LittleEndian.Uint32(buf) != crc32.Checksum(record, crc32Table) {
+		return record, &BombRecordError{Index: index, Offset: offset, Err: EPersistErrorBombChecksum}
+	}
+	return record, nil
+}
+
+// uvarintLen uvarint编码长度
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// IsBombChecksumError 是否为可跳过的记录校验错误
+func IsBombChecksumError(err error) bool {
+	return errors.Is(err, EPersistErrorBombChecksum)
+}
+
+// EncodeBomb 编码bomb文件
+func EncodeBomb(header BombHeader, records [][]byte) (data []byte, err error) {
+	var buf bytes.Buffer
+	header.Count = len(records)
+	bw, err := NewBombWriter(&buf, header)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if err = bw.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	if err = bw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeBomb 解码bomb文件, 任何记录损坏都返回错误
+func DecodeBomb(data []byte) (header BombHeader, records [][]byte, err error) {
+	br, err := NewBombReader(bytes.NewReader(data))
+	if err != nil {
+		return header, nil, err
+	}
+	defer br.Close()
+	for {
+		record, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return br.Header, nil, err
+		}
+		records = append(records, record)
+	}
+	if br.Header.Count >= 0 && br.Header.Count != len(records) {
+		return br.Header, nil, EPersistErrorInvalidBombFile
+	}
+	return br.Header, records, nil
+}
+
+// WriteBombFile 写入bomb文件, 先写临时文件再改名, 避免崩溃时留下不完整的bomb文件
+func WriteBombFile(path string, header BombHeader, records [][]byte) error {
+	data, err := EncodeBomb(header, records)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmpPath := strings.TrimSuffix(path, EBombFileExt) + ETmpFileExt
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,137 @@
+package persist_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestBombHeader_RoundTrip 测试文件头序列化和解析.
+func TestBombHeader_RoundTrip(t *testing.T) {
+	header := persist.BombHeader{
+		Name:  "MenusGlobal",
+		Kind:  persist.EBombKindTrace,
+		Count: 3,
+		Time:  time.Unix(1700000000, 0),
+	}
+
+	got, err := persist.ParseBombHeader(header.String())
+	if err != nil {
+		t.Fatalf("ParseBombHeader() error = %v", err)
+	}
+	if got.Name != header.Name || got.Kind != header.Kind || got.Count != header.Count || !got.Time.Equal(header.Time) {
+		t.Errorf("ParseBombHeader() = %+v, want %+v", got, header)
+	}
+}
+
+// TestParseBombHeader_Invalid 测试非法文件头.
+func TestParseBombHeader_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"persist:0;name=a",
+		"persist:1;kind=bomb",
+		"persist:1;name=a;count=x",
+		"persist:1;name",
+	}
+
+	for _, data := range tests {
+		if _, err := persist.ParseBombHeader(data); !errors.Is(err, persist.EPersistErrorInvalidBombFile) {
+			t.Errorf("ParseBombHeader(%q) error = %v, want %v", data, err, persist.EPersistErrorInvalidBombFile)
+		}
+	}
+}
+
+// TestEncodeDecodeBomb 测试编码解码bomb文件.
+func TestEncodeDecodeBomb(t *testing.T) {
+	records := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte{0xff}, 300)}
+	data, err := persist.EncodeBomb(persist.BombHeader{Name: "MenusGlobal"}, records)
+	if err != nil {
+		t.Fatalf("EncodeBomb() error = %v", err)
+	}
+
+	header, got, err := persist.DecodeBomb(data)
+	if err != nil {
+		t.Fatalf("DecodeBomb() error = %v", err)
+	}
+	if header.Name != "MenusGlobal" || header.Kind != persist.EBombKindBomb || header.Count != len(records) {
+		t.Errorf("DecodeBomb() header = %+v", header)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("DecodeBomb() records = %d, want %d", len(got), len(records))
+	}
+	for i := range records {
+		if !bytes.Equal(got[i], records[i]) {
+			t.Errorf("record %d = %x, want %x", i, got[i], records[i])
+		}
+	}
+}
+
+// TestBombReader_Checksum 测试记录校验失败后可以继续读取.
+func TestBombReader_Checksum(t *testing.T) {
+	data, err := persist.EncodeBomb(persist.BombHeader{Name: "MenusGlobal"}, [][]byte{[]byte("first"), []byte("second")})
+	if err != nil {
+		t.Fatalf("EncodeBomb() error = %v", err)
+	}
+	pos := bytes.Index(data, []byte("first"))
+	data[pos] = 'F'
+
+	br, err := persist.NewBombReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewBombReader() error = %v", err)
+	}
+	_, err = br.Next()
+	var recordErr *persist.BombRecordError
+	if !errors.As(err, &recordErr) || !persist.IsBombChecksumError(err) || recordErr.Index != 0 {
+		t.Fatalf("Next() error = %v, want checksum error on record 0", err)
+	}
+	record, err := br.Next()
+	if err != nil || string(record) != "second" {
+		t.Fatalf("Next() = %q, %v, want second", record, err)
+	}
+	if _, err = br.Next(); err != io.EOF {
+		t.Fatalf("Next() error = %v, want EOF", err)
+	}
+
+	if _, _, err = persist.DecodeBomb(data); !persist.IsBombChecksumError(err) {
+		t.Errorf("DecodeBomb() error = %v, want checksum error", err)
+	}
+}
+
+// TestDecodeBomb_Truncated 测试截断的文件.
+func TestDecodeBomb_Truncated(t *testing.T) {
+	data, err := persist.EncodeBomb(persist.BombHeader{Name: "MenusGlobal"}, [][]byte{[]byte("first"), []byte("second")})
+	if err != nil {
+		t.Fatalf("EncodeBomb() error = %v", err)
+	}
+
+	_, _, err = persist.DecodeBomb(data[:len(data)-2])
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("DecodeBomb() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+// TestWriteBombFile 测试写入bomb文件不留下临时文件.
+func TestWriteBombFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "MenusGlobal"+persist.EBombFileExt)
+
+	if err := persist.WriteBombFile(path, persist.BombHeader{Name: "MenusGlobal"}, [][]byte{[]byte("first")}); err != nil {
+		t.Fatalf("WriteBombFile() error = %v", err)
+	}
+	if persist.DirExists(filepath.Join(dir, "MenusGlobal"+persist.ETmpFileExt)) {
+		t.Error("temp file should be renamed")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if _, records, err := persist.DecodeBomb(data); err != nil || len(records) != 1 {
+		t.Errorf("DecodeBomb() = %d records, %v", len(records), err)
+	}
+}
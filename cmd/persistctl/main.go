@@ -0,0 +1,15 @@
+// Command persistctl 检查和处理 bomb/trace 文件.
+//
+// 该命令未注册任何模型, inspect 只能输出原始记录. 需要按模型解码或 replay 时,
+// 在业务仓库中复制本文件并在 Register 中注册对应的 persist.
+package main
+
+import (
+	"os"
+
+	"github.com/spelens-gud/persist/persistctl"
+)
+
+func main() {
+	os.Exit(persistctl.Main(os.Args[1:], nil))
+}
@@ -0,0 +1,55 @@
+// Command persistgen 为模型结构体生成字段序号, setter, 编解码和管理器代码.
+//
+//	//go:generate go run github.com/spelens-gud/persist/cmd/persistgen -type MenusGlobal
+//
+// 在模型所在目录执行, 为每个类型生成 <type_name>_persist.go.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spelens-gud/persist/persistgen"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of struct names; required")
+	dir := flag.String("dir", ".", "package directory")
+	output := flag.String("output", "", "output file name; default <dir>/<type_name>_persist.go, only for a single type")
+	flag.Parse()
+
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+	if *output != "" && len(types) > 1 {
+		fmt.Fprintln(os.Stderr, "persistgen: -output requires a single -type")
+		os.Exit(2)
+	}
+
+	for _, typeName := range types {
+		typeName = strings.TrimSpace(typeName)
+		model, err := persistgen.Parse(*dir, typeName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		src, err := persistgen.Generate(model)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		path := *output
+		if path == "" {
+			path = filepath.Join(*dir, persistgen.OutputName(typeName))
+		}
+		if err = os.WriteFile(path, src, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,868 @@
+package persist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// 结构体二进制编码
+//
+//	flag(1字节) + uvarint(字段数量) + [位图] + 字段数据
+//
+// flag 含 EMarshalFlagBitSet 时紧跟 uvarint(位图长度) + 位图(每个单元8字节小端), 只写入被标记的字段.
+// 字段按声明顺序编码, 新增字段只能追加在结构体末尾, 旧数据缺少的字段解码为零值.
+//
+//	bool           1字节
+//	int*           zigzag varint
+//	uint*          uvarint
+//	float32/64     4/8字节小端
+//	string         uvarint(长度) + 数据
+//	[]T / map      uvarint(长度+1) + 元素, 0 表示 nil
+//	*T             1字节, 0 表示 nil, EMarshalFlagPoint 表示后跟元素
+//	time.Time      uvarint(长度) + UTC时间的 MarshalBinary + varint(偏移秒数) + 时区名
+//	struct         依次编码所有字段
+//
+// 编码计划按类型生成一次并缓存, 基本类型, 字符串和结构体字段通过字段偏移直接读写内存;
+// map 的编解码, 切片和指针解码时的内存分配仍通过反射完成.
+// 实现了 PersistFieldCodec 的类型(persistgen 生成)直接调用生成的代码, 编码格式相同.
+//
+// time.Time 按时区名还原 *time.Location: UTC, Local 和时区数据库中的时区解码为同一时区,
+// 其他时区(如 time.FixedZone)解码为同名同偏移的固定时区; 单调时钟读数不编码.
+
+const EPersistErrorInvalidData = PersistError("persist: invalid data") // 编解码错误: 数据损坏或与结构体不匹配
+
+var (
+	gStructCodecMap sync.Map // reflect.Type -> *structCodec
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// encodeFunc 编码 p 指向的值
+type encodeFunc func(buf []byte, p unsafe.Pointer) []byte
+
+// decodeFunc 解码到 p 指向的值
+type decodeFunc func(r *PersistDecoder, p unsafe.Pointer) error
+
+// fieldCodec 字段编解码
+type fieldCodec struct {
+	offset uintptr
+	encode encodeFunc
+	decode decodeFunc
+}
+
+// structCodec 结构体编码计划
+type structCodec struct {
+	fields []fieldCodec
+	err    error // 存在不支持的字段类型
+}
+
+// sliceHeader 切片内存布局
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+// PersistFieldCodec 按字段编解码, 由 persistgen 为模型生成, 字段序号与结构体声明顺序一致
+type PersistFieldCodec interface {
+	PersistFieldNum() int                                                 // 字段数量
+	PersistEncodeField(buf []byte, i GlobalFieldIndex) ([]byte, error)    // 编码第 i 个字段
+	PersistDecodeField(d *PersistDecoder, i GlobalFieldIndex) (err error) // 解码第 i 个字段
+}
+
+// PersistDecoder 解码读取
+type PersistDecoder struct {
+	data []byte
+	pos  int
+}
+
+// MarshalPersist 序列化结构体追加到 buf, bitSet 为 nil 或全部标记时写入所有字段
+func MarshalPersist[T any](buf []byte, cls *T, bitSet *GlobalBitSet[T]) ([]byte, error) {
+	if cls == nil {
+		return buf, EPersistErrorNil
+	}
+	fc, generated := any(cls).(PersistFieldCodec)
+	var codec *structCodec
+	var num int
+	if generated {
+		num = fc.PersistFieldNum()
+	} else {
+		var err error
+		if codec, err = GetGlobalMeta[T]().structCodec(); err != nil {
+			return buf, err
+		}
+		num = len(codec.fields)
+	}
+
+	var words []uint64
+	if bitSet != nil && !bitSetCovers(bitSet.set, num) {
+		words = bitSet.set
+	}
+	if words == nil {
+		buf = append(buf, 0)
+	} else {
+		buf = append(buf, EMarshalFlagBitSet)
+	}
+	buf = binary.AppendUvarint(buf, uint64(num))
+	if words != nil {
+		buf = binary.AppendUvarint(buf, uint64(len(words)))
+		for _, word := range words {
+			buf = binary.LittleEndian.AppendUint64(buf, word)
+		}
+	}
+
+	p := unsafe.Pointer(cls)
+	for i := 0; i < num; i++ {
+		if words != nil && !bitSetWordsGet(words, i) {
+			continue
+		}
+		if generated {
+			var err error
+			if buf, err = fc.PersistEncodeField(buf, GlobalFieldIndex(i)); err != nil {
+				return buf, err
+			}
+			continue
+		}
+		field := &codec.fields[i]
+		buf = field.encode(buf, unsafe.Add(p, field.offset))
+	}
+	return buf, nil
+}
+
+// UnmarshalPersist 反序列化到 cls, 返回写入时的位图, 未写入的字段保持原值
+func UnmarshalPersist[T any](data []byte, cls *T) (bitSet GlobalBitSet[T], err error) {
+	bitSet = InitGlobalBitSet[T]()
+	if cls == nil {
+		return bitSet, EPersistErrorNil
+	}
+	fc, generated := any(cls).(PersistFieldCodec)
+	var codec *structCodec
+	var fieldNum int
+	if generated {
+		fieldNum = fc.PersistFieldNum()
+	} else {
+		if codec, err = GetGlobalMeta[T]().structCodec(); err != nil {
+			return bitSet, err
+		}
+		fieldNum = len(codec.fields)
+	}
+
+	r := &PersistDecoder{data: data}
+	flag, err := r.byte()
+	if err != nil {
+		return bitSet, err
+	}
+	num, err := r.uvarint()
+	if err != nil {
+		return bitSet, err
+	}
+	if flag&EMarshalFlagBitSet != 0 {
+		length, err := r.length()
+		if err != nil {
+			return bitSet, err
+		}
+		words := make([]uint64, length)
+		for i := range words {
+			if words[i], err = r.uint64(); err != nil {
+				return bitSet, err
+			}
+		}
+		bitSet.ClearAll()
+		copy(bitSet.set, words)
+	} else {
+		bitSet.SetAll()
+	}
+
+	p := unsafe.Pointer(cls)
+	for i := 0; i < int(num); i++ {
+		if flag&EMarshalFlagBitSet != 0 && !bitSetWordsGet(bitSet.set, i) {
+			continue
+		}
+		if i >= fieldNum {
+			// 写入时的字段多于当前结构体, 无法跳过未知类型的字段
+			return bitSet, fmt.Errorf("%w: field %d not in %s", EPersistErrorInvalidData, i, reflect.TypeFor[T]())
+		}
+		if generated {
+			err = fc.PersistDecodeField(r, GlobalFieldIndex(i))
+		} else {
+			field := &codec.fields[i]
+			err = field.decode(r, unsafe.Add(p, field.offset))
+		}
+		if err != nil {
+			return bitSet, err
+		}
+	}
+	if r.pos != len(r.data) {
+		return bitSet, fmt.Errorf("%w: %d trailing bytes", EPersistErrorInvalidData, len(r.data)-r.pos)
+	}
+	return bitSet, nil
+}
+
+// bitSetCovers 位图是否标记了全部字段
+func bitSetCovers(words []uint64, num int) bool {
+	for i := 0; i < num; i++ {
+		if !bitSetWordsGet(words, i) {
+			return false
+		}
+	}
+	return true
+}
+
+// bitSetWordsGet 获取位图第 i 位
+func bitSetWordsGet(words []uint64, i int) bool {
+	word := i >> EGlobalLog2WordSize
+	if word >= len(words) {
+		return false
+	}
+	return words[word]&(1<<(uint(i)&(EGlobalWordSize-1))) != 0
+}
+
+// getStructCodec 获取结构体编码计划
+func getStructCodec(t reflect.Type) (*structCodec, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("persist: %s is not a struct", t)
+	}
+	if codec, ok := gStructCodecMap.Load(t); ok {
+		return codec.(*structCodec), codec.(*structCodec).err
+	}
+	building := make(map[reflect.Type]*structCodec)
+	codec := buildStructCodec(t, building)
+	actual, _ := gStructCodecMap.LoadOrStore(t, codec)
+	return actual.(*structCodec), actual.(*structCodec).err
+}
+
+// buildStructCodec 生成结构体编码计划, building 用于处理递归类型
+func buildStructCodec(t reflect.Type, building map[reflect.Type]*structCodec) *structCodec {
+	if codec, ok := building[t]; ok {
+		return codec
+	}
+	codec := &structCodec{fields: make([]fieldCodec, t.NumField())}
+	building[t] = codec
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		encode, decode, err := buildCodec(field.Type, building)
+		if err != nil {
+			codec.err = fmt.Errorf("persist: field %s.%s: %w", t, field.Name, err)
+			return codec
+		}
+		codec.fields[i] = fieldCodec{offset: field.Offset, encode: encode, decode: decode}
+	}
+	return codec
+}
+
+// buildCodec 生成类型的编解码函数
+func buildCodec(t reflect.Type, building map[reflect.Type]*structCodec) (encodeFunc, decodeFunc, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return encodeBool, decodeBool, nil
+	case reflect.Int:
+		return encodeInt[int], decodeInt[int], nil
+	case reflect.Int8:
+		return encodeInt[int8], decodeInt[int8], nil
+	case reflect.Int16:
+		return encodeInt[int16], decodeInt[int16], nil
+	case reflect.Int32:
+		return encodeInt[int32], decodeInt[int32], nil
+	case reflect.Int64:
+		return encodeInt[int64], decodeInt[int64], nil
+	case reflect.Uint:
+		return encodeUint[uint], decodeUint[uint], nil
+	case reflect.Uint8:
+		return encodeUint[uint8], decodeUint[uint8], nil
+	case reflect.Uint16:
+		return encodeUint[uint16], decodeUint[uint16], nil
+	case reflect.Uint32:
+		return encodeUint[uint32], decodeUint[uint32], nil
+	case reflect.Uint64:
+		return encodeUint[uint64], decodeUint[uint64], nil
+	case reflect.Uintptr:
+		return encodeUint[uintptr], decodeUint[uintptr], nil
+	case reflect.Float32:
+		return encodeFloat32, decodeFloat32, nil
+	case reflect.Float64:
+		return encodeFloat64, decodeFloat64, nil
+	case reflect.String:
+		return encodeString, decodeString, nil
+	case reflect.Pointer:
+		return buildPointerCodec(t, building)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return encodeBytes, decodeBytes, nil
+		}
+		return buildSliceCodec(t, building)
+	case reflect.Array:
+		return buildArrayCodec(t, building)
+	case reflect.Map:
+		return buildMapCodec(t, building)
+	case reflect.Struct:
+		if t == timeType {
+			return encodeTime, decodeTime, nil
+		}
+		codec := buildStructCodec(t, building)
+		if codec.err != nil {
+			return nil, nil, codec.err
+		}
+		encode := func(buf []byte, p unsafe.Pointer) []byte {
+			for i := range codec.fields {
+				field := &codec.fields[i]
+				buf = field.encode(buf, unsafe.Add(p, field.offset))
+			}
+			return buf
+		}
+		decode := func(r *PersistDecoder, p unsafe.Pointer) error {
+			for i := range codec.fields {
+				field := &codec.fields[i]
+				if err := field.decode(r, unsafe.Add(p, field.offset)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return encode, decode, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// buildPointerCodec 指针编解码
+func buildPointerCodec(t reflect.Type, building map[reflect.Type]*structCodec) (encodeFunc, decodeFunc, error) {
+	elem := t.Elem()
+	// 递归类型在元素编码计划完成前就需要引用, 延迟到调用时获取
+	var elemEncode encodeFunc
+	var elemDecode decodeFunc
+	var elemErr error
+	var once sync.Once
+	resolve := func() {
+		once.Do(func() {
+			elemEncode, elemDecode, elemErr = buildCodec(elem, building)
+		})
+	}
+	if _, ok := building[elem]; !ok {
+		resolve()
+		if elemErr != nil {
+			return nil, nil, elemErr
+		}
+	}
+
+	encode := func(buf []byte, p unsafe.Pointer) []byte {
+		ptr := *(*unsafe.Pointer)(p)
+		if ptr == nil {
+			return append(buf, 0)
+		}
+		resolve()
+		if elemErr != nil {
+			return append(buf, 0)
+		}
+		return elemEncode(append(buf, EMarshalFlagPoint), ptr)
+	}
+	decode := func(r *PersistDecoder, p unsafe.Pointer) error {
+		flag, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if flag&EMarshalFlagPoint == 0 {
+			*(*unsafe.Pointer)(p) = nil
+			return nil
+		}
+		resolve()
+		if elemErr != nil {
+			return elemErr
+		}
+		ptr := reflect.New(elem).UnsafePointer()
+		if err = elemDecode(r, ptr); err != nil {
+			return err
+		}
+		*(*unsafe.Pointer)(p) = ptr
+		return nil
+	}
+	return encode, decode, nil
+}
+
+// buildSliceCodec 切片编解码
+func buildSliceCodec(t reflect.Type, building map[reflect.Type]*structCodec) (encodeFunc, decodeFunc, error) {
+	elemEncode, elemDecode, err := buildCodec(t.Elem(), building)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := t.Elem().Size()
+	encode := func(buf []byte, p unsafe.Pointer) []byte {
+		header := (*sliceHeader)(p)
+		if header.data == nil {
+			return append(buf, 0)
+		}
+		buf = binary.AppendUvarint(buf, uint64(header.len)+1)
+		for i := 0; i < header.len; i++ {
+			buf = elemEncode(buf, unsafe.Add(header.data, uintptr(i)*size))
+		}
+		return buf
+	}
+	decode := func(r *PersistDecoder, p unsafe.Pointer) error {
+		length, err := r.nilLength()
+		if err != nil {
+			return err
+		}
+		if length < 0 {
+			*(*sliceHeader)(p) = sliceHeader{}
+			return nil
+		}
+		slice := reflect.MakeSlice(t, length, length)
+		data := slice.UnsafePointer()
+		for i := 0; i < length; i++ {
+			if err = elemDecode(r, unsafe.Add(data, uintptr(i)*size)); err != nil {
+				return err
+			}
+		}
+		reflect.NewAt(t, p).Elem().Set(slice)
+		return nil
+	}
+	return encode, decode, nil
+}
+
+// buildArrayCodec 数组编解码
+func buildArrayCodec(t reflect.Type, building map[reflect.Type]*structCodec) (encodeFunc, decodeFunc, error) {
+	elemEncode, elemDecode, err := buildCodec(t.Elem(), building)
+	if err != nil {
+		return nil, nil, err
+	}
+	size, length := t.Elem().Size(), t.Len()
+	encode := func(buf []byte, p unsafe.Pointer) []byte {
+		for i := 0; i < length; i++ {
+			buf = elemEncode(buf, unsafe.Add(p, uintptr(i)*size))
+		}
+		return buf
+	}
+	decode := func(r *PersistDecoder, p unsafe.Pointer) error {
+		for i := 0; i < length; i++ {
+			if err := elemDecode(r, unsafe.Add(p, uintptr(i)*size)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return encode, decode, nil
+}
+
+// buildMapCodec map编解码, map内部结构不公开, 通过反射遍历
+func buildMapCodec(t reflect.Type, building map[reflect.Type]*structCodec) (encodeFunc, decodeFunc, error) {
+	keyEncode, keyDecode, err := buildCodec(t.Key(), building)
+	if err != nil {
+		return nil, nil, err
+	}
+	valueEncode, valueDecode, err := buildCodec(t.Elem(), building)
+	if err != nil {
+		return nil, nil, err
+	}
+	encode := func(buf []byte, p unsafe.Pointer) []byte {
+		m := reflect.NewAt(t, p).Elem()
+		if m.IsNil() {
+			return append(buf, 0)
+		}
+		buf = binary.AppendUvarint(buf, uint64(m.Len())+1)
+		key := reflect.New(t.Key()).Elem()
+		value := reflect.New(t.Elem()).Elem()
+		iter := m.MapRange()
+		for iter.Next() {
+			key.SetIterKey(iter)
+			value.SetIterValue(iter)
+			buf = keyEncode(buf, key.Addr().UnsafePointer())
+			buf = valueEncode(buf, value.Addr().UnsafePointer())
+		}
+		return buf
+	}
+	decode := func(r *PersistDecoder, p unsafe.Pointer) error {
+		length, err := r.nilLength()
+		if err != nil {
+			return err
+		}
+		m := reflect.NewAt(t, p).Elem()
+		if length < 0 {
+			m.SetZero()
+			return nil
+		}
+		m.Set(reflect.MakeMapWithSize(t, length))
+		for i := 0; i < length; i++ {
+			key := reflect.New(t.Key())
+			value := reflect.New(t.Elem())
+			if err = keyDecode(r, key.UnsafePointer()); err != nil {
+				return err
+			}
+			if err = valueDecode(r, value.UnsafePointer()); err != nil {
+				return err
+			}
+			m.SetMapIndex(key.Elem(), value.Elem())
+		}
+		return nil
+	}
+	return encode, decode, nil
+}
+
+// integer 有符号整数
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+// unsigned 无符号整数
+type unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+func encodeBool(buf []byte, p unsafe.Pointer) []byte {
+	if *(*bool)(p) {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+func decodeBool(r *PersistDecoder, p unsafe.Pointer) error {
+	b, err := r.byte()
+	*(*bool)(p) = b != 0
+	return err
+}
+
+func encodeInt[I integer](buf []byte, p unsafe.Pointer) []byte {
+	return binary.AppendVarint(buf, int64(*(*I)(p)))
+}
+
+func decodeInt[I integer](r *PersistDecoder, p unsafe.Pointer) error {
+	v, err := r.varint()
+	*(*I)(p) = I(v)
+	return err
+}
+
+func encodeUint[U unsigned](buf []byte, p unsafe.Pointer) []byte {
+	return binary.AppendUvarint(buf, uint64(*(*U)(p)))
+}
+
+func decodeUint[U unsigned](r *PersistDecoder, p unsafe.Pointer) error {
+	v, err := r.uvarint()
+	*(*U)(p) = U(v)
+	return err
+}
+
+func encodeFloat32(buf []byte, p unsafe.Pointer) []byte {
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(*(*float32)(p)))
+}
+
+func decodeFloat32(r *PersistDecoder, p unsafe.Pointer) error {
+	b, err := r.bytes(4)
+	if err != nil {
+		return err
+	}
+	*(*float32)(p) = math.Float32frombits(binary.LittleEndian.Uint32(b))
+	return nil
+}
+
+func encodeFloat64(buf []byte, p unsafe.Pointer) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(*(*float64)(p)))
+}
+
+func decodeFloat64(r *PersistDecoder, p unsafe.Pointer) error {
+	v, err := r.uint64()
+	*(*float64)(p) = math.Float64frombits(v)
+	return err
+}
+
+func encodeString(buf []byte, p unsafe.Pointer) []byte {
+	s := *(*string)(p)
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func decodeString(r *PersistDecoder, p unsafe.Pointer) error {
+	length, err := r.length()
+	if err != nil {
+		return err
+	}
+	b, err := r.bytes(length)
+	*(*string)(p) = string(b)
+	return err
+}
+
+func encodeBytes(buf []byte, p unsafe.Pointer) []byte {
+	b := *(*[]byte)(p)
+	if b == nil {
+		return append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(b))+1)
+	return append(buf, b...)
+}
+
+func decodeBytes(r *PersistDecoder, p unsafe.Pointer) error {
+	length, err := r.nilLength()
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		*(*[]byte)(p) = nil
+		return nil
+	}
+	b, err := r.bytes(length)
+	*(*[]byte)(p) = append([]byte{}, b...)
+	return err
+}
+
+func encodeTime(buf []byte, p unsafe.Pointer) []byte {
+	t := *(*time.Time)(p)
+	_, offset := t.Zone()
+	name := t.Location().String()
+	data, _ := t.UTC().MarshalBinary()
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	buf = append(buf, data...)
+	buf = binary.AppendVarint(buf, int64(offset))
+	buf = binary.AppendUvarint(buf, uint64(len(name)))
+	return append(buf, name...)
+}
+
+func decodeTime(r *PersistDecoder, p unsafe.Pointer) error {
+	length, err := r.length()
+	if err != nil {
+		return err
+	}
+	b, err := r.bytes(length)
+	if err != nil {
+		return err
+	}
+	var t time.Time
+	if err = t.UnmarshalBinary(b); err != nil {
+		return fmt.Errorf("%w: %v", EPersistErrorInvalidData, err)
+	}
+	offset, err := r.varint()
+	if err != nil {
+		return err
+	}
+	var name string
+	if err = decodeString(r, unsafe.Pointer(&name)); err != nil {
+		return err
+	}
+	*(*time.Time)(p) = t.In(timeLocation(t, name, int(offset)))
+	return nil
+}
+
+// timeLocation 按名字还原时区, 时区数据库中找不到或偏移不一致时使用同名的固定时区
+func timeLocation(t time.Time, name string, offset int) *time.Location {
+	switch name {
+	case "UTC":
+		if offset == 0 {
+			return time.UTC
+		}
+	case "Local":
+		if _, local := t.In(time.Local).Zone(); local == offset {
+			return time.Local
+		}
+	case "":
+	default:
+		if loc, err := time.LoadLocation(name); err == nil {
+			if _, zone := t.In(loc).Zone(); zone == offset {
+				return loc
+			}
+		}
+	}
+	return time.FixedZone(name, offset)
+}
+
+// byte 读取1字节
+func (r *PersistDecoder) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, EPersistErrorInvalidData
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+// bytes 读取 n 字节, 返回的切片引用原数据
+func (r *PersistDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, EPersistErrorInvalidData
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint64 读取8字节小端
+func (r *PersistDecoder) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// uvarint 读取uvarint
+func (r *PersistDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, EPersistErrorInvalidData
+	}
+	r.pos += n
+	return v, nil
+}
+
+// varint 读取zigzag varint
+func (r *PersistDecoder) varint() (int64, error) {
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, EPersistErrorInvalidData
+	}
+	r.pos += n
+	return v, nil
+}
+
+// length 读取长度, 不能超过剩余数据长度
+func (r *PersistDecoder) length() (int, error) {
+	v, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v > uint64(len(r.data)-r.pos) {
+		return 0, EPersistErrorInvalidData
+	}
+	return int(v), nil
+}
+
+// nilLength 读取 长度+1, 0 表示 nil 返回 -1
+func (r *PersistDecoder) nilLength() (int, error) {
+	v, err := r.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if v == 0 {
+		return -1, nil
+	}
+	// 每个元素至少占1字节, 防止损坏数据导致超大分配
+	if v-1 > uint64(len(r.data)-r.pos) {
+		return 0, EPersistErrorInvalidData
+	}
+	return int(v - 1), nil
+}
+
+// AppendPersistBool 编码bool, 供生成代码使用
+func AppendPersistBool(buf []byte, v bool) []byte {
+	return encodeBool(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistInt 编码有符号整数
+func AppendPersistInt(buf []byte, v int64) []byte {
+	return binary.AppendVarint(buf, v)
+}
+
+// AppendPersistUint 编码无符号整数
+func AppendPersistUint(buf []byte, v uint64) []byte {
+	return binary.AppendUvarint(buf, v)
+}
+
+// AppendPersistFloat32 编码float32
+func AppendPersistFloat32(buf []byte, v float32) []byte {
+	return encodeFloat32(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistFloat64 编码float64
+func AppendPersistFloat64(buf []byte, v float64) []byte {
+	return encodeFloat64(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistString 编码string
+func AppendPersistString(buf []byte, v string) []byte {
+	return encodeString(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistBytes 编码[]byte
+func AppendPersistBytes(buf []byte, v []byte) []byte {
+	return encodeBytes(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistTime 编码time.Time
+func AppendPersistTime(buf []byte, v time.Time) []byte {
+	return encodeTime(buf, unsafe.Pointer(&v))
+}
+
+// AppendPersistValue 按编码计划编码任意支持的类型, 生成代码用于切片, map, 指针和嵌套结构体
+func AppendPersistValue[V any](buf []byte, v *V) ([]byte, error) {
+	encode, _, err := getValueCodec(reflect.TypeFor[V]())
+	if err != nil {
+		return buf, err
+	}
+	return encode(buf, unsafe.Pointer(v)), nil
+}
+
+// DecodePersistValue 按编码计划解码任意支持的类型
+func DecodePersistValue[V any](d *PersistDecoder, v *V) error {
+	_, decode, err := getValueCodec(reflect.TypeFor[V]())
+	if err != nil {
+		return err
+	}
+	return decode(d, unsafe.Pointer(v))
+}
+
+// valueCodec 非结构体类型的编解码缓存
+type valueCodec struct {
+	encode encodeFunc
+	decode decodeFunc
+	err    error
+}
+
+var gValueCodecMap sync.Map // reflect.Type -> *valueCodec
+
+// getValueCodec 获取类型的编解码函数
+func getValueCodec(t reflect.Type) (encodeFunc, decodeFunc, error) {
+	if codec, ok := gValueCodecMap.Load(t); ok {
+		c := codec.(*valueCodec)
+		return c.encode, c.decode, c.err
+	}
+	c := &valueCodec{}
+	c.encode, c.decode, c.err = buildCodec(t, make(map[reflect.Type]*structCodec))
+	actual, _ := gValueCodecMap.LoadOrStore(t, c)
+	c = actual.(*valueCodec)
+	return c.encode, c.decode, c.err
+}
+
+// Bool 解码bool
+func (r *PersistDecoder) Bool() (v bool, err error) {
+	err = decodeBool(r, unsafe.Pointer(&v))
+	return
+}
+
+// Int 解码有符号整数
+func (r *PersistDecoder) Int() (int64, error) {
+	return r.varint()
+}
+
+// Uint 解码无符号整数
+func (r *PersistDecoder) Uint() (uint64, error) {
+	return r.uvarint()
+}
+
+// Float32 解码float32
+func (r *PersistDecoder) Float32() (v float32, err error) {
+	err = decodeFloat32(r, unsafe.Pointer(&v))
+	return
+}
+
+// Float64 解码float64
+func (r *PersistDecoder) Float64() (v float64, err error) {
+	err = decodeFloat64(r, unsafe.Pointer(&v))
+	return
+}
+
+// String 解码string
+func (r *PersistDecoder) String() (v string, err error) {
+	err = decodeString(r, unsafe.Pointer(&v))
+	return
+}
+
+// Bytes 解码[]byte, 返回的切片不引用原数据
+func (r *PersistDecoder) Bytes() (v []byte, err error) {
+	err = decodeBytes(r, unsafe.Pointer(&v))
+	return
+}
+
+// Time 解码time.Time
+func (r *PersistDecoder) Time() (v time.Time, err error) {
+	err = decodeTime(r, unsafe.Pointer(&v))
+	return
+}
@@ -0,0 +1,208 @@
+package persist_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/spelens-gud/persist"
+)
+
+type codecInner struct {
+	Code  int32
+	Label string
+}
+
+type codecNode struct {
+	Value int
+	Next  *codecNode
+}
+
+type codecModel struct {
+	Id      int64
+	Name    string
+	Active  bool
+	Score   float64
+	Ratio   float32
+	Count   uint16
+	Delta   int8
+	Raw     []byte
+	Tags    []string
+	Attrs   map[string]int
+	Inner   codecInner
+	Ptr     *codecInner
+	Nums    [3]int
+	Created time.Time
+	Node    *codecNode
+}
+
+// newCodecModel 创建测试数据
+func newCodecModel() *codecModel {
+	return &codecModel{
+		Id:      42,
+		Name:    "菜单",
+		Active:  true,
+		Score:   3.5,
+		Ratio:   -0.25,
+		Count:   65535,
+		Delta:   -128,
+		Raw:     []byte{0, 1, 2},
+		Tags:    []string{"a", "", "c"},
+		Attrs:   map[string]int{"x": 1, "y": -2},
+		Inner:   codecInner{Code: -7, Label: "inner"},
+		Ptr:     &codecInner{Code: 9},
+		Nums:    [3]int{1, -1, 1 << 40},
+		Created: time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Node:    &codecNode{Value: 1, Next: &codecNode{Value: 2}},
+	}
+}
+
+// TestMarshalPersist_RoundTrip 测试全部字段序列化和反序列化.
+func TestMarshalPersist_RoundTrip(t *testing.T) {
+	src := newCodecModel()
+	data, err := persist.MarshalPersist(nil, src, nil)
+	if err != nil {
+		t.Fatalf("MarshalPersist() error = %v", err)
+	}
+
+	dst := new(codecModel)
+	bitSet, err := persist.UnmarshalPersist(data, dst)
+	if err != nil {
+		t.Fatalf("UnmarshalPersist() error = %v", err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Errorf("UnmarshalPersist() = %+v, want %+v", dst, src)
+	}
+	if !bitSet.IsSetAll() {
+		t.Error("bitSet should mark all fields")
+	}
+}
+
+// TestMarshalPersist_Nil 测试nil切片, map和指针.
+func TestMarshalPersist_Nil(t *testing.T) {
+	src := &codecModel{Id: 1, Tags: []string{}}
+	data, err := persist.MarshalPersist(nil, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newCodecModel()
+	if _, err = persist.UnmarshalPersist(data, dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Raw != nil || dst.Attrs != nil || dst.Ptr != nil || dst.Node != nil {
+		t.Errorf("nil fields decoded as %+v", dst)
+	}
+	if dst.Tags == nil || len(dst.Tags) != 0 {
+		t.Errorf("empty slice decoded as %#v", dst.Tags)
+	}
+}
+
+// TestMarshalPersist_BitSet 测试只序列化位图标记的字段.
+func TestMarshalPersist_BitSet(t *testing.T) {
+	src := newCodecModel()
+	bitSet := persist.InitGlobalBitSet[codecModel]()
+	bitSet.Set(0).Set(1)
+
+	data, err := persist.MarshalPersist(nil, src, &bitSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, _ := persist.MarshalPersist(nil, src, nil)
+	if len(data) >= len(full) {
+		t.Errorf("partial data %d bytes, full data %d bytes", len(data), len(full))
+	}
+
+	dst := &codecModel{Score: 1}
+	got, err := persist.UnmarshalPersist(data, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Id != src.Id || dst.Name != src.Name || dst.Score != 1 || dst.Tags != nil {
+		t.Errorf("UnmarshalPersist() = %+v", dst)
+	}
+	if !got.Get(0) || !got.Get(1) || got.Get(2) {
+		t.Error("decoded bitSet mismatch")
+	}
+}
+
+// TestMarshalPersist_TimeLocation 测试时间字段保留时区.
+func TestMarshalPersist_TimeLocation(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type timeModel struct {
+		At time.Time
+	}
+
+	tests := []*time.Location{time.UTC, time.Local, shanghai, time.FixedZone("GAME", 5*3600+30*60)}
+	for _, loc := range tests {
+		src := &timeModel{At: time.Date(2024, 5, 6, 7, 8, 9, 10, loc)}
+		data, err := persist.MarshalPersist(nil, src, nil)
+		if err != nil {
+			t.Fatalf("MarshalPersist(%s) error = %v", loc, err)
+		}
+		dst := new(timeModel)
+		if _, err = persist.UnmarshalPersist(data, dst); err != nil {
+			t.Fatalf("UnmarshalPersist(%s) error = %v", loc, err)
+		}
+		if !dst.At.Equal(src.At) || dst.At.Location().String() != loc.String() || dst.At.String() != src.At.String() {
+			t.Errorf("UnmarshalPersist(%s) = %v, want %v", loc, dst.At, src.At)
+		}
+		if (loc == time.UTC || loc == time.Local) && dst.At.Location() != loc {
+			t.Errorf("UnmarshalPersist(%s) location = %p, want %p", loc, dst.At.Location(), loc)
+		}
+	}
+}
+
+// TestUnmarshalPersist_Invalid 测试损坏的数据.
+func TestUnmarshalPersist_Invalid(t *testing.T) {
+	data, err := persist.MarshalPersist(nil, newCodecModel(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := [][]byte{
+		nil,
+		data[:len(data)/2],
+		append(append([]byte(nil), data...), 0),
+	}
+	for _, tt := range tests {
+		if _, err = persist.UnmarshalPersist(tt, new(codecModel)); !errors.Is(err, persist.EPersistErrorInvalidData) {
+			t.Errorf("UnmarshalPersist(%d bytes) error = %v", len(tt), err)
+		}
+	}
+}
+
+// TestMarshalPersist_Unsupported 测试不支持的字段类型.
+func TestMarshalPersist_Unsupported(t *testing.T) {
+	type model struct {
+		Fn func()
+	}
+	if _, err := persist.MarshalPersist(nil, &model{}, nil); err == nil {
+		t.Error("MarshalPersist() should fail on func field")
+	}
+}
+
+// BenchmarkMarshalPersist 序列化性能.
+func BenchmarkMarshalPersist(b *testing.B) {
+	src := newCodecModel()
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf, _ = persist.MarshalPersist(buf[:0], src, nil)
+	}
+}
+
+// BenchmarkUnmarshalPersist 反序列化性能.
+func BenchmarkUnmarshalPersist(b *testing.B) {
+	data, _ := persist.MarshalPersist(nil, newCodecModel(), nil)
+	dst := new(codecModel)
+	b.ReportAllocs()
+	for b.Loop() {
+		_, _ = persist.UnmarshalPersist(data, dst)
+	}
+}
@@ -0,0 +1,110 @@
+package persist
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// BombCompression bomb/trace 文件数据区压缩算法
+type BombCompression string
+
+const (
+	EBombCompressionNone   BombCompression = "none"   // 不压缩
+	EBombCompressionSnappy BombCompression = "snappy" // snappy 分帧格式, 速度优先
+	EBombCompressionZstd   BombCompression = "zstd"   // zstd, 压缩率优先
+)
+
+var (
+	gBombCompressionMu      sync.RWMutex
+	gBombCompressionDefault = EBombCompressionNone             // 未单独配置的persist使用的压缩算法
+	gBombCompressionMap     = make(map[string]BombCompression) // 按persist名配置的压缩算法
+)
+
+// SetDefaultBombCompression 设置默认压缩算法
+func SetDefaultBombCompression(compression BombCompression) error {
+	if !compression.valid() {
+		return fmt.Errorf("%w: unknown compression %q", EPersistErrorInvalidBombFile, compression)
+	}
+	gBombCompressionMu.Lock()
+	defer gBombCompressionMu.Unlock()
+	gBombCompressionDefault = compression
+	return nil
+}
+
+// SetBombCompression 设置persist写入bomb/trace文件时使用的压缩算法
+func SetBombCompression(name string, compression BombCompression) error {
+	if !compression.valid() {
+		return fmt.Errorf("%w: unknown compression %q", EPersistErrorInvalidBombFile, compression)
+	}
+	gBombCompressionMu.Lock()
+	defer gBombCompressionMu.Unlock()
+	gBombCompressionMap[name] = compression
+	return nil
+}
+
+// GetBombCompression 获取persist写入bomb/trace文件时使用的压缩算法
+func GetBombCompression(name string) BombCompression {
+	gBombCompressionMu.RLock()
+	defer gBombCompressionMu.RUnlock()
+	if compression, ok := gBombCompressionMap[name]; ok {
+		return compression
+	}
+	return gBombCompressionDefault
+}
+
+// valid 是否为支持的压缩算法
+func (c BombCompression) valid() bool {
+	switch c {
+	case "", EBombCompressionNone, EBombCompressionSnappy, EBombCompressionZstd:
+		return true
+	default:
+		return false
+	}
+}
+
+// newCompressWriter 创建压缩层, 不压缩时返回 nil
+func newCompressWriter(w io.Writer, compression BombCompression) (io.WriteCloser, error) {
+	switch compression {
+	case "", EBombCompressionNone:
+		return nil, nil
+	case EBombCompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	case EBombCompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %q", EPersistErrorInvalidBombFile, compression)
+	}
+}
+
+// newDecompressReader 创建解压层, 不压缩时返回 nil
+func newDecompressReader(r io.Reader, compression BombCompression) (io.ReadCloser, error) {
+	switch compression {
+	case "", EBombCompressionNone:
+		return nil, nil
+	case EBombCompressionSnappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	case EBombCompressionZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{decoder}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown compression %q", EPersistErrorInvalidBombFile, compression)
+	}
+}
+
+// zstdReadCloser 适配 zstd.Decoder 的 Close
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+// Close 释放解码器
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,67 @@
+package persist_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestBombCompression_RoundTrip 测试各压缩算法编码解码.
+func TestBombCompression_RoundTrip(t *testing.T) {
+	records := make([][]byte, 200)
+	for i := range records {
+		records[i] = bytes.Repeat([]byte{byte(i)}, 512)
+	}
+	plain, err := persist.EncodeBomb(persist.BombHeader{Name: "MenusGlobal"}, records)
+	if err != nil {
+		t.Fatalf("EncodeBomb() error = %v", err)
+	}
+
+	for _, compression := range []persist.BombCompression{persist.EBombCompressionSnappy, persist.EBombCompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			data, err := persist.EncodeBomb(persist.BombHeader{Name: "MenusGlobal", Compression: compression}, records)
+			if err != nil {
+				t.Fatalf("EncodeBomb() error = %v", err)
+			}
+			if len(data) >= len(plain) {
+				t.Errorf("compressed size %d, plain size %d", len(data), len(plain))
+			}
+
+			header, got, err := persist.DecodeBomb(data)
+			if err != nil {
+				t.Fatalf("DecodeBomb() error = %v", err)
+			}
+			if header.Compression != compression {
+				t.Errorf("header compression = %q, want %q", header.Compression, compression)
+			}
+			if len(got) != len(records) || !bytes.Equal(got[len(got)-1], records[len(records)-1]) {
+				t.Errorf("DecodeBomb() returned %d records", len(got))
+			}
+		})
+	}
+}
+
+// TestBombCompression_Unknown 测试未知压缩算法.
+func TestBombCompression_Unknown(t *testing.T) {
+	if err := persist.SetBombCompression("MenusGlobal", "lz4"); !errors.Is(err, persist.EPersistErrorInvalidBombFile) {
+		t.Errorf("SetBombCompression() error = %v", err)
+	}
+	if _, _, err := persist.DecodeBomb([]byte("persist:1;name=MenusGlobal;codec=lz4 ")); !errors.Is(err, persist.EPersistErrorInvalidBombFile) {
+		t.Errorf("DecodeBomb() error = %v", err)
+	}
+}
+
+// TestGetBombCompression 测试按persist配置压缩算法.
+func TestGetBombCompression(t *testing.T) {
+	if err := persist.SetBombCompression("CompressGlobal", persist.EBombCompressionZstd); err != nil {
+		t.Fatal(err)
+	}
+	if got := persist.GetBombCompression("CompressGlobal"); got != persist.EBombCompressionZstd {
+		t.Errorf("GetBombCompression() = %q", got)
+	}
+	if got := persist.GetBombCompression("OtherGlobal"); got != persist.EBombCompressionNone {
+		t.Errorf("GetBombCompression() default = %q", got)
+	}
+}
@@ -76,16 +76,22 @@ func ChangeRegister(persist IPersist) {
 	}
 }
 
-// GetIPersistByName 通过名字获取persist
+// GetIPersistByName 通过名字获取persist, 分片管理器的分片按分片名查找
 func GetIPersistByName(name string) IPersist {
 	if persist, ok := gPersistMap[name]; ok {
 		return persist
-	} else {
-		return nil
 	}
+	for _, persist := range gPersistMap {
+		if sharded, ok := persist.(interface{ ShardByName(name string) IPersist }); ok {
+			if shard := sharded.ShardByName(name); shard != nil {
+				return shard
+			}
+		}
+	}
+	return nil
 }
 
-// Load 按照用户uid导入
+// Load 按照用户uid导入, 设置空闲导出时记录访问
 func Load(uid int32) (err error) {
 	for _, persist := range gPersistUserMap {
 		err = persist.Load(uid)
@@ -93,19 +99,32 @@ func Load(uid int32) (err error) {
 			return errors.New(persist.PersistName() + err.Error())
 		}
 	}
+	TouchUser(uid)
 	return
 }
 
-// SetLoadState2Memory 确定数据一致性前提下，强制设置用户数据已导入
+// SetLoadState2Memory 确定数据一致性前提下，强制设置用户数据已导入, 设置空闲导出时记录访问
 func SetLoadState2Memory(uid int32) {
 	for _, persist := range gPersistUserMap {
 		persist.SetLoadState2Memory(uid)
 	}
+	TouchUser(uid)
 	return
 }
 
-// Unload 按照用户uid导出
+// Unload 按照用户uid导出, 成功后不再记录访问
 func Unload(uid int32) (err error) {
+	if err = unloadUser(uid); err != nil {
+		return err
+	}
+	if u := gIdleUnloader.Load(); u != nil {
+		u.Forget(uid)
+	}
+	return
+}
+
+// unloadUser 导出所有用户persist中用户uid的数据
+func unloadUser(uid int32) (err error) {
 	for _, persist := range gPersistUserMap {
 		err = persist.Unload(uid)
 		if err != nil {
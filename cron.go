@@ -0,0 +1,128 @@
+package persist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const eCronSearchYears = 5 // 查找下一次执行时间的最大年数, 如 2月30日 永远不会执行
+
+// CronSchedule cron 表达式, 5段: 分 时 日 月 周
+// 每段支持 *, 数值, 范围 a-b, 步长 */n 和 a-b/n, 以及逗号分隔的列表; 周的 0 和 7 都表示周日
+// 日和周都不以 * 开头时满足其一即执行, 否则需要同时满足, 与标准 cron 一致
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// cronDescriptors 预定义的表达式
+var cronDescriptors = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// ParseCron 解析 cron 表达式, 格式错误时返回 EPersistErrorInvalidConfig
+func ParseCron(expr string) (*CronSchedule, error) {
+	if spec, ok := cronDescriptors[strings.TrimSpace(expr)]; ok {
+		expr = spec
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: cron %q needs 5 fields", EPersistErrorInvalidConfig, expr)
+	}
+	s := &CronSchedule{domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*")}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: cron %q: %v", EPersistErrorInvalidConfig, expr, err)
+		}
+		*sets[i] = set
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField 解析一段, 返回取值的位图
+func parseCronField(field string, low, high int) (set uint64, err error) {
+	for part := range strings.SplitSeq(field, ",") {
+		expr, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q", part)
+			}
+		}
+		begin, end := low, high
+		switch {
+		case expr == "*":
+		case strings.Contains(expr, "-"):
+			a, b, _ := strings.Cut(expr, "-")
+			if begin, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+			if end, err = strconv.Atoi(b); err != nil {
+				return 0, fmt.Errorf("bad range %q", part)
+			}
+		default:
+			if begin, err = strconv.Atoi(expr); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			end = begin
+			if hasStep {
+				end = high
+			}
+		}
+		if begin < low || end > high || begin > end {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, low, high)
+		}
+		for v := begin; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next t 之后的下一次执行时间, 精确到分钟, 找不到时返回零值
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(eCronSearchYears, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// matchDay 日期是否满足日和周
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
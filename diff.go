@@ -0,0 +1,137 @@
+package persist
+
+import (
+	"math"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// equalFunc 比较 a, b 指向的字段值是否相同
+type equalFunc func(a, b unsafe.Pointer) bool
+
+// diffFuncs 字段比较函数, 按字段序号
+func (m *GlobalMeta) diffFuncs() []equalFunc {
+	m.diffOnce.Do(func() {
+		m.diff = make([]equalFunc, m.FieldNum)
+		for i, t := range m.FieldTypes {
+			m.diff[i] = buildEqual(t)
+		}
+	})
+	return m.diff
+}
+
+// DiffPersist 比较两个快照, 返回值不同的字段位图
+// 基础类型和不含指针的可比较类型直接比较内存, 切片, map, 指针等深度比较
+func DiffPersist[T any](old, cur *T) GlobalBitSet[T] {
+	bitSet := InitGlobalBitSet[T]()
+	if old == nil || cur == nil {
+		bitSet.SetAll()
+		return bitSet
+	}
+	meta := GetGlobalMeta[T]()
+	a, b := unsafe.Pointer(old), unsafe.Pointer(cur)
+	for i, equal := range meta.diffFuncs() {
+		offset := meta.Offsets[i]
+		if !equal(unsafe.Add(a, offset), unsafe.Add(b, offset)) {
+			bitSet.Set(GlobalFieldIndex(i))
+		}
+	}
+	return bitSet
+}
+
+// ClonePersist 深拷贝, 切片, map 和指针指向的数据不与原对象共享
+func ClonePersist[T any](cls *T) (*T, error) {
+	data, err := MarshalPersist(nil, cls, nil)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(T)
+	if _, err = UnmarshalPersist(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// buildEqual 生成类型的比较函数
+func buildEqual(t reflect.Type) equalFunc {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return memEqual(t.Size())
+	case reflect.Float32:
+		return func(a, b unsafe.Pointer) bool {
+			x, y := *(*float32)(a), *(*float32)(b)
+			return x == y || (math.IsNaN(float64(x)) && math.IsNaN(float64(y)))
+		}
+	case reflect.Float64:
+		return func(a, b unsafe.Pointer) bool {
+			x, y := *(*float64)(a), *(*float64)(b)
+			return x == y || (math.IsNaN(x) && math.IsNaN(y))
+		}
+	case reflect.String:
+		return func(a, b unsafe.Pointer) bool {
+			return *(*string)(a) == *(*string)(b)
+		}
+	case reflect.Struct:
+		if t == timeType {
+			return func(a, b unsafe.Pointer) bool {
+				return (*time.Time)(a).Equal(*(*time.Time)(b))
+			}
+		}
+		if plainMemory(t) {
+			return memEqual(t.Size())
+		}
+		fields := make([]equalFunc, t.NumField())
+		offsets := make([]uintptr, t.NumField())
+		for i := range fields {
+			fields[i] = buildEqual(t.Field(i).Type)
+			offsets[i] = t.Field(i).Offset
+		}
+		return func(a, b unsafe.Pointer) bool {
+			for i, equal := range fields {
+				if !equal(unsafe.Add(a, offsets[i]), unsafe.Add(b, offsets[i])) {
+					return false
+				}
+			}
+			return true
+		}
+	case reflect.Array:
+		if plainMemory(t) {
+			return memEqual(t.Size())
+		}
+	}
+	// 切片, map, 指针, 接口等深度比较
+	return func(a, b unsafe.Pointer) bool {
+		return reflect.DeepEqual(reflect.NewAt(t, a).Elem().Interface(), reflect.NewAt(t, b).Elem().Interface())
+	}
+}
+
+// memEqual 按内存比较
+func memEqual(size uintptr) equalFunc {
+	return func(a, b unsafe.Pointer) bool {
+		return string(unsafe.Slice((*byte)(a), size)) == string(unsafe.Slice((*byte)(b), size))
+	}
+}
+
+// plainMemory 类型是否可以按内存比较: 只包含整数和bool, 没有填充字节
+func plainMemory(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	case reflect.Array:
+		return plainMemory(t.Elem())
+	case reflect.Struct:
+		var size uintptr
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.Offset != size || !plainMemory(field.Type) {
+				return false
+			}
+			size += field.Type.Size()
+		}
+		return size == t.Size()
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package persist_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestDiffPersist 测试快照比较.
+func TestDiffPersist(t *testing.T) {
+	old := newCodecModel()
+	old.Score = math.NaN()
+	cur, err := persist.ClonePersist(old)
+	if err != nil {
+		t.Fatalf("ClonePersist() error = %v", err)
+	}
+	if bitSet := persist.DiffPersist(old, cur); bitSet.Count() != 0 {
+		t.Fatalf("clone diff = %d fields", bitSet.Count())
+	}
+
+	// 深拷贝不共享切片和map
+	cur.Tags[0] = "changed"
+	cur.Attrs["x"] = 100
+	cur.Inner.Label = "changed"
+	cur.Node.Next.Value = 3
+	cur.Nums[1] = 0
+	cur.Name = old.Name
+	if old.Tags[0] != "a" || old.Attrs["x"] != 1 || old.Node.Next.Value != 2 {
+		t.Fatal("ClonePersist() shares memory with source")
+	}
+
+	bitSet := persist.DiffPersist(old, cur)
+	var got []persist.GlobalFieldIndex
+	for i := range bitSet.Fields() {
+		got = append(got, i)
+	}
+	want := []persist.GlobalFieldIndex{8, 9, 10, 12, 14}
+	if len(got) != len(want) {
+		t.Fatalf("DiffPersist() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("DiffPersist() = %v, want %v", got, want)
+		}
+	}
+
+	if all := persist.DiffPersist(nil, cur); all.Count() != persist.GetGlobalMeta[codecModel]().FieldNum {
+		t.Error("DiffPersist() with nil snapshot should mark all fields")
+	}
+}
+
+// BenchmarkDiffPersist 快照比较性能.
+func BenchmarkDiffPersist(b *testing.B) {
+	old := newCodecModel()
+	cur, _ := persist.ClonePersist(old)
+	cur.Name = "changed"
+	b.ReportAllocs()
+	for b.Loop() {
+		persist.DiffPersist(old, cur)
+	}
+}
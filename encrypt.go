@@ -0,0 +1,345 @@
+package persist
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// BombEncryption bomb/trace 文件数据区加密算法
+type BombEncryption string
+
+const (
+	EBombEncryptionNone   BombEncryption = ""        // 不加密
+	EBombEncryptionAESGCM BombEncryption = "aes-gcm" // AES-GCM 分段加密
+
+	eBombSegmentSize = 64 << 10 // 每段明文长度
+	eBombNonceSize   = 8        // 文件头中随机nonce前缀长度, 后4字节为段序号
+
+	EBombKeyEnvPrefix = "PERSIST_BOMB_KEY" // 环境变量密钥前缀
+)
+
+const EPersistErrorKeyMissing = PersistError("persist: bomb key missing") // 启动关闭错误: 无法获取解密bomb文件的密钥
+
+// BombKeyError 获取密钥失败
+type BombKeyError struct {
+	KeyID string // 密钥ID
+	Err   error  // 底层错误
+}
+
+// Error 实现 error 接口
+func (e *BombKeyError) Error() string {
+	return fmt.Sprintf("bomb key %q: %v", e.KeyID, e.Err)
+}
+
+// Unwrap 返回底层错误
+func (e *BombKeyError) Unwrap() error {
+	return e.Err
+}
+
+// KeyProvider bomb/trace 文件密钥来源, 密钥长度为 16/24/32 字节
+// 新文件使用 CurrentKeyID 对应的密钥加密, 文件头记录密钥ID, 旧密钥保留在 Key 中即可轮换
+type KeyProvider interface {
+	CurrentKeyID() (keyID string, err error)  // 加密使用的密钥ID
+	Key(keyID string) (key []byte, err error) // 按密钥ID获取密钥
+}
+
+var (
+	gBombKeyProviderMu sync.RWMutex
+	gBombKeyProvider   KeyProvider // 设置后新写入的文件全部加密
+)
+
+// SetBombKeyProvider 设置密钥来源, nil 表示新文件不加密
+func SetBombKeyProvider(provider KeyProvider) {
+	gBombKeyProviderMu.Lock()
+	defer gBombKeyProviderMu.Unlock()
+	gBombKeyProvider = provider
+}
+
+// GetBombKeyProvider 获取密钥来源
+func GetBombKeyProvider() KeyProvider {
+	gBombKeyProviderMu.RLock()
+	defer gBombKeyProviderMu.RUnlock()
+	return gBombKeyProvider
+}
+
+// EnvKeyProvider 从环境变量读取密钥
+// <Prefix>_ID 为当前密钥ID, <Prefix>_<ID> 为 hex 或 base64 编码的密钥
+type EnvKeyProvider struct {
+	Prefix string
+}
+
+// CurrentKeyID 当前密钥ID
+func (p EnvKeyProvider) CurrentKeyID() (string, error) {
+	keyID := os.Getenv(p.prefix() + "_ID")
+	if keyID == "" {
+		return "", &BombKeyError{Err: fmt.Errorf("%w: %s_ID not set", EPersistErrorKeyMissing, p.prefix())}
+	}
+	return keyID, nil
+}
+
+// Key 按密钥ID获取密钥
+func (p EnvKeyProvider) Key(keyID string) ([]byte, error) {
+	value := os.Getenv(p.prefix() + "_" + strings.ToUpper(keyID))
+	if value == "" {
+		return nil, &BombKeyError{KeyID: keyID, Err: EPersistErrorKeyMissing}
+	}
+	return parseBombKey(keyID, value)
+}
+
+// prefix 环境变量前缀
+func (p EnvKeyProvider) prefix() string {
+	if p.Prefix == "" {
+		return EBombKeyEnvPrefix
+	}
+	return p.Prefix
+}
+
+// FileKeyProvider 从目录读取密钥, 每个密钥一个文件 <Dir>/<ID>.key, 内容为 hex 或 base64 编码的密钥
+type FileKeyProvider struct {
+	Dir     string // 密钥目录
+	Current string // 当前密钥ID
+}
+
+// CurrentKeyID 当前密钥ID
+func (p FileKeyProvider) CurrentKeyID() (string, error) {
+	if p.Current == "" {
+		return "", &BombKeyError{Err: fmt.Errorf("%w: no current key", EPersistErrorKeyMissing)}
+	}
+	return p.Current, nil
+}
+
+// Key 按密钥ID获取密钥
+func (p FileKeyProvider) Key(keyID string) ([]byte, error) {
+	if keyID == "" || strings.ContainsAny(keyID, `/\`) {
+		return nil, &BombKeyError{KeyID: keyID, Err: EPersistErrorKeyMissing}
+	}
+	data, err := os.ReadFile(filepath.Join(p.Dir, keyID+".key"))
+	if err != nil {
+		return nil, &BombKeyError{KeyID: keyID, Err: fmt.Errorf("%w: %v", EPersistErrorKeyMissing, err)}
+	}
+	return parseBombKey(keyID, strings.TrimSpace(string(data)))
+}
+
+// callbackKeyProvider 通过回调获取密钥
+type callbackKeyProvider struct {
+	current string
+	fn      func(keyID string) ([]byte, error)
+}
+
+// NewCallbackKeyProvider 创建回调密钥来源, current 为当前密钥ID
+func NewCallbackKeyProvider(current string, fn func(keyID string) ([]byte, error)) KeyProvider {
+	return &callbackKeyProvider{current: current, fn: fn}
+}
+
+// CurrentKeyID 当前密钥ID
+func (p *callbackKeyProvider) CurrentKeyID() (string, error) {
+	return p.current, nil
+}
+
+// Key 按密钥ID获取密钥
+func (p *callbackKeyProvider) Key(keyID string) ([]byte, error) {
+	key, err := p.fn(keyID)
+	if err != nil {
+		return nil, &BombKeyError{KeyID: keyID, Err: fmt.Errorf("%w: %v", EPersistErrorKeyMissing, err)}
+	}
+	if key == nil {
+		return nil, &BombKeyError{KeyID: keyID, Err: EPersistErrorKeyMissing}
+	}
+	return key, nil
+}
+
+// parseBombKey 解析 hex 或 base64 编码的密钥
+func parseBombKey(keyID, value string) ([]byte, error) {
+	if key, err := hex.DecodeString(value); err == nil && validBombKey(key) {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && validBombKey(key) {
+		return key, nil
+	}
+	return nil, &BombKeyError{KeyID: keyID, Err: errors.New("key must be 16, 24 or 32 bytes in hex or base64")}
+}
+
+// validBombKey 是否为合法的AES密钥长度
+func validBombKey(key []byte) bool {
+	switch len(key) {
+	case 16, 24, 32:
+		return true
+	default:
+		return false
+	}
+}
+
+// bombKey 从密钥来源获取密钥
+func bombKey(keyID string) ([]byte, error) {
+	provider := GetBombKeyProvider()
+	if provider == nil {
+		return nil, &BombKeyError{KeyID: keyID, Err: fmt.Errorf("%w: no key provider", EPersistErrorKeyMissing)}
+	}
+	key, err := provider.Key(keyID)
+	if err != nil {
+		var keyErr *BombKeyError
+		if errors.As(err, &keyErr) {
+			return nil, err
+		}
+		return nil, &BombKeyError{KeyID: keyID, Err: fmt.Errorf("%w: %v", EPersistErrorKeyMissing, err)}
+	}
+	if !validBombKey(key) {
+		return nil, &BombKeyError{KeyID: keyID, Err: errors.New("key must be 16, 24 or 32 bytes")}
+	}
+	return key, nil
+}
+
+// newBombAEAD 创建AES-GCM
+func newBombAEAD(keyID string) (cipher.AEAD, error) {
+	key, err := bombKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newBombNonce 生成文件nonce前缀
+func newBombNonce() ([]byte, error) {
+	nonce := make([]byte, eBombNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// segmentNonce 段nonce: 文件nonce前缀 + 段序号
+func segmentNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, 0, eBombNonceSize+4)
+	nonce = append(nonce, prefix...)
+	return binary.BigEndian.AppendUint32(nonce, counter)
+}
+
+// segmentAAD 段附加数据: 文件头 + 是否最后一段, 防止篡改文件头或截断文件
+func segmentAAD(header string, last bool) []byte {
+	aad := []byte(header)
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// encryptWriter 分段加密写入
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  string
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+}
+
+// newEncryptWriter 创建分段加密写入, header 为写入文件的完整文件头
+func newEncryptWriter(w io.Writer, aead cipher.AEAD, header string, nonce []byte) *encryptWriter {
+	return &encryptWriter{w: w, aead: aead, header: header, nonce: nonce}
+}
+
+// Write 缓存明文, 超过一段时加密写入, 保证最后一段在 Close 时写入
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > eBombSegmentSize {
+		if err := e.seal(e.buf[:eBombSegmentSize], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[eBombSegmentSize:]...)
+	}
+	return len(p), nil
+}
+
+// Close 加密写入最后一段
+func (e *encryptWriter) Close() error {
+	err := e.seal(e.buf, true)
+	e.buf = e.buf[:0]
+	return err
+}
+
+// seal 加密写入一段
+func (e *encryptWriter) seal(plain []byte, last bool) error {
+	e.out = e.aead.Seal(e.out[:0], segmentNonce(e.nonce, e.counter), plain, segmentAAD(e.header, last))
+	e.counter++
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// decryptReader 分段解密读取
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  string
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	plain   []byte
+	done    bool
+}
+
+// newDecryptReader 创建分段解密读取, header 为文件中的完整文件头
+func newDecryptReader(r io.Reader, aead cipher.AEAD, header string, nonce []byte) *decryptReader {
+	return &decryptReader{r: bufio.NewReader(r), aead: aead, header: header, nonce: nonce}
+}
+
+// Read 读取明文
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open 读取并解密一段
+func (d *decryptReader) open() error {
+	if cap(d.buf) < eBombSegmentSize+d.aead.Overhead() {
+		d.buf = make([]byte, eBombSegmentSize+d.aead.Overhead())
+	}
+	n, err := io.ReadFull(d.r, d.buf[:cap(d.buf)])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	last := err != nil
+	if !last {
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	plain, err := d.aead.Open(d.buf[:0], segmentNonce(d.nonce, d.counter), d.buf[:n], segmentAAD(d.header, last))
+	if err != nil {
+		return fmt.Errorf("%w: segment %d: %v", EPersistErrorInvalidBombFile, d.counter, err)
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// Close 实现 io.Closer
+func (d *decryptReader) Close() error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package persist_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// setKeyProvider 设置测试用密钥来源, 测试结束后恢复
+func setKeyProvider(t *testing.T, provider persist.KeyProvider) {
+	t.Helper()
+	old := persist.GetBombKeyProvider()
+	persist.SetBombKeyProvider(provider)
+	t.Cleanup(func() {
+		persist.SetBombKeyProvider(old)
+	})
+}
+
+// testKeys 测试用密钥
+var testKeys = map[string][]byte{
+	"k1": bytes.Repeat([]byte{1}, 32),
+	"k2": bytes.Repeat([]byte{2}, 16),
+}
+
+// testKeyProvider 创建测试用回调密钥来源
+func testKeyProvider(current string) persist.KeyProvider {
+	return persist.NewCallbackKeyProvider(current, func(keyID string) ([]byte, error) {
+		return testKeys[keyID], nil
+	})
+}
+
+// TestBombEncryption_RoundTrip 测试加密文件编码解码.
+func TestBombEncryption_RoundTrip(t *testing.T) {
+	setKeyProvider(t, testKeyProvider("k1"))
+	records := make([][]byte, 300)
+	for i := range records {
+		records[i] = bytes.Repeat([]byte("player@example.com;"), i)
+	}
+
+	for _, compression := range []persist.BombCompression{persist.EBombCompressionNone, persist.EBombCompressionZstd} {
+		t.Run(string(compression), func(t *testing.T) {
+			header := persist.NewBombHeader("MenusGlobal", persist.EBombKindBomb, len(records))
+			header.Compression = compression
+			data, err := persist.EncodeBomb(header, records)
+			if err != nil {
+				t.Fatalf("EncodeBomb() error = %v", err)
+			}
+			if bytes.Contains(data, []byte("player@example.com")) {
+				t.Fatal("plain text found in encrypted file")
+			}
+
+			got, records2, err := persist.DecodeBomb(data)
+			if err != nil {
+				t.Fatalf("DecodeBomb() error = %v", err)
+			}
+			if got.KeyID != "k1" || got.Encryption != persist.EBombEncryptionAESGCM {
+				t.Errorf("DecodeBomb() header = %+v", got)
+			}
+			if len(records2) != len(records) || !bytes.Equal(records2[len(records)-1], records[len(records)-1]) {
+				t.Errorf("DecodeBomb() returned %d records", len(records2))
+			}
+		})
+	}
+}
+
+// TestBombEncryption_Rotation 测试轮换密钥后仍能读取旧文件.
+func TestBombEncryption_Rotation(t *testing.T) {
+	setKeyProvider(t, testKeyProvider("k1"))
+	old, err := persist.EncodeBomb(persist.NewBombHeader("MenusGlobal", persist.EBombKindBomb, 1), [][]byte{[]byte("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persist.SetBombKeyProvider(testKeyProvider("k2"))
+	data, err := persist.EncodeBomb(persist.NewBombHeader("MenusGlobal", persist.EBombKindBomb, 1), [][]byte{[]byte("new")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, _, err := persist.DecodeBomb(data); err != nil || header.KeyID != "k2" {
+		t.Errorf("DecodeBomb() new = %+v, %v", header, err)
+	}
+	if _, records, err := persist.DecodeBomb(old); err != nil || string(records[0]) != "old" {
+		t.Errorf("DecodeBomb() old = %q, %v", records, err)
+	}
+}
+
+// TestBombEncryption_KeyMissing 测试缺少密钥时返回类型化错误.
+func TestBombEncryption_KeyMissing(t *testing.T) {
+	setKeyProvider(t, testKeyProvider("k1"))
+	data, err := persist.EncodeBomb(persist.NewBombHeader("MenusGlobal", persist.EBombKindBomb, 1), [][]byte{[]byte("row")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := persist.NewCallbackKeyProvider("k3", func(keyID string) ([]byte, error) {
+		return nil, nil
+	})
+	for _, provider := range []persist.KeyProvider{nil, rotated, persist.FileKeyProvider{Dir: t.TempDir()}} {
+		persist.SetBombKeyProvider(provider)
+		_, _, err = persist.DecodeBomb(data)
+		var keyErr *persist.BombKeyError
+		if !errors.As(err, &keyErr) || !errors.Is(err, persist.EPersistErrorKeyMissing) || keyErr.KeyID != "k1" {
+			t.Errorf("DecodeBomb() error = %v, want key missing for k1", err)
+		}
+	}
+}
+
+// TestBombEncryption_Tamper 测试篡改文件头或截断文件.
+func TestBombEncryption_Tamper(t *testing.T) {
+	setKeyProvider(t, testKeyProvider("k1"))
+	records := [][]byte{bytes.Repeat([]byte{7}, 100<<10), []byte("tail")}
+	data, err := persist.EncodeBomb(persist.NewBombHeader("MenusGlobal", persist.EBombKindBomb, len(records)), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := bytes.Replace(data, []byte("name=MenusGlobal"), []byte("name=MenusGlobaX"), 1)
+	if _, _, err = persist.DecodeBomb(renamed); err == nil {
+		t.Error("DecodeBomb() with tampered header should fail")
+	}
+	if _, _, err = persist.DecodeBomb(data[:len(data)-10]); err == nil {
+		t.Error("DecodeBomb() with truncated data should fail")
+	}
+}
+
+// TestEnvKeyProvider 测试从环境变量读取密钥.
+func TestEnvKeyProvider(t *testing.T) {
+	t.Setenv("PERSIST_BOMB_KEY_ID", "k1")
+	t.Setenv("PERSIST_BOMB_KEY_K1", hex.EncodeToString(testKeys["k1"]))
+	provider := persist.EnvKeyProvider{}
+
+	keyID, err := provider.CurrentKeyID()
+	if err != nil || keyID != "k1" {
+		t.Fatalf("CurrentKeyID() = %q, %v", keyID, err)
+	}
+	key, err := provider.Key(keyID)
+	if err != nil || !bytes.Equal(key, testKeys["k1"]) {
+		t.Errorf("Key() = %x, %v", key, err)
+	}
+	if _, err = provider.Key("k2"); !errors.Is(err, persist.EPersistErrorKeyMissing) {
+		t.Errorf("Key() error = %v", err)
+	}
+}
+
+// TestFileKeyProvider 测试从目录读取密钥.
+func TestFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "k2.key"), []byte(hex.EncodeToString(testKeys["k2"])+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider := persist.FileKeyProvider{Dir: dir, Current: "k2"}
+
+	key, err := provider.Key("k2")
+	if err != nil || !bytes.Equal(key, testKeys["k2"]) {
+		t.Errorf("Key() = %x, %v", key, err)
+	}
+	if _, err = provider.Key("../k2"); !errors.Is(err, persist.EPersistErrorKeyMissing) {
+		t.Errorf("Key() error = %v", err)
+	}
+}
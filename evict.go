@@ -0,0 +1,186 @@
+package persist
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	eGlobalEvictInterval = time.Second // 默认淘汰检查间隔
+	eGlobalKeyMissPrune  = 4096        // 未配置淘汰时记录的数据库中不存在的主键上限
+)
+
+// GlobalEvictConfig 按主键导入的数据淘汰配置, 上限为0时不限制
+// 淘汰按导出主键处理: 之前的修改写回后才从内存移除, 写回失败时保留, 导出期间仍可读取
+type GlobalEvictConfig struct {
+	MaxRows   int           // 内存数据行数上限, 超过时淘汰最久未访问的数据
+	MaxMemory int64         // 内存数据估算上限, 按结构体大小估算, 不含字符串和切片指向的数据
+	IdleTTL   time.Duration // 超过该时间未访问的数据淘汰
+	Interval  time.Duration // 检查间隔, 为0时按 eGlobalEvictInterval
+}
+
+// GlobalCacheStats 按主键导入的统计
+type GlobalCacheStats struct {
+	Rows      int64 // 内存中的主键数量, 包括数据库中不存在的主键, 未配置淘汰时这部分有数量上限
+	Hits      int64 // 命中内存的次数
+	Misses    int64 // 查询数据库的次数
+	Evictions int64 // 淘汰移除的主键数量
+}
+
+// SetEvict 设置淘汰并开启按主键导入, 应在 Run 之前调用, 配置无效时返回 EPersistErrorInvalidConfig
+func (g *GlobalManager[T]) SetEvict(config GlobalEvictConfig) error {
+	if config.MaxRows < 0 || config.MaxMemory < 0 || config.IdleTTL < 0 || config.Interval < 0 {
+		return fmt.Errorf("%w: negative evict config", EPersistErrorInvalidConfig)
+	}
+	g.evict = config
+	g.readThrough = true
+	return nil
+}
+
+// CacheStats 按主键导入的命中, 未命中和淘汰统计
+func (g *GlobalManager[T]) CacheStats() GlobalCacheStats {
+	g.keyMu.Lock()
+	rows := int64(len(g.keyLoads))
+	g.keyMu.Unlock()
+	return GlobalCacheStats{
+		Rows:      rows,
+		Hits:      g.cacheHits.Load(),
+		Misses:    g.cacheMisses.Load(),
+		Evictions: g.evictions.Load(),
+	}
+}
+
+// evictEnabled 是否配置了淘汰
+func (g *GlobalManager[T]) evictEnabled() bool {
+	return g.evict.MaxRows > 0 || g.evict.MaxMemory > 0 || g.evict.IdleTTL > 0
+}
+
+// touchKey 更新主键的访问时间
+func (g *GlobalManager[T]) touchKey(key any) {
+	if !g.evictEnabled() {
+		return
+	}
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	if load, ok := g.keyLoads[key]; ok {
+		g.touchLocked(load)
+	}
+}
+
+// touchLocked 更新访问时间, 调用时持有 keyMu
+func (g *GlobalManager[T]) touchLocked(load *globalKeyLoad) {
+	if g.evictEnabled() {
+		load.access = time.Now().UnixNano()
+	}
+}
+
+// Evict 执行一次淘汰, 返回开始导出的主键数量
+// 先淘汰超过空闲时间的数据, 再按访问时间从旧到新淘汰到行数和内存不超过上限
+func (g *GlobalManager[T]) Evict() int {
+	if !g.evictEnabled() || g.LoadState() == EGlobalTableStateMemory {
+		return 0
+	}
+	type candidate struct {
+		key    any
+		access int64
+	}
+	g.keyMu.Lock()
+	rows := 0
+	candidates := make([]candidate, 0, len(g.keyLoads))
+	for key, load := range g.keyLoads {
+		switch load.state {
+		case EGlobalLoadStateMemory:
+			candidates = append(candidates, candidate{key: key, access: load.access})
+			rows++
+		case EGlobalLoadStatePrepareUnloading, EGlobalLoadStateUnloading:
+		default:
+			rows++
+		}
+	}
+	g.keyMu.Unlock()
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.access < b.access:
+			return -1
+		case a.access > b.access:
+			return 1
+		}
+		return 0
+	})
+	size := int64(g.meta.Type.Size())
+	idle := time.Now().Add(-g.evict.IdleTTL).UnixNano()
+	over := func() bool {
+		return (g.evict.MaxRows > 0 && rows > g.evict.MaxRows) || (g.evict.MaxMemory > 0 && int64(rows)*size > g.evict.MaxMemory)
+	}
+
+	evicted := 0
+	for _, c := range candidates {
+		if !(g.evict.IdleTTL > 0 && c.access < idle) && !over() {
+			break
+		}
+		if g.evictKey(c.key) {
+			evicted++
+		}
+		rows--
+	}
+	return evicted
+}
+
+// evictKey 导出一个主键, 管理器不接收修改或主键状态已变化时跳过
+func (g *GlobalManager[T]) evictKey(key any) bool {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	if !g.readThrough || atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal {
+		return false
+	}
+	return g.unloadKey(key, true) == nil
+}
+
+// startEvict 启动淘汰协程, 未配置淘汰时不启动
+func (g *GlobalManager[T]) startEvict() {
+	if !g.evictEnabled() {
+		return
+	}
+	interval := g.evict.Interval
+	if interval <= 0 {
+		interval = eGlobalEvictInterval
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	g.evictStop, g.evictDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				g.evictOnce()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// evictOnce 执行一次淘汰, 异常不影响后续淘汰
+func (g *GlobalManager[T]) evictOnce() {
+	defer func() {
+		if r := recover(); r != nil {
+			g.logError("evict", fmt.Errorf("%w: %v", EPersistErrorUnknownError, r), nil)
+		}
+	}()
+	g.Evict()
+}
+
+// stopEvict 停止淘汰协程, 等待正在进行的淘汰结束
+func (g *GlobalManager[T]) stopEvict() {
+	if g.evictStop == nil {
+		return
+	}
+	close(g.evictStop)
+	<-g.evictDone
+	g.evictStop, g.evictDone = nil, nil
+}
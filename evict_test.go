@@ -0,0 +1,121 @@
+package persist_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestGlobalManager_EvictLRU 测试超过行数上限时淘汰最久未访问的数据, 淘汰后再次访问从数据库导入, 并统计命中和淘汰.
+func TestGlobalManager_EvictLRU(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert([]*ManagerGlobal{{AuthId: 1}, {AuthId: 2}, {AuthId: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetEvict(persist.GlobalEvictConfig{MaxRows: -1}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("SetEvict() negative error = %v", err)
+	}
+	if err := g.SetEvict(persist.GlobalEvictConfig{MaxRows: 2, Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{1, 2, 3} {
+		if _, ok := g.Get(id); !ok {
+			t.Fatalf("Get(%d) ok = false", id)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 访问后 1 不再是最久未访问的数据
+	g.Get(int64(1))
+
+	if n := g.Evict(); n != 1 {
+		t.Fatalf("Evict() = %d, want 1", n)
+	}
+	waitFor(t, func() bool { return g.KeyLoadState(int64(2)) == persist.EGlobalLoadStateDisk })
+	if g.KeyLoadState(int64(1)) != persist.EGlobalLoadStateMemory || g.KeyLoadState(int64(3)) != persist.EGlobalLoadStateMemory {
+		t.Error("evicted recently used key")
+	}
+	if stats := g.CacheStats(); stats.Rows != 2 || stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 {
+		t.Errorf("CacheStats() = %+v", stats)
+	}
+	if _, ok := g.Get(int64(2)); !ok {
+		t.Error("Get() evicted key ok = false")
+	}
+	if stats := g.CacheStats(); stats.Misses != 4 {
+		t.Errorf("CacheStats() misses = %d, want 4", stats.Misses)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_EvictPending 测试淘汰未写回的数据时先写回再移除, 淘汰期间的修改取消淘汰.
+func TestGlobalManager_EvictPending(t *testing.T) {
+	g, engine := newTestManager(t)
+	if err := g.SetEvict(persist.GlobalEvictConfig{IdleTTL: time.Nanosecond, Interval: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: 50 * time.Millisecond, MaxInterval: 50 * time.Millisecond})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 2, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if n := g.Evict(); n != 2 {
+		t.Fatalf("Evict() = %d, want 2", n)
+	}
+	// 写回前仍可读取
+	if row, ok := g.Get(int64(1)); !ok || row.Name != "a" {
+		t.Errorf("Get() while evicting = %+v, %v", row, ok)
+	}
+	if err := g.Update(&ManagerGlobal{AuthId: 2, Name: "b2"}, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatalf("Update() while evicting error = %v", err)
+	}
+
+	waitFor(t, func() bool { return g.KeyLoadState(int64(1)) == persist.EGlobalLoadStateDisk })
+	if n := countRows(t, engine); n != 2 {
+		t.Errorf("rows after evict = %d, want 2", n)
+	}
+	waitFor(t, func() bool {
+		got := new(ManagerGlobal)
+		has, _ := engine.ID(int64(2)).Get(got)
+		return has && got.Name == "b2"
+	})
+	if g.KeyLoadState(int64(2)) != persist.EGlobalLoadStateMemory {
+		t.Error("modified key evicted")
+	}
+	if stats := g.CacheStats(); stats.Evictions != 1 {
+		t.Errorf("CacheStats() evictions = %d, want 1", stats.Evictions)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_EvictIdle 测试后台协程淘汰超过空闲时间的数据.
+func TestGlobalManager_EvictIdle(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetEvict(persist.GlobalEvictConfig{IdleTTL: 10 * time.Millisecond, Interval: 5 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.Get(int64(1)); !ok {
+		t.Fatal("Get() ok = false")
+	}
+	waitFor(t, func() bool { return g.CacheStats().Rows == 0 })
+	if stats := g.CacheStats(); stats.Evictions != 1 {
+		t.Errorf("CacheStats() = %+v", stats)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
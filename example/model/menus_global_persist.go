@@ -0,0 +1,345 @@
+// Code generated by persistgen. DO NOT EDIT.
+
+package model
+
+import (
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+// MenusGlobal 字段序号, 与位图位置一致
+const (
+	MenusGlobalFieldIndexAuthId             persist.GlobalFieldIndex = 0  // 权限id
+	MenusGlobalFieldIndexParentId           persist.GlobalFieldIndex = 1  // 父菜单ID
+	MenusGlobalFieldIndexTreePath           persist.GlobalFieldIndex = 2  // 父节点ID路径
+	MenusGlobalFieldIndexName               persist.GlobalFieldIndex = 3  // 菜单名称
+	MenusGlobalFieldIndexType               persist.GlobalFieldIndex = 4  // 菜单类型
+	MenusGlobalFieldIndexRouteName          persist.GlobalFieldIndex = 5  // 路由名称（Vue Router 中用于命名路由）
+	MenusGlobalFieldIndexPath               persist.GlobalFieldIndex = 6  // 路由路径（Vue Router 中定义的 URL 路径）
+	MenusGlobalFieldIndexComponent          persist.GlobalFieldIndex = 7  // 组件路径（组件页面完整路径，相对于 src/views/，缺省后缀 .vue）
+	MenusGlobalFieldIndexPerm               persist.GlobalFieldIndex = 8  // [按钮]权限标识
+	MenusGlobalFieldIndexStatus             persist.GlobalFieldIndex = 9  // 显示状态（1-显示 2-隐藏）
+	MenusGlobalFieldIndexAffixTab           persist.GlobalFieldIndex = 10 // 固定标签页（1-是 2-否）
+	MenusGlobalFieldIndexHideChildrenInMenu persist.GlobalFieldIndex = 11 // 子级不展现（1-是 2-否）
+	MenusGlobalFieldIndexHideInBreadcrumb   persist.GlobalFieldIndex = 12 // 面包屑中不展现（1-是 2-否）
+	MenusGlobalFieldIndexHideInMenu         persist.GlobalFieldIndex = 13 // 菜单中不展现（1-是 2-否）
+	MenusGlobalFieldIndexHideInTab          persist.GlobalFieldIndex = 14 // 标签页中不展现（1-是 2-否）
+	MenusGlobalFieldIndexKeepAlive          persist.GlobalFieldIndex = 15 // 是否缓存（1-是 2-否）
+	MenusGlobalFieldIndexSort               persist.GlobalFieldIndex = 16 // 排序
+	MenusGlobalFieldIndexIcon               persist.GlobalFieldIndex = 17 // 菜单图标
+	MenusGlobalFieldIndexRedirect           persist.GlobalFieldIndex = 18 // 跳转路径
+	MenusGlobalFieldIndexRoles              persist.GlobalFieldIndex = 19 // 可访问的角色
+	MenusGlobalFieldIndexUpdated            persist.GlobalFieldIndex = 20 // 修改时间
+
+	MenusGlobalFieldNum = 21 // 字段数量
+)
+
+// MenusGlobalDBFiledMap 字段序号 -> 数据库列名, 非数据库字段为空
+var MenusGlobalDBFiledMap = [MenusGlobalFieldNum]string{
+	"auth_id",
+	"parent_id",
+	"tree_path",
+	"name",
+	"type",
+	"route_name",
+	"path",
+	"component",
+	"perm",
+	"status",
+	"affix_tab",
+	"hide_children_in_menu",
+	"hide_in_breadcrumb",
+	"hide_in_menu",
+	"hide_in_tab",
+	"keep_alive",
+	"sort",
+	"icon",
+	"redirect",
+	"roles",
+	"updated",
+}
+
+// MenusGlobalHashGroups hash标签声明的索引组, 与 persist.GlobalMeta.HashGroups 相同
+var MenusGlobalHashGroups = []persist.GlobalHashGroup{
+	{Group: 1, Unique: true, Fields: []persist.GlobalFieldIndex{MenusGlobalFieldIndexAuthId}},
+	{Group: 3, Unique: false, Fields: []persist.GlobalFieldIndex{MenusGlobalFieldIndexAuthId, MenusGlobalFieldIndexType}},
+}
+
+// MenusGlobalHashAuthId hash索引组1的键, 组内唯一
+type MenusGlobalHashAuthId struct {
+	AuthId int64
+}
+
+// HashAuthId hash索引组1的键
+func (m *MenusGlobal) HashAuthId() MenusGlobalHashAuthId {
+	return MenusGlobalHashAuthId{AuthId: m.AuthId}
+}
+
+// MenusGlobalHashAuthIdType hash索引组3的键
+type MenusGlobalHashAuthIdType struct {
+	AuthId int64
+	Type   string
+}
+
+// HashAuthIdType hash索引组3的键
+func (m *MenusGlobal) HashAuthIdType() MenusGlobalHashAuthIdType {
+	return MenusGlobalHashAuthIdType{AuthId: m.AuthId, Type: m.Type}
+}
+
+// SetAuthId 修改 AuthId 并标记位图
+func (m *MenusGlobal) SetAuthId(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.AuthId = v
+	bitSet.Set(MenusGlobalFieldIndexAuthId)
+}
+
+// SetParentId 修改 ParentId 并标记位图
+func (m *MenusGlobal) SetParentId(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.ParentId = v
+	bitSet.Set(MenusGlobalFieldIndexParentId)
+}
+
+// SetTreePath 修改 TreePath 并标记位图
+func (m *MenusGlobal) SetTreePath(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.TreePath = v
+	bitSet.Set(MenusGlobalFieldIndexTreePath)
+}
+
+// SetName 修改 Name 并标记位图
+func (m *MenusGlobal) SetName(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Name = v
+	bitSet.Set(MenusGlobalFieldIndexName)
+}
+
+// SetType 修改 Type 并标记位图
+func (m *MenusGlobal) SetType(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Type = v
+	bitSet.Set(MenusGlobalFieldIndexType)
+}
+
+// SetRouteName 修改 RouteName 并标记位图
+func (m *MenusGlobal) SetRouteName(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.RouteName = v
+	bitSet.Set(MenusGlobalFieldIndexRouteName)
+}
+
+// SetPath 修改 Path 并标记位图
+func (m *MenusGlobal) SetPath(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Path = v
+	bitSet.Set(MenusGlobalFieldIndexPath)
+}
+
+// SetComponent 修改 Component 并标记位图
+func (m *MenusGlobal) SetComponent(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Component = v
+	bitSet.Set(MenusGlobalFieldIndexComponent)
+}
+
+// SetPerm 修改 Perm 并标记位图
+func (m *MenusGlobal) SetPerm(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Perm = v
+	bitSet.Set(MenusGlobalFieldIndexPerm)
+}
+
+// SetStatus 修改 Status 并标记位图
+func (m *MenusGlobal) SetStatus(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Status = v
+	bitSet.Set(MenusGlobalFieldIndexStatus)
+}
+
+// SetAffixTab 修改 AffixTab 并标记位图
+func (m *MenusGlobal) SetAffixTab(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.AffixTab = v
+	bitSet.Set(MenusGlobalFieldIndexAffixTab)
+}
+
+// SetHideChildrenInMenu 修改 HideChildrenInMenu 并标记位图
+func (m *MenusGlobal) SetHideChildrenInMenu(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.HideChildrenInMenu = v
+	bitSet.Set(MenusGlobalFieldIndexHideChildrenInMenu)
+}
+
+// SetHideInBreadcrumb 修改 HideInBreadcrumb 并标记位图
+func (m *MenusGlobal) SetHideInBreadcrumb(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.HideInBreadcrumb = v
+	bitSet.Set(MenusGlobalFieldIndexHideInBreadcrumb)
+}
+
+// SetHideInMenu 修改 HideInMenu 并标记位图
+func (m *MenusGlobal) SetHideInMenu(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.HideInMenu = v
+	bitSet.Set(MenusGlobalFieldIndexHideInMenu)
+}
+
+// SetHideInTab 修改 HideInTab 并标记位图
+func (m *MenusGlobal) SetHideInTab(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.HideInTab = v
+	bitSet.Set(MenusGlobalFieldIndexHideInTab)
+}
+
+// SetKeepAlive 修改 KeepAlive 并标记位图
+func (m *MenusGlobal) SetKeepAlive(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.KeepAlive = v
+	bitSet.Set(MenusGlobalFieldIndexKeepAlive)
+}
+
+// SetSort 修改 Sort 并标记位图
+func (m *MenusGlobal) SetSort(v int64, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Sort = v
+	bitSet.Set(MenusGlobalFieldIndexSort)
+}
+
+// SetIcon 修改 Icon 并标记位图
+func (m *MenusGlobal) SetIcon(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Icon = v
+	bitSet.Set(MenusGlobalFieldIndexIcon)
+}
+
+// SetRedirect 修改 Redirect 并标记位图
+func (m *MenusGlobal) SetRedirect(v string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Redirect = v
+	bitSet.Set(MenusGlobalFieldIndexRedirect)
+}
+
+// SetRoles 修改 Roles 并标记位图
+func (m *MenusGlobal) SetRoles(v []string, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Roles = v
+	bitSet.Set(MenusGlobalFieldIndexRoles)
+}
+
+// SetUpdated 修改 Updated 并标记位图
+func (m *MenusGlobal) SetUpdated(v time.Time, bitSet *persist.GlobalBitSet[MenusGlobal]) {
+	m.Updated = v
+	bitSet.Set(MenusGlobalFieldIndexUpdated)
+}
+
+// PersistFieldNum 字段数量
+func (m *MenusGlobal) PersistFieldNum() int {
+	return MenusGlobalFieldNum
+}
+
+// PersistEncodeField 编码第 i 个字段
+func (m *MenusGlobal) PersistEncodeField(buf []byte, i persist.GlobalFieldIndex) ([]byte, error) {
+	switch i {
+	case MenusGlobalFieldIndexAuthId:
+		return persist.AppendPersistInt(buf, m.AuthId), nil
+	case MenusGlobalFieldIndexParentId:
+		return persist.AppendPersistInt(buf, m.ParentId), nil
+	case MenusGlobalFieldIndexTreePath:
+		return persist.AppendPersistString(buf, m.TreePath), nil
+	case MenusGlobalFieldIndexName:
+		return persist.AppendPersistString(buf, m.Name), nil
+	case MenusGlobalFieldIndexType:
+		return persist.AppendPersistString(buf, m.Type), nil
+	case MenusGlobalFieldIndexRouteName:
+		return persist.AppendPersistString(buf, m.RouteName), nil
+	case MenusGlobalFieldIndexPath:
+		return persist.AppendPersistString(buf, m.Path), nil
+	case MenusGlobalFieldIndexComponent:
+		return persist.AppendPersistString(buf, m.Component), nil
+	case MenusGlobalFieldIndexPerm:
+		return persist.AppendPersistString(buf, m.Perm), nil
+	case MenusGlobalFieldIndexStatus:
+		return persist.AppendPersistInt(buf, m.Status), nil
+	case MenusGlobalFieldIndexAffixTab:
+		return persist.AppendPersistInt(buf, m.AffixTab), nil
+	case MenusGlobalFieldIndexHideChildrenInMenu:
+		return persist.AppendPersistInt(buf, m.HideChildrenInMenu), nil
+	case MenusGlobalFieldIndexHideInBreadcrumb:
+		return persist.AppendPersistInt(buf, m.HideInBreadcrumb), nil
+	case MenusGlobalFieldIndexHideInMenu:
+		return persist.AppendPersistInt(buf, m.HideInMenu), nil
+	case MenusGlobalFieldIndexHideInTab:
+		return persist.AppendPersistInt(buf, m.HideInTab), nil
+	case MenusGlobalFieldIndexKeepAlive:
+		return persist.AppendPersistInt(buf, m.KeepAlive), nil
+	case MenusGlobalFieldIndexSort:
+		return persist.AppendPersistInt(buf, m.Sort), nil
+	case MenusGlobalFieldIndexIcon:
+		return persist.AppendPersistString(buf, m.Icon), nil
+	case MenusGlobalFieldIndexRedirect:
+		return persist.AppendPersistString(buf, m.Redirect), nil
+	case MenusGlobalFieldIndexRoles:
+		return persist.AppendPersistValue(buf, &m.Roles)
+	case MenusGlobalFieldIndexUpdated:
+		return persist.AppendPersistTime(buf, m.Updated), nil
+	}
+	return buf, persist.EPersistErrorInvalidData
+}
+
+// PersistDecodeField 解码第 i 个字段
+func (m *MenusGlobal) PersistDecodeField(d *persist.PersistDecoder, i persist.GlobalFieldIndex) (err error) {
+	switch i {
+	case MenusGlobalFieldIndexAuthId:
+		m.AuthId, err = d.Int()
+	case MenusGlobalFieldIndexParentId:
+		m.ParentId, err = d.Int()
+	case MenusGlobalFieldIndexTreePath:
+		m.TreePath, err = d.String()
+	case MenusGlobalFieldIndexName:
+		m.Name, err = d.String()
+	case MenusGlobalFieldIndexType:
+		m.Type, err = d.String()
+	case MenusGlobalFieldIndexRouteName:
+		m.RouteName, err = d.String()
+	case MenusGlobalFieldIndexPath:
+		m.Path, err = d.String()
+	case MenusGlobalFieldIndexComponent:
+		m.Component, err = d.String()
+	case MenusGlobalFieldIndexPerm:
+		m.Perm, err = d.String()
+	case MenusGlobalFieldIndexStatus:
+		m.Status, err = d.Int()
+	case MenusGlobalFieldIndexAffixTab:
+		m.AffixTab, err = d.Int()
+	case MenusGlobalFieldIndexHideChildrenInMenu:
+		m.HideChildrenInMenu, err = d.Int()
+	case MenusGlobalFieldIndexHideInBreadcrumb:
+		m.HideInBreadcrumb, err = d.Int()
+	case MenusGlobalFieldIndexHideInMenu:
+		m.HideInMenu, err = d.Int()
+	case MenusGlobalFieldIndexHideInTab:
+		m.HideInTab, err = d.Int()
+	case MenusGlobalFieldIndexKeepAlive:
+		m.KeepAlive, err = d.Int()
+	case MenusGlobalFieldIndexSort:
+		m.Sort, err = d.Int()
+	case MenusGlobalFieldIndexIcon:
+		m.Icon, err = d.String()
+	case MenusGlobalFieldIndexRedirect:
+		m.Redirect, err = d.String()
+	case MenusGlobalFieldIndexRoles:
+		err = persist.DecodePersistValue(d, &m.Roles)
+	case MenusGlobalFieldIndexUpdated:
+		m.Updated, err = d.Time()
+	default:
+		err = persist.EPersistErrorInvalidData
+	}
+	return
+}
+
+// MenusGlobalManager MenusGlobal 管理器
+type MenusGlobalManager struct {
+	*persist.GlobalManager[MenusGlobal]
+}
+
+// NewMenusGlobalManager 创建 MenusGlobal 管理器, engine 为 nil 时需要惰性注册
+func NewMenusGlobalManager(engine *xorm.Engine) *MenusGlobalManager {
+	return &MenusGlobalManager{GlobalManager: persist.NewGlobalManager[MenusGlobal](engine)}
+}
+
+// NewBitSet 创建空位图
+func (g *MenusGlobalManager) NewBitSet() persist.GlobalBitSet[MenusGlobal] {
+	return persist.InitGlobalBitSet[MenusGlobal]()
+}
+
+// Get 按主键获取数据副本
+func (g *MenusGlobalManager) Get(authId int64) (*MenusGlobal, bool) {
+	return g.GlobalManager.Get(authId)
+}
+
+// Delete 按主键删除数据
+func (g *MenusGlobalManager) Delete(authId int64) error {
+	return g.GlobalManager.Delete(authId)
+}
@@ -0,0 +1,116 @@
+package model_test
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"github.com/spelens-gud/persist/example/model"
+	"github.com/spelens-gud/persist/persistgen"
+)
+
+// menusMirror 与 MenusGlobal 结构相同但没有生成的方法, 走反射编码计划
+type menusMirror model.MenusGlobal
+
+// newMenus 创建测试数据
+func newMenus() *model.MenusGlobal {
+	return &model.MenusGlobal{
+		AuthId:   1001,
+		ParentId: -1,
+		Name:     "系统管理",
+		Path:     "/system",
+		Sort:     3,
+		Roles:    []string{"admin", "ops"},
+		Updated:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+// TestMenusGlobal_Codec 测试生成的编解码与反射编码计划格式相同.
+func TestMenusGlobal_Codec(t *testing.T) {
+	src := newMenus()
+	bitSet := persist.InitGlobalBitSet[model.MenusGlobal]()
+	src.SetName("菜单", &bitSet)
+	src.SetRoles(nil, &bitSet)
+	mirrorBitSet := persist.InitGlobalBitSet[menusMirror]()
+	mirrorBitSet.Set(model.MenusGlobalFieldIndexName).Set(model.MenusGlobalFieldIndexRoles)
+
+	tests := []struct {
+		name   string
+		gen    *persist.GlobalBitSet[model.MenusGlobal]
+		mirror *persist.GlobalBitSet[menusMirror]
+	}{
+		{"all", nil, nil},
+		{"partial", &bitSet, &mirrorBitSet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := persist.MarshalPersist(nil, src, tt.gen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := persist.MarshalPersist(nil, (*menusMirror)(src), tt.mirror)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("generated codec = %x, reflect codec = %x", got, want)
+			}
+
+			dst := new(model.MenusGlobal)
+			if _, err = persist.UnmarshalPersist(got, dst); err != nil {
+				t.Fatal(err)
+			}
+			mirror := new(menusMirror)
+			if _, err = persist.UnmarshalPersist(got, mirror); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dst, (*model.MenusGlobal)(mirror)) {
+				t.Errorf("generated decode = %+v, reflect decode = %+v", dst, mirror)
+			}
+		})
+	}
+}
+
+// TestMenusGlobal_UpToDate 测试生成文件与模型定义一致.
+func TestMenusGlobal_UpToDate(t *testing.T) {
+	m, err := persistgen.Parse(".", "MenusGlobal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := persistgen.Generate(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(persistgen.OutputName("MenusGlobal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("menus_global_persist.go is stale, run go generate")
+	}
+}
+
+// TestMenusGlobal_HashGroups 测试生成的hash索引组与运行时元数据一致.
+func TestMenusGlobal_HashGroups(t *testing.T) {
+	if want := persist.GetGlobalMeta[model.MenusGlobal]().HashGroups; !reflect.DeepEqual(model.MenusGlobalHashGroups, want) {
+		t.Errorf("MenusGlobalHashGroups = %+v, want %+v", model.MenusGlobalHashGroups, want)
+	}
+	src := newMenus()
+	src.Type = "menu"
+	if key := src.HashAuthIdType(); key.AuthId != src.AuthId || key.Type != "menu" {
+		t.Errorf("HashAuthIdType() = %+v", key)
+	}
+}
+
+// BenchmarkMenusGlobal_Marshal 生成代码的序列化性能.
+func BenchmarkMenusGlobal_Marshal(b *testing.B) {
+	src := newMenus()
+	buf := make([]byte, 0, 256)
+	b.ReportAllocs()
+	for b.Loop() {
+		buf, _ = persist.MarshalPersist(buf[:0], src, nil)
+	}
+}
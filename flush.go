@@ -0,0 +1,70 @@
+package persist
+
+import "time"
+
+// GlobalFlushConfig 写回调度配置
+// 下一轮写回的间隔按上一轮写回耗时自适应: 负载低时数据库响应快, 按最小间隔尽快写回;
+// 负载高时数据库响应变慢, 间隔变大, 每轮写回更大的批次. 修改增长快时按批量阈值提前写回
+type GlobalFlushConfig struct {
+	MinInterval time.Duration // 两轮写回之间的最小间隔
+	MaxInterval time.Duration // 两轮写回之间的最大间隔, 上一轮写回失败时按此间隔重试
+	BatchSize   int           // 缓存队列达到此数量时立即写回, 为0时只按间隔写回
+}
+
+// DefaultFlushConfig 默认写回调度配置
+var DefaultFlushConfig = GlobalFlushConfig{
+	MinInterval: 10 * time.Millisecond,
+	MaxInterval: time.Second,
+	BatchSize:   eGlobalInsertMultiNum * 10,
+}
+
+// interval 按上一轮写回耗时计算下一轮写回间隔
+func (c *GlobalFlushConfig) interval(lastWriteBack time.Duration, failed bool) time.Duration {
+	if failed {
+		return max(c.MaxInterval, c.MinInterval)
+	}
+	return max(min(lastWriteBack, c.MaxInterval), c.MinInterval)
+}
+
+// batchFull 缓存队列是否达到批量阈值
+func (c *GlobalFlushConfig) batchFull(n int) bool {
+	return c.BatchSize > 0 && n >= c.BatchSize
+}
+
+// GlobalFlushStats 写回调度统计
+type GlobalFlushStats struct {
+	Rounds        int64         // 写回轮数
+	BatchFlushes  int64         // 达到批量阈值提前开始的轮数
+	TimerFlushes  int64         // 到达写回间隔开始的轮数
+	Interval      time.Duration // 最近一次计算的写回间隔
+	LastBatch     int64         // 最近一轮写回的修改数量, 不含失败队列
+	LastWriteBack time.Duration // 最近一轮写回耗时
+}
+
+// SetFlush 设置写回调度, 应在 Run 之前调用
+func (g *GlobalManager[T]) SetFlush(config GlobalFlushConfig) {
+	g.flush = config
+}
+
+// FlushStats 获取写回调度统计
+func (g *GlobalManager[T]) FlushStats() GlobalFlushStats {
+	return GlobalFlushStats{
+		Rounds:        g.flushRounds.Load(),
+		BatchFlushes:  g.flushBatches.Load(),
+		TimerFlushes:  g.flushTimers.Load(),
+		Interval:      time.Duration(g.flushInterval.Load()),
+		LastBatch:     g.lastBatch.Load(),
+		LastWriteBack: time.Duration(g.lastWriteBackTime.Load()),
+	}
+}
+
+// nextFlush 上一轮写回结束后计算下一轮写回的等待时间, 没有需要写回的数据时 ok 为false, 等待新的修改
+func (g *GlobalManager[T]) nextFlush() (wait time.Duration, ok bool) {
+	failed := g.failNum.Load() > 0
+	interval := g.flush.interval(time.Duration(g.lastWriteBackTime.Load()), failed)
+	g.flushInterval.Store(int64(interval))
+	if len(*g.cacheQueue) == 0 && !failed && len(g.spillSeqs) == 0 {
+		return 0, false
+	}
+	return interval, true
+}
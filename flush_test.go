@@ -0,0 +1,108 @@
+package persist_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+// countRows 数据库中的行数
+func countRows(t *testing.T, engine *xorm.Engine) int64 {
+	t.Helper()
+	n, err := engine.Count(new(ManagerGlobal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestGlobalManager_FlushBatch 测试缓存队列达到批量阈值时立即写回, 未达到时等待写回间隔.
+func TestGlobalManager_FlushBatch(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Hour, MaxInterval: time.Hour, BatchSize: 10})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 10; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return countRows(t, engine) == 10 })
+	if stats := g.FlushStats(); stats.BatchFlushes != 1 || stats.TimerFlushes != 0 || stats.LastBatch != 10 {
+		t.Errorf("FlushStats() = %+v", stats)
+	}
+
+	if err := g.Insert(&ManagerGlobal{AuthId: 11}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := countRows(t, engine); n != 10 {
+		t.Errorf("rows before interval = %d, want 10", n)
+	}
+	g.Exit(&sync.WaitGroup{})
+	if n := countRows(t, engine); n != 11 {
+		t.Errorf("rows after Exit = %d, want 11", n)
+	}
+}
+
+// TestGlobalManager_FlushBatchFailing 测试失败队列不为空时达到批量阈值也不提前写回, 按最大间隔重试.
+func TestGlobalManager_FlushBatchFailing(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Millisecond, MaxInterval: time.Hour, BatchSize: 2})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return g.Health().FailQueue > 0 })
+	rounds := g.FlushStats().Rounds
+
+	for i := int64(3); i <= 12; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if stats := g.FlushStats(); stats.Rounds != rounds {
+		t.Errorf("FlushStats() rounds = %d, want %d while failing", stats.Rounds, rounds)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_FlushInterval 测试写回间隔在上下限之间.
+func TestGlobalManager_FlushInterval(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: 20 * time.Millisecond, MaxInterval: 40 * time.Millisecond})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	for i := int64(1); i <= 3; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool { return countRows(t, engine) == i })
+	}
+	stats := g.FlushStats()
+	if stats.TimerFlushes < 3 || stats.BatchFlushes != 0 {
+		t.Errorf("FlushStats() = %+v", stats)
+	}
+	if stats.Interval < 20*time.Millisecond || stats.Interval > 40*time.Millisecond {
+		t.Errorf("Interval = %v", stats.Interval)
+	}
+	if stats.LastWriteBack <= 0 {
+		t.Errorf("LastWriteBack = %v", stats.LastWriteBack)
+	}
+}
@@ -0,0 +1,185 @@
+package persist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HealthState 健康状态
+type HealthState string
+
+const (
+	EHealthStateHealthy  HealthState = "healthy"  // 正常运行
+	EHealthStateDegraded HealthState = "degraded" // 运行中但写回失败, 过载或存在未恢复的bomb文件
+	EHealthStateDown     HealthState = "down"     // 未运行或非法停止
+)
+
+// rank 状态的严重程度
+func (s HealthState) rank() int {
+	switch s {
+	case EHealthStateHealthy:
+		return 0
+	case EHealthStateDegraded:
+		return 1
+	}
+	return 2
+}
+
+// Health persist的健康状态
+type Health struct {
+	Name        string      `json:"name"`
+	State       HealthState `json:"state"`
+	Reason      string      `json:"reason,omitempty"`       // 不健康的原因
+	Pending     int64       `json:"pending"`                // 未写回的修改数量, 包括失败队列
+	FailQueue   int64       `json:"fail_queue"`             // 失败队列长度
+	LastBatch   int64       `json:"last_batch"`             // 最近一轮写回的修改数量
+	Overloaded  bool        `json:"overloaded"`             // 是否过载
+	LastFlush   time.Time   `json:"last_flush,omitzero"`    // 最近一次成功写回的时间
+	LastError   string      `json:"last_error,omitempty"`   // 最近一次错误
+	LastErrorAt time.Time   `json:"last_error_at,omitzero"` // 最近一次错误的时间
+	BombFile    bool        `json:"bomb_file"`              // 是否存在bomb文件
+	Restarts    int64       `json:"restarts"`               // 崩溃后自动重启的次数
+	Panic       string      `json:"panic,omitempty"`        // 最近一次崩溃的错误
+	PanicStack  string      `json:"panic_stack,omitempty"`  // 最近一次崩溃的调用栈
+	PanicAt     time.Time   `json:"panic_at,omitzero"`      // 最近一次崩溃的时间
+}
+
+// IPersistHealth 可以报告健康状态的persist
+type IPersistHealth interface {
+	Health() Health // 健康状态
+}
+
+// healthError 最近一次错误
+type healthError struct {
+	err string
+	at  time.Time
+}
+
+// recordError 记录最近一次错误
+func (g *GlobalManager[T]) recordError(err error) {
+	g.lastError.Store(&healthError{err: err.Error(), at: time.Now()})
+}
+
+// recordFlush 记录成功写回的时间
+func (g *GlobalManager[T]) recordFlush() {
+	g.lastFlush.Store(time.Now().UnixNano())
+}
+
+// Health 健康状态
+func (g *GlobalManager[T]) Health() Health {
+	h := Health{
+		Name:       g.name,
+		Pending:    g.pending.Load(),
+		FailQueue:  g.failNum.Load(),
+		LastBatch:  g.lastBatch.Load(),
+		Overloaded: g.overloaded.Load(),
+		BombFile:   DirExists(BombFilePath(g.name)),
+		Restarts:   g.restarts.Load(),
+	}
+	if flush := g.lastFlush.Load(); flush > 0 {
+		h.LastFlush = time.Unix(0, flush)
+	}
+	if last := g.lastError.Load(); last != nil {
+		h.LastError, h.LastErrorAt = last.err, last.at
+	}
+	if p := g.lastPanic.Load(); p != nil {
+		h.Panic, h.PanicStack, h.PanicAt = p.Err.Error(), p.Stack, p.At
+	}
+
+	switch atomic.LoadInt32(&g.managerState) {
+	case EGlobalManagerStateIdle:
+		h.State, h.Reason = EHealthStateDown, "not running"
+		if h.BombFile {
+			h.Reason = "not running, bomb file not recovered"
+		}
+	case EGlobalManagerStatePanic:
+		h.State, h.Reason = EHealthStateDown, "stopped by panic"
+		if h.Panic != "" {
+			h.Reason += ": " + h.Panic
+		}
+	default:
+		h.State = EHealthStateHealthy
+		switch {
+		case h.FailQueue > 0:
+			h.State, h.Reason = EHealthStateDegraded, fmt.Sprintf("write back failing, %d in fail queue", h.FailQueue)
+		case h.BombFile:
+			h.State, h.Reason = EHealthStateDegraded, "bomb file not recovered"
+		case h.Overloaded:
+			h.State, h.Reason = EHealthStateDegraded, "overloaded"
+		}
+	}
+	return h
+}
+
+// Health 所有分片合计的健康状态, 状态为最差的分片, 最近一次成功写回为最早的分片
+func (s *ShardedManager[T]) Health() Health {
+	h := Health{Name: s.name, State: EHealthStateHealthy}
+	for _, g := range s.shards {
+		shard := g.Health()
+		h.Pending += shard.Pending
+		h.FailQueue += shard.FailQueue
+		h.LastBatch += shard.LastBatch
+		h.Overloaded = h.Overloaded || shard.Overloaded
+		h.BombFile = h.BombFile || shard.BombFile
+		h.Restarts += shard.Restarts
+		if shard.PanicAt.After(h.PanicAt) {
+			h.Panic, h.PanicStack, h.PanicAt = shard.Panic, shard.PanicStack, shard.PanicAt
+		}
+		if h.LastFlush.IsZero() || (!shard.LastFlush.IsZero() && shard.LastFlush.Before(h.LastFlush)) {
+			h.LastFlush = shard.LastFlush
+		}
+		if shard.LastErrorAt.After(h.LastErrorAt) {
+			h.LastError, h.LastErrorAt = shard.LastError, shard.LastErrorAt
+		}
+		if shard.State.rank() > h.State.rank() {
+			h.State, h.Reason = shard.State, shard.Name+": "+shard.Reason
+		}
+	}
+	return h
+}
+
+// HealthReport 所有注册的persist的健康报告, 可以直接序列化为JSON
+type HealthReport struct {
+	State    HealthState `json:"state"` // 最差的persist状态
+	Ready    bool        `json:"ready"` // 预热是否完成
+	Time     time.Time   `json:"time"`
+	Persists []Health    `json:"persists"` // 按名字排序
+}
+
+// GetHealthReport 所有注册的persist的健康报告, 未实现 IPersistHealth 的persist按 Dead 判断
+func GetHealthReport() HealthReport {
+	report := HealthReport{State: EHealthStateHealthy, Ready: Ready(), Time: time.Now()}
+	for name, persist := range gPersistMap {
+		var h Health
+		if health, ok := persist.(IPersistHealth); ok {
+			h = health.Health()
+		} else if persist.Dead() {
+			h = Health{Name: name, State: EHealthStateDown, Reason: "dead"}
+		} else {
+			h = Health{Name: name, State: EHealthStateHealthy}
+		}
+		if h.State.rank() > report.State.rank() {
+			report.State = h.State
+		}
+		report.Persists = append(report.Persists, h)
+	}
+	slices.SortFunc(report.Persists, func(a, b Health) int { return strings.Compare(a.Name, b.Name) })
+	return report
+}
+
+// HealthHandler 以JSON输出健康报告, 存在停止的persist或预热未完成时返回503
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := GetHealthReport()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if report.State == EHealthStateDown || !report.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,61 @@
+package persist_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestGlobalManager_Health 测试写回失败时报告失败队列, 最近错误和bomb文件, 恢复后健康, 退出后停止.
+func TestGlobalManager_Health(t *testing.T) {
+	g, repair := newOverloadManager(t, persist.GlobalOverloadConfig{})
+	if h := g.Health(); h.State != persist.EHealthStateHealthy || !h.LastFlush.IsZero() {
+		t.Errorf("Health() before writes = %+v", h)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.Health().State == persist.EHealthStateDegraded })
+	h := g.Health()
+	if h.FailQueue != 1 || !h.BombFile || h.LastError == "" || h.LastErrorAt.IsZero() || !strings.Contains(h.Reason, "fail queue") {
+		t.Errorf("Health() failing = %+v", h)
+	}
+
+	repair()
+	waitFor(t, func() bool { return g.Health().State == persist.EHealthStateHealthy })
+	if h = g.Health(); h.FailQueue != 0 || h.BombFile || h.LastFlush.IsZero() {
+		t.Errorf("Health() repaired = %+v", h)
+	}
+	g.Exit(&sync.WaitGroup{})
+	if h = g.Health(); h.State != persist.EHealthStateDown || h.Reason != "not running" {
+		t.Errorf("Health() after Exit = %+v", h)
+	}
+}
+
+// TestHealthHandler 测试健康报告以JSON输出, 停止或未就绪时返回503.
+func TestHealthHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	persist.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var report persist.HealthReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	want := http.StatusOK
+	if report.State == persist.EHealthStateDown || !report.Ready {
+		want = http.StatusServiceUnavailable
+	}
+	if rec.Code != want {
+		t.Errorf("status = %d, want %d for %+v", rec.Code, want, report)
+	}
+	if report.Time.IsZero() {
+		t.Error("report time is zero")
+	}
+}
@@ -0,0 +1,337 @@
+package persist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const eIdleCheckInterval = time.Minute // 默认空闲检查间隔
+
+// IdleConfig 空闲用户自动导出配置
+type IdleConfig struct {
+	Timeout   time.Duration              // 最近一次访问后超过该时间未访问的用户导出
+	Interval  time.Duration              // 检查间隔, 为0时按 eIdleCheckInterval
+	CanUnload func(uid int32) bool       // 导出前调用, 返回false时本次不导出, 为 nil 时不否决
+	Unload    func(uid int32) error      // 导出用户数据, 为 nil 时调用 Unload 导出所有用户persist
+	OnUnload  func(uid int32, err error) // 自动导出结束后调用, 不能阻塞
+}
+
+// IdleStats 空闲用户自动导出统计
+type IdleStats struct {
+	Tracked  int   // 记录访问时间的用户数量
+	Pinned   int   // 固定在内存中的用户数量
+	Unloads  int64 // 自动导出的次数
+	Vetoes   int64 // 被 CanUnload 否决的次数
+	Failures int64 // 导出失败的次数, 失败的用户在下一次检查时重试
+}
+
+// idleUser 用户的访问记录
+type idleUser struct {
+	access    int64         // 最近访问时间, 纳秒
+	pinned    bool          // 在线用户不自动导出
+	unloading chan struct{} // 正在自动导出, 导出结束时关闭
+}
+
+// IdleUnloader 记录用户最近访问时间, 空闲超时后自动导出用户数据
+// 在线用户用 Pin 固定, 下线时 Unpin 后开始计算空闲时间
+type IdleUnloader struct {
+	config IdleConfig
+	mu     sync.Mutex
+	users  map[int32]*idleUser
+	stop   chan struct{}
+	done   chan struct{}
+
+	unloads  atomic.Int64
+	vetoes   atomic.Int64
+	failures atomic.Int64
+}
+
+// NewIdleUnloader 创建空闲用户导出器, 超时时间不大于0时返回 EPersistErrorInvalidConfig
+func NewIdleUnloader(config IdleConfig) (*IdleUnloader, error) {
+	if config.Timeout <= 0 || config.Interval < 0 {
+		return nil, fmt.Errorf("%w: idle timeout %v, interval %v", EPersistErrorInvalidConfig, config.Timeout, config.Interval)
+	}
+	if config.Interval == 0 {
+		config.Interval = eIdleCheckInterval
+	}
+	return &IdleUnloader{config: config, users: make(map[int32]*idleUser)}, nil
+}
+
+// Touch 记录用户访问
+func (u *IdleUnloader) Touch(uid int32) {
+	now := time.Now().UnixNano()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if user, ok := u.users[uid]; ok {
+		user.access = now
+		return
+	}
+	u.users[uid] = &idleUser{access: now}
+}
+
+// Pin 固定用户, 在线期间不自动导出
+// 用户正在自动导出时等待导出结束后固定, 之后需要重新导入; 不能在 Unload 回调中调用
+func (u *IdleUnloader) Pin(uid int32) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.users[uid]
+	for ok && user.unloading != nil {
+		unloading := user.unloading
+		u.mu.Unlock()
+		<-unloading
+		u.mu.Lock()
+		user, ok = u.users[uid]
+	}
+	now := time.Now().UnixNano()
+	if ok {
+		user.access, user.pinned = now, true
+		return
+	}
+	u.users[uid] = &idleUser{access: now, pinned: true}
+}
+
+// Unpin 取消固定, 从调用时开始计算空闲时间
+func (u *IdleUnloader) Unpin(uid int32) {
+	now := time.Now().UnixNano()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if user, ok := u.users[uid]; ok {
+		user.access, user.pinned = now, false
+	}
+}
+
+// Forget 不再记录用户, 手动导出后调用
+func (u *IdleUnloader) Forget(uid int32) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.users, uid)
+}
+
+// Stats 统计
+func (u *IdleUnloader) Stats() IdleStats {
+	u.mu.Lock()
+	stats := IdleStats{Tracked: len(u.users)}
+	for _, user := range u.users {
+		if user.pinned {
+			stats.Pinned++
+		}
+	}
+	u.mu.Unlock()
+	stats.Unloads = u.unloads.Load()
+	stats.Vetoes = u.vetoes.Load()
+	stats.Failures = u.failures.Load()
+	return stats
+}
+
+// Sweep 导出在 now 之前空闲超时的用户, 返回导出成功的用户
+// 导出期间被访问的用户导出后继续记录, 失败的用户保留到下一次检查
+func (u *IdleUnloader) Sweep(now time.Time) (unloaded []int32) {
+	deadline := now.Add(-u.config.Timeout).UnixNano()
+	u.mu.Lock()
+	idle := make(map[int32]int64)
+	for uid, user := range u.users {
+		if !user.pinned && user.access <= deadline {
+			idle[uid] = user.access
+		}
+	}
+	u.mu.Unlock()
+
+	for uid, access := range idle {
+		if u.config.CanUnload != nil && !u.config.CanUnload(uid) {
+			u.vetoes.Add(1)
+			continue
+		}
+		// 否决回调期间可能已被访问或固定, 开始导出后 Pin 等待导出结束
+		u.mu.Lock()
+		user, ok := u.users[uid]
+		ready := ok && !user.pinned && user.access == access
+		var unloading chan struct{}
+		if ready {
+			unloading = make(chan struct{})
+			user.unloading = unloading
+		}
+		u.mu.Unlock()
+		if !ready {
+			continue
+		}
+
+		err := u.unload(uid)
+		u.mu.Lock()
+		if user, ok = u.users[uid]; ok && user.unloading == unloading {
+			user.unloading = nil
+		}
+		close(unloading)
+		u.mu.Unlock()
+		if u.config.OnUnload != nil {
+			u.config.OnUnload(uid, err)
+		}
+		if err != nil {
+			u.failures.Add(1)
+			msg := &Error{Err: fmt.Errorf("idle unload %d: %w", uid, err), Type: ErrorTypeOp}
+			msg.SetMeta(H{"uid": uid})
+			msg.Println(DefaultErrorWriter)
+			continue
+		}
+		u.unloads.Add(1)
+		unloaded = append(unloaded, uid)
+		u.mu.Lock()
+		if user, ok = u.users[uid]; ok && !user.pinned && user.access == access {
+			delete(u.users, uid)
+		}
+		u.mu.Unlock()
+	}
+	return unloaded
+}
+
+// unload 导出用户数据, 异常按失败处理
+func (u *IdleUnloader) unload(uid int32) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", EPersistErrorUnknownError, r)
+		}
+	}()
+	if u.config.Unload != nil {
+		return u.config.Unload(uid)
+	}
+	return unloadUser(uid)
+}
+
+// Start 启动检查协程, 重复调用不重复启动
+func (u *IdleUnloader) Start() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.stop != nil {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	u.stop, u.done = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(u.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				u.Sweep(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止检查协程, 等待正在进行的导出结束
+func (u *IdleUnloader) Stop() {
+	u.mu.Lock()
+	stop, done := u.stop, u.done
+	u.stop, u.done = nil, nil
+	u.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+var gIdleUnloader atomic.Pointer[IdleUnloader] // 注册表的空闲用户导出器, 未设置时为 nil
+
+// SetUserIdle 设置所有用户persist的空闲导出, 替换之前的设置, 应在 StartUserIdle 之前调用
+func SetUserIdle(config IdleConfig) error {
+	u, err := NewIdleUnloader(config)
+	if err != nil {
+		return err
+	}
+	if old := gIdleUnloader.Swap(u); old != nil {
+		old.Stop()
+	}
+	return nil
+}
+
+// StartUserIdle 启动空闲用户自动导出, ExitPersists 时停止
+func StartUserIdle() error {
+	u := gIdleUnloader.Load()
+	if u == nil {
+		return fmt.Errorf("%w: user idle not set", EPersistErrorInvalidConfig)
+	}
+	u.Start()
+	return nil
+}
+
+// StopUserIdle 停止空闲用户自动导出
+func StopUserIdle() {
+	if u := gIdleUnloader.Load(); u != nil {
+		u.Stop()
+	}
+}
+
+// TouchUser 记录用户访问, 未设置空闲导出时忽略
+func TouchUser(uid int32) {
+	if u := gIdleUnloader.Load(); u != nil {
+		u.Touch(uid)
+	}
+}
+
+// PinUser 用户上线, 在线期间不自动导出
+func PinUser(uid int32) {
+	if u := gIdleUnloader.Load(); u != nil {
+		u.Pin(uid)
+	}
+}
+
+// UnpinUser 用户下线, 从下线开始计算空闲时间
+func UnpinUser(uid int32) {
+	if u := gIdleUnloader.Load(); u != nil {
+		u.Unpin(uid)
+	}
+}
+
+// GetUserIdleStats 空闲用户自动导出统计
+func GetUserIdleStats() IdleStats {
+	if u := gIdleUnloader.Load(); u != nil {
+		return u.Stats()
+	}
+	return IdleStats{}
+}
+
+// SetTouchUser 读取和修改时按用户ID字段记录用户访问, 活跃用户不会被空闲导出, 应在 Run 之前调用
+// field 必须是整数类型的主键字段, 否则返回 EPersistErrorInvalidConfig
+func (g *GlobalManager[T]) SetTouchUser(field string) error {
+	idx, ok := g.meta.FieldIndex(field)
+	if ok {
+		for i, pk := range g.meta.PkIndex {
+			kind := g.meta.FieldTypes[pk].Kind()
+			if pk == idx && kind >= reflect.Int && kind <= reflect.Uint64 {
+				g.touchPk = i + 1
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: touch user field %s is not an integer primary key", EPersistErrorInvalidConfig, field)
+}
+
+// touchUser 按主键中的用户ID记录用户访问
+func (g *GlobalManager[T]) touchUser(pk []any) {
+	if g.touchPk == 0 || len(pk) < g.touchPk {
+		return
+	}
+	v := reflect.ValueOf(pk[g.touchPk-1])
+	switch {
+	case v.CanInt():
+		TouchUser(int32(v.Int()))
+	case v.CanUint():
+		TouchUser(int32(v.Uint()))
+	}
+}
+
+// SetTouchUser 所有分片读取和修改时记录用户访问, 规则同 GlobalManager.SetTouchUser
+func (s *ShardedManager[T]) SetTouchUser(field string) error {
+	for _, g := range s.shards {
+		if err := g.SetTouchUser(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package persist_test
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestIdleUnloader_Sweep 测试空闲超时的用户导出, 在线用户和被否决的用户保留, 导出失败的用户下一次重试.
+func TestIdleUnloader_Sweep(t *testing.T) {
+	if _, err := persist.NewIdleUnloader(persist.IdleConfig{}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("NewIdleUnloader() zero timeout error = %v", err)
+	}
+
+	var mu sync.Mutex
+	var unloaded []int32
+	failing := map[int32]bool{4: true}
+	u, err := persist.NewIdleUnloader(persist.IdleConfig{
+		Timeout:   time.Minute,
+		CanUnload: func(uid int32) bool { return uid != 3 },
+		Unload: func(uid int32) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if failing[uid] {
+				return errors.New("busy")
+			}
+			unloaded = append(unloaded, uid)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for uid := int32(1); uid <= 4; uid++ {
+		u.Touch(uid)
+	}
+	u.Pin(2)
+
+	if got := u.Sweep(time.Now()); len(got) != 0 {
+		t.Errorf("Sweep() before timeout = %v", got)
+	}
+	got := u.Sweep(time.Now().Add(time.Hour))
+	if !slices.Equal(got, []int32{1}) {
+		t.Errorf("Sweep() = %v, want [1]", got)
+	}
+	if stats := u.Stats(); stats.Tracked != 3 || stats.Pinned != 1 || stats.Unloads != 1 || stats.Vetoes != 1 || stats.Failures != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	// 下线后从下线开始计算空闲时间, 失败的用户重试
+	u.Unpin(2)
+	mu.Lock()
+	failing[4] = false
+	mu.Unlock()
+	got = u.Sweep(time.Now().Add(time.Hour))
+	slices.Sort(got)
+	if !slices.Equal(got, []int32{2, 4}) {
+		t.Errorf("Sweep() after unpin = %v, want [2 4]", got)
+	}
+	if stats := u.Stats(); stats.Tracked != 1 || stats.Pinned != 0 {
+		t.Errorf("Stats() after unpin = %+v", stats)
+	}
+}
+
+// TestIdleUnloader_PinDuringUnload 测试导出开始后上线的用户等待导出结束后固定, 之后不再自动导出.
+func TestIdleUnloader_PinDuringUnload(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	u, err := persist.NewIdleUnloader(persist.IdleConfig{
+		Timeout: time.Minute,
+		Unload: func(uid int32) error {
+			close(started)
+			<-release
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Touch(5)
+	swept := make(chan []int32)
+	go func() { swept <- u.Sweep(time.Now().Add(time.Hour)) }()
+	<-started
+
+	pinned := make(chan struct{})
+	go func() {
+		u.Pin(5)
+		close(pinned)
+	}()
+	select {
+	case <-pinned:
+		t.Fatal("Pin() returned while unloading")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-pinned
+	if got := <-swept; !slices.Equal(got, []int32{5}) {
+		t.Errorf("Sweep() = %v, want [5]", got)
+	}
+	if stats := u.Stats(); stats.Tracked != 1 || stats.Pinned != 1 {
+		t.Errorf("Stats() after pin = %+v", stats)
+	}
+	if got := u.Sweep(time.Now().Add(time.Hour)); len(got) != 0 {
+		t.Errorf("Sweep() pinned = %v", got)
+	}
+}
+
+// TestIdleUnloader_Start 测试后台协程自动导出空闲用户, 停止后不再导出.
+func TestIdleUnloader_Start(t *testing.T) {
+	done := make(chan int32, 1)
+	u, err := persist.NewIdleUnloader(persist.IdleConfig{
+		Timeout:  10 * time.Millisecond,
+		Interval: 5 * time.Millisecond,
+		Unload:   func(uid int32) error { return nil },
+		OnUnload: func(uid int32, err error) { done <- uid },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Touch(7)
+	u.Start()
+	select {
+	case uid := <-done:
+		if uid != 7 {
+			t.Errorf("unloaded uid = %d, want 7", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle user not unloaded")
+	}
+	u.Stop()
+
+	u.Touch(8)
+	time.Sleep(30 * time.Millisecond)
+	if stats := u.Stats(); stats.Unloads != 1 || stats.Tracked != 1 {
+		t.Errorf("Stats() after Stop = %+v", stats)
+	}
+}
+
+// TestGlobalManager_TouchUser 测试按用户ID主键读取和修改时记录访问, 持续访问的用户不会被空闲导出.
+func TestGlobalManager_TouchUser(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[ShardUserGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.SetTouchUser("Gold"); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("SetTouchUser() non pk error = %v", err)
+	}
+	if err := g.SetTouchUser("Uid"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	unloaded := make(chan int32, 1)
+	if err := persist.SetUserIdle(persist.IdleConfig{
+		Timeout:  100 * time.Millisecond,
+		Interval: 10 * time.Millisecond,
+		Unload:   func(uid int32) error { unloaded <- uid; return nil },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := persist.StartUserIdle(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(persist.StopUserIdle)
+
+	if err := g.Insert(&ShardUserGlobal{Uid: 9, Gold: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(300 * time.Millisecond); time.Now().Before(deadline); {
+		if _, ok := g.Get(int64(9)); !ok {
+			t.Fatal("Get(9) not found")
+		}
+		select {
+		case uid := <-unloaded:
+			t.Fatalf("uid %d unloaded while accessed", uid)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	select {
+	case uid := <-unloaded:
+		if uid != 9 {
+			t.Errorf("unloaded uid = %d, want 9", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle user not unloaded")
+	}
+}
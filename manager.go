@@ -1,603 +1,1208 @@
 package persist
 
-//
-// import (
-// 	"bytes"
-// 	"encoding/base64"
-// 	"encoding/binary"
-// 	"errors"
-// 	"os"
-// 	"reflect"
-// 	"runtime"
-// 	"runtime/debug"
-// 	"sync"
-// 	"sync/atomic"
-// 	"time"
-//
-// 	"xorm.io/xorm"
-// )
-//
-// var (
-// 	GlobalDBFiledMap []string // 全局数据库字段映射
-// )
-//
-// type GlobalSync[T any] struct {
-// 	Data   T               // 数据
-// 	Op     int8            // 操作类型
-// 	BitSet GlobalBitSet[T] // 位图
-// }
-//
-// // GlobalManager 全局管理器
-// type GlobalManager[T any] struct {
-// 	managerState int32 // 管理器状态
-// 	loadState    int32 // 加载状态
-//
-// 	pool     *sync.Pool // 对象池
-// 	modelNil *T
-//
-// 	syncQueue  *[]*GlobalSync[T] // 同步队列
-// 	cacheQueue *[]*GlobalSync[T] // 缓存队列
-//
-// 	FailQueue   []*GlobalSync[T] // 失败队列
-// 	InsertQueue []*GlobalSync[T] // 插入队列
-//
-// 	lastWriteBackTime time.Duration // 上次写回时间
-//
-// 	syncChan  chan *GlobalSync[T] // 同步通道
-// 	syncBegin chan bool           // 同步开始
-// 	syncEnd   chan bool           // 同步结束
-// 	exitBegin chan bool           // 退出开始
-// 	exitEnd   chan bool           // 退出结束
-//
-// 	//hashAuthId     GlobalHashAuthId
-// 	//hashAuthIdType GlobalHashAuthIdType
-// 	bitSetAll GlobalBitSet[T]
-//
-// 	engine *xorm.Engine // TODO 后期支持多种ORM数据库
-// }
-//
-// // NewGlobalManager 创建全局管理器
-// func NewGlobalManager[T any](engine *xorm.Engine) *GlobalManager[T] {
-// 	m := &GlobalManager[T]{
-// 		engine: engine,
-// 	}
-//
-// 	m.modelNil = new(T)
-// 	m.syncChan = make(chan *GlobalSync[T], runtime.NumCPU()*2)
-// 	tmpSyncQueue := make([]*GlobalSync[T], 0)
-// 	m.syncQueue = &tmpSyncQueue
-// 	m.syncEnd = make(chan bool)
-// 	m.syncBegin = make(chan bool)
-// 	m.exitBegin = make(chan bool)
-// 	m.exitEnd = make(chan bool)
-// 	tmpCacheQueue := make([]*GlobalSync[T], 0)
-// 	m.cacheQueue = &tmpCacheQueue
-// 	m.lastWriteBackTime = 1 * time.Millisecond
-// 	m.pool = &sync.Pool{
-// 		New: func() any {
-// 			return m.modelNil
-// 		},
-// 	}
-//
-// 	m.bitSetAll = InitGlobalBitSet[T]()
-// 	m.bitSetAll.SetAll()
-// 	if engine != nil {
-// 		fieldNames := GetFieldNames(m.modelNil)
-// 		GlobalDBFiledMap = make([]string, len(fieldNames))
-// 		for idx, name := range fieldNames {
-// 			GlobalDBFiledMap[idx] = engine.GetColumnMapper().Obj2Table(name)
-// 		}
-// 	}
-//
-// 	return m
-// }
-//
-// func (g *GlobalManager[T]) Sync(wg *sync.WaitGroup) (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// func (g *GlobalManager[T]) Exit(wg *sync.WaitGroup) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// // Run 启动管理器
-// func (g *GlobalManager[T]) Run() (err error) {
-// 	if atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStateIdle, EGlobalManagerStateNormal) {
-// 		// 启动失败读取崩溃恢复
-// 		if err = g.LoadFile(); err != nil {
-// 			return err
-// 		}
-// 		go g.Collect() // 启动数据收集协程
-// 	} else if atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStatePanic, EGlobalManagerStateNormal) {
-// 		// 从崩溃状态恢复
-// 		if err = g.LoadFile(); err != nil {
-// 			return err
-// 		}
-// 		go g.Collect() // 启动数据收集协程
-// 	}
-// 	return nil
-// }
-//
-// // Dead 管理器是否不可用
-// func (g *GlobalManager[T]) Dead() bool {
-// 	return atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal
-// }
-//
-// // PersistName 获取持久化名称
-// func (g *GlobalManager[T]) PersistName() string {
-// 	return reflect.TypeOf(g.modelNil).Name()
-// }
-//
-// func (g *GlobalManager[T]) RecoverBomb(bomb []byte) (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// func (g *GlobalManager[T]) SyncData(wg *sync.WaitGroup, sentryDebug bool) (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// func (g *GlobalManager[T]) RecoverTrace(trace [][]byte) (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// func (g *GlobalManager[T]) StringToPersistSyncInterface(data string) any {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// // BytesToPersistInterface bytes转化为persist
-// func (g *GlobalManager[T]) BytesToPersistInterface(data []byte) any {
-// 	return g.BytesToPersist(data)
-// }
-//
-// // PersistInterfaceToBytes persist转化为bytes
-// func (g *GlobalManager[T]) PersistInterfaceToBytes(i any) []byte {
-// 	return g.PersistToBytes(i.(*T), g.bitSetAll)
-// }
-//
-// // PersistInterfaceToPkStruct persist转化为主键
-// func (g *GlobalManager[T]) PersistInterfaceToPkStruct(i any) any {
-// 	cls, ok := i.(*T)
-// 	if !ok {
-// 		return nil
-// 	}
-//
-// 	pk, ok := GetFieldValueByTag(cls, "xorm", "pk")
-// 	if !ok {
-// 		return nil
-// 	}
-//
-// 	return pk
-// }
-//
-// func (g *GlobalManager[T]) LazyInit() (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// func (g *GlobalManager[T]) Segmentation(wg *sync.WaitGroup) (err error) {
-// 	//TODO implement me
-// 	panic("implement me")
-// }
-//
-// // PersistUserNilObjInterface 获取PersistUser对象数组的nil指针
-// func (g *GlobalManager[T]) PersistUserNilObjInterface() any {
-// 	return g.modelNil
-// }
-//
-// // PersistUserNilObjInterfaceList 返回persist any list
-// func (g *GlobalManager[T]) PersistUserNilObjInterfaceList() any {
-// 	plist := make([]*T, 0)
-// 	return &plist
-// }
-//
-// // Collect 收集数据
-// func (g *GlobalManager[T]) Collect() {
-// 	var persistSync *GlobalSync[T]
-// 	var ok bool
-// 	// 0:normal  1:exit begin, save sync  2:save cache  3:save done
-// 	var state int8
-// 	go g.Save()
-// 	g.syncBegin <- true
-// 	for {
-// 		select {
-// 		case persistSync, ok = <-g.syncChan:
-// 			if ok {
-// 				*g.cacheQueue = append(*g.cacheQueue, persistSync)
-// 			}
-// 		case _, ok = <-g.syncEnd:
-// 			if ok {
-// 				g.CheckOverload()
-// 				g.cacheQueue, g.syncQueue = g.syncQueue, g.cacheQueue
-// 				switch state {
-// 				case EGlobalCollectStateNormal:
-// 					//go m.AsyncSave()
-// 					g.syncBegin <- true
-// 				case EGlobalCollectStateSaveSync:
-// 					//go m.AsyncSave()
-// 					g.syncBegin <- true
-// 					state = EGlobalCollectStateSaveCache
-// 				case EGlobalCollectStateSaveCache:
-// 					//go m.AsyncSave()
-// 					g.syncBegin <- true
-// 					state = EGlobalCollectStateSaveDone
-// 				case EGlobalCollectStateSaveDone:
-// 					g.syncBegin <- false
-// 					<-g.syncEnd
-// 					g.exitEnd <- true
-// 					return
-// 				}
-// 			}
-// 		case _, ok = <-g.exitBegin:
-// 			if ok {
-// 				state = EGlobalCollectStateSaveSync
-// 			}
-// 		}
-// 	}
-// }
-//
-// // LoadFile 文件读取写回失败数据
-// func (g *GlobalManager[T]) LoadFile() error {
-// 	// TODO 指定路径恢复
-// 	bombExist := DirExists("_./_Users_xt_go_servers_admin_backend_data/MenusGlobal.bomb")
-// 	tmpExist := DirExists("_./_Users_xt_go_servers_admin_backend_data/MenusGlobal.tmp")
-//
-// 	if tmpExist {
-// 		return EPersistErrorTempFileExist
-// 	}
-//
-// 	if bombExist {
-// 		data, err := os.ReadFile("_./_Users_xt_go_servers_admin_backend_data/MenusGlobal.bomb")
-// 		if err != nil {
-// 			return err
-// 		}
-// 		pos := bytes.IndexByte(data, byte(' '))
-// 		if pos == -1 {
-// 			return EPersistErrorInvalidBombFile
-// 		}
-// 		persistData := data[pos+1:]
-// 		err = m.UnmarshalFailQueue(persistData, &g.FailQueue)
-// 		if err != nil {
-// 			return err
-// 		}
-//
-// 		session := g.engine.NewSession()
-// 		defer session.Close()
-//
-// 		var persistSync *GlobalSync[T]
-//
-// 		for i := range g.FailQueue {
-// 			persistSync = g.FailQueue[i]
-// 			err = g.SaveDB(session, persistSync)
-// 			if err != nil {
-// 				g.FailQueue = g.FailQueue[i:]
-// 				g.SaveFile()
-// 				return err
-// 			}
-// 		}
-// 		g.FailQueue = g.FailQueue[0:0]
-// 		g.RemoveFile()
-//
-// 	}
-// 	return nil
-// }
-//
-// // SaveDB xorm写数据库
-// func (g *GlobalManager[T]) SaveDB(session *xorm.Session, persistSync *GlobalSync[T]) (err error) {
-// 	defer func() {
-// 		if r := recover(); r != nil {
-// 			if err == nil {
-// 				err = errors.New("unknown error")
-// 			}
-// 		}
-// 	}()
-// 	switch persistSync.Op {
-// 	case EGlobalOpInsert:
-//
-// 		_, err = session.Insert(persistSync.Data)
-//
-// 		if err != nil {
-// 			log.Infoln("insert error ", err, "[sql error MenusGlobal]", g.PersistSyncToString(persistSync))
-// 			return
-// 		}
-//
-// 	case EGlobalOpUpdate:
-// 		cls := persistSync.Data
-// 		bitSet := persistSync.BitSet
-// 		if bitSet.IsSetAll() {
-// 			_, err = session.ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
-// 			if err != nil {
-// 				log.Infoln("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
-// 				return
-// 			}
-// 		} else {
-// 			var nameList []string
-// 			for idx, name := range MenusGlobalDBFiledMap {
-// 				if bitSet.Get(MenusGlobalFieldIndex(idx)) {
-// 					nameList = append(nameList, name)
-// 				}
-// 			}
-// 			if nameList != nil {
-// 				_, err = session.ID(core.NewPK(cls.AuthId)).Cols(nameList...).Update(cls)
-// 				if err != nil {
-// 					log.Infoln("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
-// 					return
-// 				}
-// 			} else {
-// 				_, err = session.ID(core.NewPK(cls.AuthId)).AllCols().Update(cls)
-// 				if err != nil {
-// 					log.Infoln("update error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
-// 					return
-// 				}
-// 			}
-// 		}
-//
-// 	case EGlobalOpDelete:
-// 		cls := persistSync.Data
-// 		_, err = session.ID(core.NewPK(cls.AuthId)).Delete(gMenusGlobalNil)
-// 		if err != nil {
-// 			log.Infoln("delete error ", err, "[sql error MenusGlobal]", m.PersistSyncToString(persistSync))
-// 			return
-// 		}
-//
-// 	}
-// 	return
-// }
-//
-// // PersistSyncToString 序列化2sync
-// func (g *GlobalManager[T]) PersistSyncToString(persistSync *GlobalSync[T]) (data string) {
-// 	buf := g.PersistSyncToBytes(persistSync)
-// 	if buf == nil {
-// 		return ""
-// 	}
-// 	data = base64.StdEncoding.EncodeToString(buf)
-// 	return
-// }
-//
-// // PersistSyncToBytes 序列化sync
-// func (g *GlobalManager[T]) PersistSyncToBytes(persistSync *GlobalSync[T]) (data []byte) {
-// 	var err error
-// 	if persistSync == nil {
-// 		return nil
-// 	}
-// 	defer func() {
-// 		if r := recover(); r != nil {
-// 			log.Infoln("recovered in ", r)
-// 			log.Infoln("stack: ", string(debug.Stack()))
-// 		}
-// 		if err != nil {
-// 			log.Infoln("PersistSyncToBytes Error", err.Error())
-// 		}
-// 	}()
-// 	size := 0
-//
-// 	var bitSetSize = (int)((GlobalFieldIndex(GetFieldNum(g.modelNil))>>EGlobalLog2WordSize)+1) * 8
-//
-// 	pData := g.PersistToBytes(persistSync.Data, persistSync.BitSet)
-// 	size += len(pData) + 1 + bitSetSize
-//
-// 	data = make([]byte, size)
-//
-// 	i := 0
-//
-// 	copy(data[i:], pData)
-// 	i += len(pData)
-// 	data[i] = uint8(persistSync.Op)
-// 	i += 1
-// 	for _, setItem := range persistSync.BitSet.set {
-// 		binary.LittleEndian.PutUint64(data[i:], setItem)
-// 		i += 8
-// 	}
-//
-// 	return
-// }
-//
-// // PersistToBytes 序列化
-// func (g *GlobalManager[T]) PersistToBytes(cls *T, bitSet GlobalBitSet[T]) (data []byte) {
-// 	var err error
-// 	if cls == nil {
-// 		return nil
-// 	}
-// 	defer func() {
-// 		if r := recover(); r != nil {
-// 		}
-// 		if err != nil {
-// 			log.Infoln("PersistToBytes Error", err.Error())
-// 		}
-// 	}()
-// 	size := 0
-//
-// 	return nil
-// }
-//
-// // BytesToPersist 反序列化
-// func (g *GlobalManager[T]) BytesToPersist(data []byte) (cls *T) {
-// 	cls = g.modelNil
-// 	return cls
-// }
-//
-// // Save 异步写回
-// func (g *GlobalManager[T]) Save() {
-// 	var exit bool
-// 	for {
-// 		// 正常退出
-// 		exit = g.AsyncSave()
-// 		if exit {
-// 			break
-// 		}
-// 	}
-// }
-//
-// // AsyncSave 异步写回
-// func (g *GlobalManager[T]) AsyncSave() (exit bool) {
-// 	var persistSync *GlobalSync[T]
-// 	var err error
-// 	var queueEmpty bool
-// 	bTime := time.Now().UnixNano()
-// 	defer func() {
-// 		if r := recover(); r != nil {
-// 			//log.Infoln("recovered in ", r)
-// 			//log.Infoln("stack: ", string(debug.Stack()))
-// 			if !queueEmpty {
-// 				//log.Infoln("save failed: incrementalSave")
-// 			}
-// 		} else {
-// 			if !queueEmpty {
-// 				if err == nil {
-// 					//log.Infoln("save success: incrementalSave")
-// 				} else {
-// 					//log.Infoln("save failed: incrementalSave")
-// 				}
-// 			}
-// 		}
-// 		g.DataToFailQueue()
-// 		g.lastWriteBackTime = time.Duration(time.Now().UnixNano() - bTime)
-// 		g.syncEnd <- true
-// 	}()
-//
-// 	needCollect := <-g.syncBegin
-// 	if len(*g.syncQueue) == 0 {
-// 		queueEmpty = true
-// 		if needCollect {
-// 			time.Sleep(time.Millisecond * 100)
-// 		} else {
-// 			exit = true
-// 		}
-// 		return
-// 	}
-// 	session := g.engine.NewSession()
-// 	defer session.Close()
-//
-// 	//log.Infoln("begin incrementalSave", bTime)
-//
-// 	if len(g.FailQueue) > 0 {
-// 		tmpQueue := make([]*GlobalSync[T], len(g.FailQueue)+len(*g.syncQueue))
-// 		copy(tmpQueue, g.FailQueue)
-// 		copy(tmpQueue[len(g.FailQueue):], *g.syncQueue)
-// 		insertQueue, otherQueue := g.MergeQueue(tmpQueue, true)
-// 		g.syncQueue = &otherQueue
-// 		g.InsertQueue = insertQueue
-// 		g.FailQueue = g.FailQueue[0:0]
-// 	} else {
-// 		insertQueue, otherQueue := g.MergeQueue(*g.syncQueue, true)
-// 		g.syncQueue = &otherQueue
-// 		g.InsertQueue = insertQueue
-// 	}
-//
-// 	multiInsertFn := func() bool {
-// 		var err error
-// 		defer func() {
-// 			if r := recover(); r != nil {
-// 				_ = session.Rollback()
-// 			} else {
-// 				if err == nil {
-// 					m.InsertQueue = m.InsertQueue[0:0]
-// 				} else {
-// 					_ = session.Rollback()
-// 				}
-// 			}
-// 		}()
-//
-// 		if len(m.InsertQueue) <= 0 {
-// 			return true
-// 		}
-// 		err = session.Begin()
-// 		if err != nil {
-// 			return false
-// 		}
-//
-// 		const num = 100
-// 		var insertArray [num]*model.MenusGlobal
-// 		length := len(m.InsertQueue)
-// 		quotient := length / num
-// 		remainder := length % num
-// 		for i := 0; i < quotient; i++ {
-// 			//fmt.Println("queue->(", i*num, "-", (i+1)*num, "): ", m.InsertQueue[i*num:(i+1)*num])
-// 			for j := 0; j < num; j++ {
-// 				insertArray[j] = m.InsertQueue[i*num+j].Data
-// 			}
-//
-// 			_, err = session.InsertMulti(insertArray[:])
-//
-// 			if err != nil {
-// 				log.Infoln("InsertMulti error ", err)
-// 				return false
-// 			}
-// 		}
-// 		if remainder != 0 {
-// 			//fmt.Println("queue->(", quotient*num, "-", length, "): ", m.InsertQueue[quotient*num:length])
-//
-// 			insertArray = [num]*model.MenusGlobal{}
-// 			for j := 0; j < remainder; j++ {
-// 				insertArray[j] = m.InsertQueue[quotient*num+j].Data
-// 			}
-//
-// 			_, err = session.InsertMulti(insertArray[:remainder])
-//
-// 			if err != nil {
-// 				log.Infoln("InsertMulti error ", err)
-// 				return false
-// 			}
-// 		}
-// 		err = session.Commit()
-// 		if err != nil {
-// 			return false
-// 		}
-// 		return true
-// 	}
-//
-// 	multiInsertSuccess := multiInsertFn()
-//
-// 	// 批量插入失败, 改为单条插入
-// 	if !multiInsertSuccess {
-// 		for idx, persistSync := range g.InsertQueue {
-// 			err = g.SaveDB(session, persistSync)
-// 			if err != nil {
-// 				g.InsertQueue = g.InsertQueue[idx:]
-// 				g.SaveFile()
-// 				return
-// 			}
-// 		}
-// 		g.InsertQueue = m.InsertQueue[0:0]
-// 	}
-//
-// 	for i := 0; i < len(*g.syncQueue); i++ {
-// 		persistSync = (*m.syncQueue)[i]
-// 		err = g.SaveDB(session, persistSync)
-// 		if err != nil {
-// 			*m.syncQueue = (*m.syncQueue)[i:]
-// 			m.SaveFile()
-// 			return
-// 		}
-// 	}
-// 	*g.syncQueue = (*g.syncQueue)[0:0]
-// 	g.RemoveFile()
-// 	return
-// }
-//
-// // DataToFailQueue 未写入成功数据, 添加到失败队列
-// func (g *GlobalManager[T]) DataToFailQueue() {
-// 	var persistSync *GlobalSync[T]
-//
-// 	// 插入队列数据添加到失败队列
-// 	g.FailQueue = append(g.FailQueue, g.InsertQueue...)
-// 	// 清空插入队列
-// 	g.InsertQueue = g.InsertQueue[0:0]
-//
-// 	// 一旦失败标记所有的数据都是失败, 不允许导出
-// 	for i := 0; i < len(*g.syncQueue); i++ {
-// 		persistSync = (*g.syncQueue)[i]
-// 		switch persistSync.Op {
-// 		case EGlobalOpInsert, EGlobalOpUpdate, EGlobalOpDelete:
-// 			g.FailQueue = append(g.FailQueue, persistSync)
-// 		default:
-// 		}
-// 	}
-// 	// 清空同步队列
-// 	*g.syncQueue = (*g.syncQueue)[0:0]
-// }
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/names"
+	"xorm.io/xorm/schemas"
+)
+
+const eGlobalInsertMultiNum = 100 // 批量插入每批数量
+
+// GlobalSync 写回队列中的一次修改
+type GlobalSync[T any] struct {
+	Data    *T              // 数据
+	Op      int8            // 操作类型
+	BitSet  GlobalBitSet[T] // 位图
+	Version int64           // 修改前的版本号, 有版本号字段时作为写回条件
+
+	replay bool // 来自失败队列, 可能已经提交但未收到结果
+}
+
+// GlobalManager 全局管理器
+// 数据在内存中修改后立即可见, 由后台协程批量写回数据库, 写回失败的数据保存到bomb文件, 下次启动时恢复
+type GlobalManager[T any] struct {
+	managerState int32       // 管理器状态
+	loadState    int32       // 加载状态
+	warming      atomic.Bool // 正在预热全导入, 不接收读取和修改
+
+	modelNil *T
+	name     string // persist名
+
+	rows       *GenericConcurrentMap[any, T] // 内存数据, 主键 -> 数据
+	meta       *GlobalMeta                   // 结构体元数据
+	dbFieldMap []string                      // 字段序号 -> 数据库列名, 非数据库字段为空
+
+	syncQueue  *[]*GlobalSync[T] // 同步队列
+	cacheQueue *[]*GlobalSync[T] // 缓存队列
+	cacheIndex map[any]int       // 主键 -> 缓存队列中该主键最后一次修改的位置
+
+	FailQueue   []*GlobalSync[T] // 失败队列
+	InsertQueue []*GlobalSync[T] // 插入队列
+
+	lastWriteBackTime atomic.Int64                // 上一轮写回耗时, 纳秒
+	lastFlush         atomic.Int64                // 最近一次成功写回的时间, 纳秒
+	lastError         atomic.Pointer[healthError] // 最近一次错误
+
+	opMu      sync.Mutex          // 保证修改内存数据与发送同步的顺序一致, 退出时阻止新的修改
+	syncChan  chan *GlobalSync[T] // 同步通道
+	syncBegin chan bool           // 同步开始
+	syncEnd   chan bool           // 同步结束
+	exitBegin chan bool           // 退出开始
+	exitEnd   chan bool           // 退出结束
+	crash     chan *GlobalPanic   // 写回协程崩溃时发送给收集协程
+
+	restart     GlobalRestartConfig         // 自动重启配置
+	restartMu   sync.Mutex                  // 崩溃处理, 自动重启, Run 和 Exit 互斥
+	restarts    atomic.Int64                // 自动重启成功的次数
+	generation  atomic.Int64                // 监督协程的代数, 旧的监督协程不再重启
+	lastPanic   atomic.Pointer[GlobalPanic] // 最近一次崩溃
+	panicked    *GlobalPanic                // 本次运行中写回协程的崩溃, 只在收集协程中使用
+	saveRunning bool                        // 写回协程未退出, 只在收集协程中使用
+	saving      bool                        // 已开始的一轮写回未结束, 只在收集协程中使用
+	exiting     bool                        // 已收到退出开始, 只在收集协程中使用
+	stopSaving  bool                        // 收集协程崩溃, 写回协程不再写回直接退出
+
+	bitSetAll GlobalBitSet[T]
+
+	snapshotDiff atomic.Bool // 修改时与内存快照比较计算位图
+	upsertReplay bool        // 重放时新建使用 Upsert
+
+	conflictPolicy GlobalConflictPolicy // 版本号冲突处理策略
+	conflictMerge  func(local, db *T) *T
+
+	overload    GlobalOverloadConfig // 过载保护配置
+	overloaded  atomic.Bool          // 是否过载
+	pending     atomic.Int64         // 未写回的修改数量
+	failNum     atomic.Int64         // 失败队列长度, 每轮写回结束时更新
+	batchNum    int                  // 正在写回的修改数量, 只在收集协程中使用
+	syncSize    int64                // 每条修改的估算内存
+	reliefMu    sync.Mutex           // 保护 relief 与过载状态一致
+	relief      chan struct{}        // 解除过载时关闭, 唤醒阻塞的修改
+	spillSeqs   []uint64             // 未读取的溢出文件序号, 按溢出顺序
+	spillNext   uint64               // 下一个溢出文件序号
+	spillLoaded []string             // 本轮写回读取的溢出文件
+
+	flush         GlobalFlushConfig // 写回调度配置
+	flushRounds   atomic.Int64      // 写回轮数
+	flushBatches  atomic.Int64      // 达到批量阈值提前开始的轮数
+	flushTimers   atomic.Int64      // 到达写回间隔开始的轮数
+	flushInterval atomic.Int64      // 最近一次计算的写回间隔, 纳秒
+	lastBatch     atomic.Int64      // 最近一轮写回的修改数量
+
+	purge     GlobalPurgeConfig // 软删除清理配置
+	purgeStop chan struct{}     // 关闭时停止清理协程
+	purgeDone chan struct{}     // 清理协程退出时关闭
+
+	segment *globalSegment // 按时间分表, 未分表时为 nil
+	table   string         // 指定的表名, 为空时按结构体映射
+	replica *globalReplica // 数据库组的从库, 未使用数据库组时为 nil
+
+	readThrough bool                   // 按主键导入
+	touchPk     int                    // 记录用户访问的用户ID在主键中的位置加1, 为0时不记录
+	keyMu       sync.Mutex             // 保护 keyLoads
+	keyLoads    map[any]*globalKeyLoad // 主键 -> 按主键导入的状态
+	unloadedAt  map[any]time.Time      // 主键 -> 使用从库时的导出时间, 受 keyMu 保护
+	unloadPrune int                    // unloadedAt 达到该数量时清理过期的导出时间
+	keyMisses   int                    // 上次清理后记录的数据库中不存在的主键数量, 受 keyMu 保护
+
+	reloaded map[any]globalReload // 主键 -> 冲突重新读取后的版本号, 受 opMu 保护
+
+	evict       GlobalEvictConfig // 淘汰配置
+	evictStop   chan struct{}     // 关闭时停止淘汰协程
+	evictDone   chan struct{}     // 淘汰协程退出时关闭
+	cacheHits   atomic.Int64      // 按主键导入命中内存的次数
+	cacheMisses atomic.Int64      // 按主键导入查询数据库的次数
+	evictions   atomic.Int64      // 淘汰移除的主键数量
+
+	engine *xorm.Engine // TODO 后期支持多种ORM数据库
+}
+
+// NewGlobalManager 创建全局管理器, engine 为 nil 时需要惰性注册, 在 LazyInit 中获取数据库连接
+func NewGlobalManager[T any](engine *xorm.Engine) *GlobalManager[T] {
+	g := &GlobalManager[T]{
+		engine: engine,
+	}
+
+	g.modelNil = new(T)
+	g.meta = GetGlobalMeta[T]()
+	g.name = g.meta.Type.Name()
+	g.rows = NewGenericConcurrentMap[any, T]()
+	g.syncChan = make(chan *GlobalSync[T], runtime.NumCPU()*2)
+	tmpSyncQueue := make([]*GlobalSync[T], 0)
+	g.syncQueue = &tmpSyncQueue
+	g.syncEnd = make(chan bool)
+	g.syncBegin = make(chan bool)
+	g.exitBegin = make(chan bool)
+	g.exitEnd = make(chan bool)
+	g.crash = make(chan *GlobalPanic, 1)
+	tmpCacheQueue := make([]*GlobalSync[T], 0)
+	g.cacheQueue = &tmpCacheQueue
+	g.cacheIndex = make(map[any]int)
+	g.keyLoads = make(map[any]*globalKeyLoad)
+	g.unloadedAt = make(map[any]time.Time)
+	g.reloaded = make(map[any]globalReload)
+	g.flush = DefaultFlushConfig
+	g.restart = DefaultRestartConfig
+	g.upsertReplay = true
+
+	g.bitSetAll = InitGlobalBitSet[T]()
+	g.bitSetAll.SetAll()
+	g.initFields()
+	g.relief = make(chan struct{})
+	g.syncSize = int64(g.meta.Type.Size()+unsafe.Sizeof(GlobalSync[T]{})) + int64(len(g.bitSetAll.set))*8
+
+	return g
+}
+
+// initFields 从元数据获取主键, 按数据库连接的映射规则生成列名
+func (g *GlobalManager[T]) initFields() {
+	var mapper names.Mapper = names.SnakeMapper{}
+	if g.engine != nil {
+		mapper = g.engine.GetColumnMapper()
+	}
+	g.dbFieldMap = g.meta.ColumnsWith(mapper)
+}
+
+// SetTable 指定写入的表名, 分表时作为分表名的前缀, 应在 Sync 之前调用
+func (g *GlobalManager[T]) SetTable(name string) {
+	g.table = name
+}
+
+// baseTable 写入的表名, 未指定时按结构体映射
+func (g *GlobalManager[T]) baseTable() string {
+	if g.table != "" {
+		return g.table
+	}
+	return g.engine.TableName(new(T))
+}
+
+// Sync 同步表结构, 分表时查找已有的分表并创建当前和之后的分表
+func (g *GlobalManager[T]) Sync(wg *sync.WaitGroup) (err error) {
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	if g.segment != nil {
+		if err = g.scanSegments(); err != nil {
+			return err
+		}
+		return g.segmentation(time.Now())
+	}
+	if g.table != "" {
+		return g.engine.Table(g.table).Sync(new(T))
+	}
+	return g.engine.Sync(new(T))
+}
+
+// Exit 退出管理器, 写回所有数据后返回, 写回失败的数据保存在失败队列和bomb文件中
+// 崩溃状态时未写回的数据已保存在bomb文件中, 停止自动重启
+func (g *GlobalManager[T]) Exit(wg *sync.WaitGroup) {
+	for {
+		g.opMu.Lock()
+		running := atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStateNormal, EGlobalManagerStateIdle)
+		g.opMu.Unlock()
+		if running {
+			break
+		}
+		if !g.exitPanic() {
+			return
+		}
+	}
+	g.exitBegin <- true
+	<-g.exitEnd
+	g.stopPurge()
+	g.stopEvict()
+}
+
+// Run 启动管理器, 崩溃状态时写回bomb文件后重新启动收集协程
+func (g *GlobalManager[T]) Run() (err error) {
+	if atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStateIdle, EGlobalManagerStateNormal) {
+		// 启动失败读取崩溃恢复
+		if err = g.LoadFile(); err != nil {
+			atomic.StoreInt32(&g.managerState, EGlobalManagerStateIdle)
+			return err
+		}
+		g.scanSpill()
+		go g.supervise() // 启动数据收集协程
+		g.startPurge()
+		g.startEvict()
+		return nil
+	}
+
+	g.restartMu.Lock()
+	defer g.restartMu.Unlock()
+	if atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStatePanic, EGlobalManagerStateNormal) {
+		// 从崩溃状态恢复, 清理和淘汰协程仍在运行
+		if err = g.LoadFile(); err != nil {
+			atomic.StoreInt32(&g.managerState, EGlobalManagerStatePanic)
+			return err
+		}
+		g.scanSpill()
+		go g.supervise() // 启动数据收集协程
+	}
+	return nil
+}
+
+// Dead 管理器是否不可用
+func (g *GlobalManager[T]) Dead() bool {
+	return atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal
+}
+
+// PersistName 获取持久化名称
+func (g *GlobalManager[T]) PersistName() string {
+	return g.name
+}
+
+// RecoverBomb 恢复数据通过 bomb数据, 按顺序写回数据库, 不修改bomb文件
+func (g *GlobalManager[T]) RecoverBomb(bomb []byte) (err error) {
+	header, records, err := DecodeBomb(bomb)
+	if err != nil {
+		return err
+	}
+	if header.Name != g.name {
+		return fmt.Errorf("%w: persist %s, want %s", EPersistErrorInvalidBombFile, header.Name, g.name)
+	}
+	return g.RecoverTrace(records)
+}
+
+// SyncData 将失败队列写回数据库, 只能在 Exit 之后调用, 写回失败时保存bomb文件
+func (g *GlobalManager[T]) SyncData(wg *sync.WaitGroup, sentryDebug bool) (err error) {
+	if atomic.LoadInt32(&g.managerState) == EGlobalManagerStateNormal {
+		return EPersistErrorIncorrectState
+	}
+	if len(g.FailQueue) == 0 {
+		return nil
+	}
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+
+	session := g.engine.NewSession()
+	defer session.Close()
+
+	for i, persistSync := range g.FailQueue {
+		if err = g.ReplayDB(session, persistSync); err != nil {
+			g.FailQueue = g.FailQueue[i:]
+			if fileErr := g.SaveFile(); fileErr != nil {
+				return errors.Join(err, fileErr)
+			}
+			return err
+		}
+	}
+	g.FailQueue = g.FailQueue[0:0]
+	return g.RemoveFile()
+}
+
+// RecoverTrace 恢复数据通过 trace数据, 按顺序写回数据库
+func (g *GlobalManager[T]) RecoverTrace(trace [][]byte) (err error) {
+	queue, err := g.BytesToPersistSyncQueue(trace)
+	if err != nil {
+		return err
+	}
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+
+	session := g.engine.NewSession()
+	defer session.Close()
+
+	for _, persistSync := range queue {
+		if err = g.ReplayDB(session, persistSync); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StringToPersistSyncInterface base64数据转化为 *GlobalSync[T], 失败返回 nil
+func (g *GlobalManager[T]) StringToPersistSyncInterface(data string) any {
+	buf, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil
+	}
+	persistSync, err := g.BytesToPersistSync(buf)
+	if err != nil {
+		return nil
+	}
+	return persistSync
+}
+
+// BytesToPersistInterface bytes转化为persist
+func (g *GlobalManager[T]) BytesToPersistInterface(data []byte) any {
+	return g.BytesToPersist(data)
+}
+
+// PersistInterfaceToBytes persist转化为bytes
+func (g *GlobalManager[T]) PersistInterfaceToBytes(i any) []byte {
+	cls, ok := i.(*T)
+	if !ok {
+		return nil
+	}
+	return g.PersistToBytes(cls, g.bitSetAll)
+}
+
+// PersistInterfaceToPkStruct persist转化为主键
+func (g *GlobalManager[T]) PersistInterfaceToPkStruct(i any) any {
+	cls, ok := i.(*T)
+	if !ok || cls == nil || len(g.meta.PkIndex) == 0 {
+		return nil
+	}
+	if len(g.meta.PkIndex) == 1 {
+		return g.pkValues(cls)[0]
+	}
+	return schemas.PK(g.pkValues(cls))
+}
+
+// LazyInit 惰性注册初始化, 获取数据库连接并注册
+func (g *GlobalManager[T]) LazyInit() (err error) {
+	if g.engine == nil {
+		g.engine = GetDatabaseDB()
+	}
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	g.initFields()
+	RegisterPersist(g)
+	return nil
+}
+
+// Segmentation 提前创建之后周期的表, 由定时任务调用, 未配置分表时不处理
+// 数据按时间字段写入对应的表, 不需要在周期边界切换写回队列
+func (g *GlobalManager[T]) Segmentation(wg *sync.WaitGroup) (err error) {
+	if g.segment == nil {
+		return nil
+	}
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	return g.segmentation(time.Now())
+}
+
+// PersistUserNilObjInterface 获取PersistUser对象数组的nil指针
+func (g *GlobalManager[T]) PersistUserNilObjInterface() any {
+	return g.modelNil
+}
+
+// PersistUserNilObjInterfaceList 返回persist any list
+func (g *GlobalManager[T]) PersistUserNilObjInterfaceList() any {
+	plist := make([]*T, 0)
+	return &plist
+}
+
+// LoadAll 全导入数据表到内存, 应在 Run 恢复bomb文件之后调用
+func (g *GlobalManager[T]) LoadAll() (err error) {
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	if !atomic.CompareAndSwapInt32(&g.loadState, EGlobalTableStateDisk, EGlobalTableStateLoading) {
+		return EPersistErrorIncorrectState
+	}
+
+	var list []*T
+	session := g.readEngine().NewSession()
+	defer session.Close()
+	if table := g.loadTable(); table != "" {
+		// 分表时导入当前周期的表
+		session.Table(table)
+	}
+	if err = session.Find(&list); err != nil {
+		atomic.StoreInt32(&g.loadState, EGlobalTableStateDisk)
+		return err
+	}
+	for _, cls := range list {
+		// 导入期间新建的数据以内存为准
+		g.rows.LoadOrStore(g.pkKey(cls), cls)
+	}
+	atomic.StoreInt32(&g.loadState, EGlobalTableStateMemory)
+	return nil
+}
+
+// LoadState 数据表导入状态
+func (g *GlobalManager[T]) LoadState() int32 {
+	return atomic.LoadInt32(&g.loadState)
+}
+
+// Get 按主键获取数据副本, 联合主键按字段顺序传入
+// 开启按主键导入时, 不在内存中的主键从数据库导入, 导入失败返回false
+func (g *GlobalManager[T]) Get(pk ...any) (cls *T, ok bool) {
+	if g.warming.Load() {
+		return nil, false
+	}
+	g.touchUser(pk)
+	key := pkKeyOf(pk)
+	row, ok := g.rows.Load(key)
+	if g.readThrough && g.LoadState() != EGlobalTableStateMemory && len(pk) == len(g.meta.PkIndex) {
+		if ok {
+			g.cacheHits.Add(1)
+			g.touchKey(key)
+		} else {
+			if err := g.LoadKey(pk...); err != nil {
+				return nil, false
+			}
+			row, ok = g.rows.Load(g.keyOf(pk))
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	cls, err := g.cloneModel(row)
+	if err != nil {
+		g.logError("get", err, nil)
+		return nil, false
+	}
+	return cls, true
+}
+
+// Range 遍历内存数据副本, fn 返回false时停止, 无法复制的数据记录错误后跳过
+func (g *GlobalManager[T]) Range(fn func(cls *T) bool) {
+	g.rows.Range(func(key any, row *T) bool {
+		cls, err := g.cloneModel(row)
+		if err != nil {
+			g.logError("range", err, nil)
+			return true
+		}
+		return fn(cls)
+	})
+}
+
+// Insert 新建数据, 有版本号字段时 cls 的版本号设置为1, 分表的时间字段为零值时设置为当前时间
+func (g *GlobalManager[T]) Insert(cls *T) (err error) {
+	if cls == nil {
+		return EPersistErrorNil
+	}
+	if g.warming.Load() {
+		return EPersistErrorNotInMemory
+	}
+	if err = g.waitOverload(); err != nil {
+		return err
+	}
+	pk := g.pkValues(cls)
+	g.touchUser(pk)
+	if err = g.lockKey(pk); err != nil {
+		return err
+	}
+	defer g.opMu.Unlock()
+	if atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal {
+		return EPersistErrorIncorrectState
+	}
+
+	g.stampSegment(cls)
+	row, err := g.cloneModel(cls)
+	if err != nil {
+		return err
+	}
+	if g.hasVersion() {
+		// 与 xorm 新建时写入的版本号一致
+		g.setVersion(row, 1)
+	}
+	// 写回队列使用独立的深拷贝, 返回后修改 cls 不影响未写回的数据
+	data, err := ClonePersist(row)
+	if err != nil {
+		return err
+	}
+	key := g.pkKey(row)
+	if _, loaded := g.rows.LoadOrStore(key, row); loaded {
+		return EPersistErrorAlreadyExist
+	}
+	if g.readThrough {
+		g.keepKey(key)
+	}
+	if g.hasVersion() {
+		g.setVersion(cls, 1)
+	}
+	g.syncChan <- &GlobalSync[T]{Data: data, Op: EGlobalOpInsert, BitSet: g.bitSetAll.Clone()}
+	return nil
+}
+
+// SetSnapshotDiff 开启后 Update 与内存中的快照逐字段比较, 自动标记修改的字段, 应在 Run 之前调用
+// 快照和 Get 返回的数据均为深拷贝, 修改返回数据中的切片和map不会影响快照
+func (g *GlobalManager[T]) SetSnapshotDiff(enable bool) {
+	g.snapshotDiff.Store(enable)
+}
+
+// Update 修改数据, bitSet 标记修改的字段, 未标记任何字段时写回所有字段
+// 开启快照比较时 bitSet 与比较结果合并, 没有任何修改时不写回
+// 有版本号字段时 cls 的版本号与内存数据不一致返回 EPersistErrorOutOfDate, 成功后 cls 的版本号加1
+func (g *GlobalManager[T]) Update(cls *T, bitSet GlobalBitSet[T]) (err error) {
+	if cls == nil {
+		return EPersistErrorNil
+	}
+	if g.warming.Load() {
+		return EPersistErrorNotInMemory
+	}
+	if err = g.waitOverload(); err != nil {
+		return err
+	}
+	pk := g.pkValues(cls)
+	g.touchUser(pk)
+	if err = g.lockKey(pk); err != nil {
+		return err
+	}
+	defer g.opMu.Unlock()
+	if atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal {
+		return EPersistErrorIncorrectState
+	}
+
+	row, err := g.cloneModel(cls)
+	if err != nil {
+		return err
+	}
+	key := g.pkKey(row)
+	old, ok := g.rows.Load(key)
+	if !ok {
+		return EPersistErrorNotInMemory
+	}
+	var version int64
+	if g.hasVersion() {
+		if version = g.versionOf(old); g.versionOf(row) != version {
+			return EPersistErrorOutOfDate
+		}
+	}
+	if g.snapshotDiff.Load() {
+		diff := DiffPersist(old, row)
+		diff.Merge(bitSet)
+		if diff.IsEmpty() {
+			return nil
+		}
+		bitSet = diff
+	}
+	if bitSet.IsEmpty() {
+		bitSet = g.bitSetAll
+	}
+	bitSet = bitSet.Clone()
+	if g.hasVersion() {
+		g.setVersion(row, version+1)
+		g.setVersion(cls, version+1)
+		bitSet.Set(GlobalFieldIndex(g.meta.Version))
+	}
+	data, err := ClonePersist(row)
+	if err != nil {
+		return err
+	}
+	g.rows.Store(key, row)
+	if g.hasVersion() {
+		version = g.syncVersion(key, version)
+	}
+	g.syncChan <- &GlobalSync[T]{Data: data, Op: EGlobalOpUpdate, BitSet: bitSet, Version: version}
+	return nil
+}
+
+// Delete 按主键删除数据, 联合主键按字段顺序传入
+func (g *GlobalManager[T]) Delete(pk ...any) (err error) {
+	if g.warming.Load() {
+		return EPersistErrorNotInMemory
+	}
+	if err = g.waitOverload(); err != nil {
+		return err
+	}
+	g.touchUser(pk)
+	if err = g.lockKey(pk); err != nil {
+		return err
+	}
+	defer g.opMu.Unlock()
+	if atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal {
+		return EPersistErrorIncorrectState
+	}
+
+	row, ok := g.rows.LoadAndDelete(pkKeyOf(pk))
+	if !ok {
+		return EPersistErrorNotInMemory
+	}
+	delete(g.reloaded, pkKeyOf(pk))
+	g.syncChan <- &GlobalSync[T]{Data: row, Op: EGlobalOpDelete, BitSet: g.bitSetAll.Clone()}
+	return nil
+}
+
+// cloneModel 复制内存数据, 开启快照比较时深拷贝, 深拷贝失败时返回错误
+func (g *GlobalManager[T]) cloneModel(cls *T) (*T, error) {
+	if g.snapshotDiff.Load() {
+		return ClonePersist(cls)
+	}
+	cp := new(T)
+	*cp = *cls
+	return cp, nil
+}
+
+// pkValues 主键字段值
+func (g *GlobalManager[T]) pkValues(cls *T) []any {
+	v := reflect.ValueOf(cls).Elem()
+	values := make([]any, len(g.meta.PkIndex))
+	for i, idx := range g.meta.PkIndex {
+		values[i] = v.Field(int(idx)).Interface()
+	}
+	return values
+}
+
+// pkKey 内存数据的主键
+func (g *GlobalManager[T]) pkKey(cls *T) any {
+	return pkKeyOf(g.pkValues(cls))
+}
+
+// pkKeyOf 主键值转化为map key, 联合主键拼接为字符串
+func pkKeyOf(values []any) any {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return fmt.Sprint(values...)
+}
+
+// Collect 收集数据
+// 上一轮写回结束后按写回调度等待下一轮, 缓存队列达到批量阈值时立即开始
+func (g *GlobalManager[T]) Collect() {
+	var persistSync *GlobalSync[T]
+	var ok bool
+	// 0:normal  1:exit begin, save sync  2:save cache  3:save done
+	var state int8
+	var flushC <-chan time.Time // 下一轮写回定时器, 为nil时等待新的修改
+	var waiting bool            // 上一轮写回已结束, 等待下一轮开始
+	g.saveRunning = true
+	go g.Save()
+	g.beginSync(&state)
+	for {
+		select {
+		case persistSync, ok = <-g.syncChan:
+			if ok {
+				g.appendCache(persistSync)
+				if g.CheckOverload() && g.cacheFull() {
+					_ = g.spillCache()
+				}
+				if !waiting {
+					break
+				}
+				if g.failNum.Load() == 0 && g.flush.batchFull(len(*g.cacheQueue)) {
+					g.flushBatches.Add(1)
+					waiting, flushC = false, nil
+					g.beginSync(&state)
+				} else if flushC == nil {
+					flushC = time.After(g.flush.MinInterval)
+				}
+			}
+		case <-flushC:
+			g.flushTimers.Add(1)
+			waiting, flushC = false, nil
+			g.beginSync(&state)
+		case _, ok = <-g.syncEnd:
+			if ok {
+				g.saving = false
+				if g.panicked = g.takeCrash(); g.panicked != nil {
+					// 写回协程崩溃, 由监督协程保存队列后重启
+					return
+				}
+				// 上一轮写回结束, 按写回结果更新过载状态后准备下一轮
+				g.batchNum = 0
+				g.removeSpilled()
+				g.CheckOverload()
+				if state != EGlobalCollectStateNormal {
+					if g.beginSync(&state) {
+						return
+					}
+					break
+				}
+				wait, pending := g.nextFlush()
+				if pending && g.failNum.Load() == 0 && g.flush.batchFull(len(*g.cacheQueue)) {
+					g.flushBatches.Add(1)
+					g.beginSync(&state)
+					break
+				}
+				waiting = true
+				if pending {
+					flushC = time.After(wait)
+				}
+			}
+		case _, ok = <-g.exitBegin:
+			if ok {
+				// 退出时已不再接收修改, 取出通道中剩余的数据
+				g.exiting = true
+				for len(g.syncChan) > 0 {
+					g.appendCache(<-g.syncChan)
+				}
+				state = EGlobalCollectStateSaveSync
+				if waiting {
+					waiting, flushC = false, nil
+					g.beginSync(&state)
+				}
+			}
+		}
+	}
+}
+
+// beginSync 交换缓存队列和同步队列, 开始下一轮写回, 退出完成时返回true
+func (g *GlobalManager[T]) beginSync(state *int8) (exit bool) {
+	g.rotateSpill(*state != EGlobalCollectStateNormal)
+	g.cacheQueue, g.syncQueue = g.syncQueue, g.cacheQueue
+	clear(g.cacheIndex)
+	g.batchNum = len(*g.syncQueue)
+	g.lastBatch.Store(int64(g.batchNum))
+	g.flushRounds.Add(1)
+	g.CheckOverload()
+	switch *state {
+	case EGlobalCollectStateNormal:
+		g.syncBegin <- true
+	case EGlobalCollectStateSaveSync:
+		g.syncBegin <- true
+		// 溢出文件全部读取后才继续退出
+		if len(g.spillSeqs) == 0 {
+			*state = EGlobalCollectStateSaveCache
+		}
+	case EGlobalCollectStateSaveCache:
+		g.syncBegin <- true
+		*state = EGlobalCollectStateSaveDone
+	case EGlobalCollectStateSaveDone:
+		g.syncBegin <- false
+		g.saving = true
+		<-g.syncEnd
+		g.saving, g.saveRunning = false, false
+		if p := g.takeCrash(); p != nil {
+			// 最后一轮写回崩溃, 未写回的修改已在失败队列中
+			g.recordPanic(p)
+			_ = g.SaveFile()
+		}
+		g.removeSpilled()
+		g.setOverloaded(false)
+		g.exitEnd <- true
+		return true
+	}
+	g.saving = true
+	return false
+}
+
+// LoadFile 文件读取写回失败数据
+func (g *GlobalManager[T]) LoadFile() error {
+	if DirExists(TmpFilePath(g.name)) {
+		return EPersistErrorTempFileExist
+	}
+	if !DirExists(BombFilePath(g.name)) {
+		return nil
+	}
+
+	data, err := os.ReadFile(BombFilePath(g.name))
+	if err != nil {
+		return err
+	}
+	header, records, err := DecodeBomb(data)
+	if err != nil {
+		return err
+	}
+	if header.Name != g.name {
+		return fmt.Errorf("%w: persist %s, want %s", EPersistErrorInvalidBombFile, header.Name, g.name)
+	}
+	if g.FailQueue, err = g.BytesToPersistSyncQueue(records); err != nil {
+		return err
+	}
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+
+	session := g.engine.NewSession()
+	defer session.Close()
+
+	var persistSync *GlobalSync[T]
+
+	for i := range g.FailQueue {
+		persistSync = g.FailQueue[i]
+		err = g.ReplayDB(session, persistSync)
+		if err != nil {
+			g.FailQueue = g.FailQueue[i:]
+			_ = g.SaveFile()
+			return err
+		}
+	}
+	g.FailQueue = g.FailQueue[0:0]
+	return g.RemoveFile()
+}
+
+// SaveFile 未写回的数据保存到bomb文件
+func (g *GlobalManager[T]) SaveFile() (err error) {
+	queue := make([]*GlobalSync[T], 0, len(g.FailQueue)+len(g.InsertQueue)+len(*g.syncQueue))
+	queue = append(queue, g.FailQueue...)
+	queue = append(queue, g.InsertQueue...)
+	queue = append(queue, *g.syncQueue...)
+
+	records := make([][]byte, 0, len(queue))
+	for _, persistSync := range queue {
+		if data := g.PersistSyncToBytes(persistSync); data != nil {
+			records = append(records, data)
+		}
+	}
+	header := NewBombHeader(g.name, EBombKindBomb, len(records))
+	if err = WriteBombFile(BombFilePath(g.name), header, records); err != nil {
+		g.logError("save file", err, nil)
+	}
+	return err
+}
+
+// RemoveFile 全部写回成功后删除bomb文件
+func (g *GlobalManager[T]) RemoveFile() error {
+	err := os.Remove(BombFilePath(g.name))
+	if err != nil && !os.IsNotExist(err) {
+		g.logError("remove file", err, nil)
+		return err
+	}
+	return nil
+}
+
+// SaveDB xorm写数据库
+func (g *GlobalManager[T]) SaveDB(session *xorm.Session, persistSync *GlobalSync[T]) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err == nil {
+				err = fmt.Errorf("%w: %v", EPersistErrorUnknownError, r)
+			}
+		}
+	}()
+	if persistSync.Op == EGlobalOpUnload {
+		// 之前的修改都已写回
+		g.finishUnload(persistSync)
+		return
+	}
+	if err = g.ensureSegment(persistSync.Data); err != nil {
+		return
+	}
+	switch persistSync.Op {
+	case EGlobalOpInsert:
+		if g.softDelete() {
+			// 主键可能存在已标记删除的行, 覆盖后恢复
+			err = g.Upsert(session, persistSync.Data)
+		} else {
+			_, err = g.on(session, persistSync.Data).NoVersionCheck().Insert(persistSync.Data)
+		}
+		if err != nil {
+			g.logError("insert", err, persistSync)
+			return
+		}
+
+	case EGlobalOpUpdate:
+		cls := persistSync.Data
+		pk := schemas.PK(g.pkValues(cls))
+		nameList := g.bitSetCols(persistSync.BitSet)
+		if g.hasVersion() {
+			err = g.updateVersion(session, persistSync, nameList)
+		} else if nameList != nil {
+			_, err = g.on(session, cls).ID(pk).Cols(nameList...).Update(cls)
+		} else {
+			_, err = g.on(session, cls).ID(pk).AllCols().Update(cls)
+		}
+		if err != nil {
+			g.logError("update", err, persistSync)
+			return
+		}
+
+	case EGlobalOpDelete:
+		cls := persistSync.Data
+		_, err = g.on(session, cls).ID(schemas.PK(g.pkValues(cls))).Delete(new(T))
+		if err != nil {
+			g.logError("delete", err, persistSync)
+			return
+		}
+
+	case EGlobalOpReplace:
+		// 删除后新建, 重试时删除不存在的行不会失败; 软删除时删除只是标记, 直接覆盖
+		cls := persistSync.Data
+		if g.softDelete() {
+			if err = g.Upsert(session, cls); err != nil {
+				g.logError("replace", err, persistSync)
+			}
+			return
+		}
+		_, err = g.on(session, cls).ID(schemas.PK(g.pkValues(cls))).Delete(new(T))
+		if err != nil {
+			g.logError("replace", err, persistSync)
+			return
+		}
+		_, err = g.on(session, cls).NoVersionCheck().Insert(cls)
+		if err != nil {
+			g.logError("replace", err, persistSync)
+			return
+		}
+
+	}
+	return
+}
+
+// Cols 位图标记的数据库列名, 按数据库连接的映射规则转换, 可直接传给 xorm Cols()
+func (g *GlobalManager[T]) Cols(bitSet GlobalBitSet[T]) []string {
+	return bitSet.ColsWith(g.dbFieldMap)
+}
+
+// bitSetCols 位图标记的数据库列名, 标记全部字段或未标记任何字段时返回 nil
+func (g *GlobalManager[T]) bitSetCols(bitSet GlobalBitSet[T]) (nameList []string) {
+	nameList = g.Cols(bitSet)
+	all := 0
+	for _, name := range g.dbFieldMap {
+		if name != "" {
+			all++
+		}
+	}
+	if len(nameList) == all {
+		return nil
+	}
+	return nameList
+}
+
+// logError 输出写回错误
+func (g *GlobalManager[T]) logError(op string, err error, persistSync *GlobalSync[T]) {
+	g.recordError(err)
+	msg := &Error{Err: fmt.Errorf("%s %s: %w", g.name, op, err), Type: ErrorTypeOp}
+	if persistSync != nil {
+		msg.Err = fmt.Errorf("%w [sql error %s] %s", msg.Err, g.name, g.PersistSyncToString(persistSync))
+	}
+	msg.Println(DefaultErrorWriter)
+}
+
+// PersistSyncToString 序列化2sync
+func (g *GlobalManager[T]) PersistSyncToString(persistSync *GlobalSync[T]) (data string) {
+	buf := g.PersistSyncToBytes(persistSync)
+	if buf == nil {
+		return ""
+	}
+	data = base64.StdEncoding.EncodeToString(buf)
+	return
+}
+
+// PersistSyncToBytes 序列化sync: 操作类型(1字节) + [修改前的版本号 varint] + 数据
+func (g *GlobalManager[T]) PersistSyncToBytes(persistSync *GlobalSync[T]) (data []byte) {
+	if persistSync == nil || persistSync.Data == nil || persistSync.Op == eGlobalOpNone || persistSync.Op == EGlobalOpUnload {
+		return nil
+	}
+	data, err := MarshalPersist(g.appendSyncOp(data, persistSync), persistSync.Data, &persistSync.BitSet)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// BytesToPersistSync 反序列化sync
+func (g *GlobalManager[T]) BytesToPersistSync(data []byte) (persistSync *GlobalSync[T], err error) {
+	if len(data) == 0 {
+		return nil, EPersistErrorInvalidData
+	}
+	persistSync = &GlobalSync[T]{Data: new(T)}
+	if data, err = readSyncOp(data, persistSync); err != nil {
+		return nil, err
+	}
+	switch persistSync.Op {
+	case EGlobalOpInsert, EGlobalOpUpdate, EGlobalOpDelete, EGlobalOpReplace:
+	default:
+		return nil, fmt.Errorf("%w: unknown op %d", EPersistErrorInvalidData, persistSync.Op)
+	}
+	if persistSync.BitSet, err = UnmarshalPersist(data, persistSync.Data); err != nil {
+		return nil, err
+	}
+	return persistSync, nil
+}
+
+// BytesToPersistSyncQueue 反序列化bomb/trace记录
+func (g *GlobalManager[T]) BytesToPersistSyncQueue(records [][]byte) (queue []*GlobalSync[T], err error) {
+	queue = make([]*GlobalSync[T], 0, len(records))
+	for i, record := range records {
+		persistSync, err := g.BytesToPersistSync(record)
+		if err != nil {
+			return nil, &BombRecordError{Index: i, Err: err}
+		}
+		queue = append(queue, persistSync)
+	}
+	return queue, nil
+}
+
+// PersistToBytes 序列化, 只写入位图标记的字段
+func (g *GlobalManager[T]) PersistToBytes(cls *T, bitSet GlobalBitSet[T]) (data []byte) {
+	if cls == nil {
+		return nil
+	}
+	data, err := MarshalPersist(nil, cls, &bitSet)
+	if err != nil {
+		g.logError("PersistToBytes", err, nil)
+		return nil
+	}
+	return data
+}
+
+// BytesToPersist 反序列化, 失败返回 nil
+func (g *GlobalManager[T]) BytesToPersist(data []byte) (cls *T) {
+	cls = new(T)
+	if _, err := UnmarshalPersist(data, cls); err != nil {
+		return nil
+	}
+	return cls
+}
+
+// Save 异步写回
+func (g *GlobalManager[T]) Save() {
+	var exit bool
+	for {
+		// 正常退出
+		exit = g.AsyncSave()
+		if exit {
+			break
+		}
+	}
+}
+
+// AsyncSave 异步写回
+func (g *GlobalManager[T]) AsyncSave() (exit bool) {
+	var persistSync *GlobalSync[T]
+	var err error
+	bTime := time.Now().UnixNano()
+	defer func() {
+		if r := recover(); r != nil {
+			// 通知收集协程后退出, 由监督协程重启
+			g.crash <- g.newPanic("save", r)
+			exit = true
+		}
+		g.DataToFailQueue()
+		g.failNum.Store(int64(len(g.FailQueue)))
+		g.lastWriteBackTime.Store(time.Now().UnixNano() - bTime)
+		g.syncEnd <- true
+	}()
+
+	needCollect := <-g.syncBegin
+	if !needCollect {
+		exit = true
+		if g.stopSaving {
+			return
+		}
+	}
+	if len(*g.syncQueue) == 0 && len(g.FailQueue) == 0 {
+		return
+	}
+	session := g.engine.NewSession()
+	defer session.Close()
+
+	// 失败队列中的修改可能已经提交但未收到结果, 按重放写入, 新建使用 Upsert
+	save := g.SaveDB
+	retry := len(g.FailQueue) > 0
+	if retry {
+		save = g.ReplayDB
+		for _, persistSync := range g.FailQueue {
+			persistSync.replay = true
+		}
+		tmpQueue := make([]*GlobalSync[T], len(g.FailQueue)+len(*g.syncQueue))
+		copy(tmpQueue, g.FailQueue)
+		copy(tmpQueue[len(g.FailQueue):], *g.syncQueue)
+		insertQueue, otherQueue := g.MergeQueue(tmpQueue, true)
+		g.syncQueue = &otherQueue
+		g.InsertQueue = insertQueue
+		g.FailQueue = g.FailQueue[0:0]
+	} else {
+		insertQueue, otherQueue := g.MergeQueue(*g.syncQueue, true)
+		g.syncQueue = &otherQueue
+		g.InsertQueue = insertQueue
+	}
+
+	multiInsertFn := func() bool {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				_ = session.Rollback()
+			} else {
+				if err == nil {
+					g.InsertQueue = g.InsertQueue[0:0]
+				} else {
+					_ = session.Rollback()
+				}
+			}
+		}()
+
+		if len(g.InsertQueue) <= 0 {
+			return true
+		}
+		// 分表在事务外创建
+		for _, persistSync := range g.InsertQueue {
+			if err = g.ensureSegment(persistSync.Data); err != nil {
+				return false
+			}
+		}
+		err = session.Begin()
+		if err != nil {
+			return false
+		}
+
+		// 每批只写入同一张表
+		insertArray := make([]*T, 0, eGlobalInsertMultiNum)
+		for i := 0; i < len(g.InsertQueue); {
+			insertArray = insertArray[:0]
+			table := g.tableOf(g.InsertQueue[i].Data)
+			for ; i < len(g.InsertQueue) && len(insertArray) < eGlobalInsertMultiNum; i++ {
+				if g.tableOf(g.InsertQueue[i].Data) != table {
+					break
+				}
+				insertArray = append(insertArray, g.InsertQueue[i].Data)
+			}
+
+			_, err = g.on(session, insertArray[0]).NoVersionCheck().InsertMulti(&insertArray)
+
+			if err != nil {
+				return false
+			}
+		}
+		err = session.Commit()
+		if err != nil {
+			return false
+		}
+		return true
+	}
+
+	// 重试时不批量插入, 逐条重放
+	multiInsertSuccess := !retry && multiInsertFn()
+
+	// 批量插入失败, 改为单条插入
+	if !multiInsertSuccess {
+		for idx, persistSync := range g.InsertQueue {
+			err = save(session, persistSync)
+			if err != nil {
+				g.InsertQueue = g.InsertQueue[idx:]
+				_ = g.SaveFile()
+				return
+			}
+		}
+		g.InsertQueue = g.InsertQueue[0:0]
+	}
+
+	for i := 0; i < len(*g.syncQueue); i++ {
+		persistSync = (*g.syncQueue)[i]
+		err = save(session, persistSync)
+		if err != nil {
+			*g.syncQueue = (*g.syncQueue)[i:]
+			_ = g.SaveFile()
+			return
+		}
+	}
+	*g.syncQueue = (*g.syncQueue)[0:0]
+	_ = g.RemoveFile()
+	g.recordFlush()
+	return
+}
+
+// DataToFailQueue 未写入成功数据, 添加到失败队列
+func (g *GlobalManager[T]) DataToFailQueue() {
+	var persistSync *GlobalSync[T]
+
+	// 插入队列数据添加到失败队列
+	g.FailQueue = append(g.FailQueue, g.InsertQueue...)
+	// 清空插入队列
+	g.InsertQueue = g.InsertQueue[0:0]
+
+	// 一旦失败标记所有的数据都是失败, 不允许导出
+	for i := 0; i < len(*g.syncQueue); i++ {
+		persistSync = (*g.syncQueue)[i]
+		switch persistSync.Op {
+		case EGlobalOpInsert, EGlobalOpUpdate, EGlobalOpDelete, EGlobalOpReplace:
+			g.FailQueue = append(g.FailQueue, persistSync)
+		case EGlobalOpUnload:
+			g.cancelUnload(persistSync)
+		default:
+		}
+	}
+	// 清空同步队列
+	*g.syncQueue = (*g.syncQueue)[0:0]
+}
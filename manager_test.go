@@ -0,0 +1,297 @@
+package persist_test
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	_ "modernc.org/sqlite"
+	"xorm.io/xorm"
+)
+
+type ManagerGlobal struct {
+	AuthId   int64  `xorm:"pk"` // 权限id
+	ParentId int64  `xorm:""`   // 父菜单ID
+	Name     string `xorm:""`   // 菜单名称
+	Sort     int64  `xorm:""`   // 排序
+	Note     string `xorm:"-"`  // 不写入数据库
+}
+
+var _ persist.IPersist = (*persist.GlobalManager[ManagerGlobal])(nil)
+
+// newTestManager 创建使用临时sqlite数据库的管理器
+func newTestManager(t *testing.T) (*persist.GlobalManager[ManagerGlobal], *xorm.Engine) {
+	t.Helper()
+	dir := t.TempDir()
+	bombDir := persist.GetBombDir()
+	persist.SetBombDir(dir)
+	t.Cleanup(func() { persist.SetBombDir(bombDir) })
+
+	engine, err := xorm.NewEngine("sqlite", filepath.Join(dir, "persist.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = engine.Close() })
+
+	g := persist.NewGlobalManager[ManagerGlobal](engine)
+	if err = g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	return g, engine
+}
+
+// TestGlobalManager_WriteBack 测试修改在退出时写回数据库.
+func TestGlobalManager_WriteBack(t *testing.T) {
+	g, engine := newTestManager(t)
+	if err := g.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i, Name: "menu", Sort: i}); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1}); err != persist.EPersistErrorAlreadyExist {
+		t.Errorf("Insert() duplicate error = %v", err)
+	}
+
+	// 新建写回后再修改, 修改单独写回
+	waitFor(t, func() bool { return countRows(t, engine) == 3 })
+
+	cls, ok := g.Get(int64(2))
+	if !ok {
+		t.Fatal("Get() not found")
+	}
+	cls.Name = "renamed"
+	cls.Sort = 100
+	bitSet := persist.InitGlobalBitSet[ManagerGlobal]()
+	bitSet.Set(2)
+	if err := g.Update(cls, bitSet); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := g.Delete(int64(3)); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	g.Exit(&sync.WaitGroup{})
+	if err := g.Insert(&ManagerGlobal{AuthId: 4}); err != persist.EPersistErrorIncorrectState {
+		t.Errorf("Insert() after Exit error = %v", err)
+	}
+
+	var rows []ManagerGlobal
+	if err := engine.Asc("auth_id").Find(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("rows = %+v", rows)
+	}
+	// 只写回位图标记的字段
+	if rows[1].Name != "renamed" || rows[1].Sort != 2 {
+		t.Errorf("updated row = %+v", rows[1])
+	}
+}
+
+// TestGlobalManager_LoadAll 测试导入数据表.
+func TestGlobalManager_LoadAll(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 7, Name: "db"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	if err := g.LoadAll(); err != nil {
+		t.Fatalf("LoadAll() error = %v", err)
+	}
+	if g.LoadState() != persist.EGlobalTableStateMemory {
+		t.Errorf("LoadState() = %d", g.LoadState())
+	}
+	if cls, ok := g.Get(int64(7)); !ok || cls.Name != "db" {
+		t.Errorf("Get() = %+v, %v", cls, ok)
+	}
+	if err := g.LoadAll(); err != persist.EPersistErrorIncorrectState {
+		t.Errorf("LoadAll() twice error = %v", err)
+	}
+}
+
+// TestGlobalManager_BombRecover 测试写回失败保存bomb文件, 重启后恢复.
+func TestGlobalManager_BombRecover(t *testing.T) {
+	g, engine := newTestManager(t)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "lost"}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	if !persist.DirExists(persist.BombFilePath(g.PersistName())) {
+		t.Fatal("bomb file not written")
+	}
+	if len(g.FailQueue) != 1 {
+		t.Errorf("FailQueue = %d, want 1", len(g.FailQueue))
+	}
+
+	// 修复数据库后重启
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	restarted := persist.NewGlobalManager[ManagerGlobal](engine)
+	if err := restarted.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	restarted.Exit(&sync.WaitGroup{})
+
+	if persist.DirExists(persist.BombFilePath(g.PersistName())) {
+		t.Error("bomb file should be removed after recovery")
+	}
+	cls := new(ManagerGlobal)
+	if has, err := engine.ID(1).Get(cls); err != nil || !has || cls.Name != "lost" {
+		t.Errorf("recovered row = %+v, %v, %v", cls, has, err)
+	}
+}
+
+// TestGlobalManager_PersistSync 测试写回记录序列化.
+func TestGlobalManager_PersistSync(t *testing.T) {
+	g := persist.NewGlobalManager[ManagerGlobal](nil)
+	bitSet := persist.InitGlobalBitSet[ManagerGlobal]()
+	bitSet.Set(0).Set(2)
+	src := &persist.GlobalSync[ManagerGlobal]{
+		Data:   &ManagerGlobal{AuthId: 5, Name: "sync", Sort: 9},
+		Op:     persist.EGlobalOpUpdate,
+		BitSet: bitSet,
+	}
+
+	got, ok := g.StringToPersistSyncInterface(g.PersistSyncToString(src)).(*persist.GlobalSync[ManagerGlobal])
+	if !ok {
+		t.Fatal("StringToPersistSyncInterface() failed")
+	}
+	if got.Op != src.Op || got.Data.AuthId != 5 || got.Data.Name != "sync" || got.Data.Sort != 0 {
+		t.Errorf("decoded sync = %+v, %+v", got, got.Data)
+	}
+	if !got.BitSet.Get(2) || got.BitSet.Get(3) {
+		t.Error("decoded bitSet mismatch")
+	}
+	if g.StringToPersistSyncInterface("!") != nil {
+		t.Error("invalid data should return nil")
+	}
+}
+
+// TestGlobalManager_SnapshotDiff 测试快照比较自动标记修改的字段.
+func TestGlobalManager_SnapshotDiff(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetSnapshotDiff(true)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "menu", Sort: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cls, _ := g.Get(int64(1))
+	cls.Name = "renamed"
+	if err := g.Update(cls, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	// 没有修改时不写回
+	if err := g.Update(cls, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	row := new(ManagerGlobal)
+	if has, err := engine.ID(1).Get(row); err != nil || !has || row.Name != "renamed" {
+		t.Fatalf("row = %+v, %v, %v", row, has, err)
+	}
+	if _, err := engine.Exec("UPDATE manager_global SET sort = 99 WHERE auth_id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := persist.NewGlobalManager[ManagerGlobal](engine)
+	restarted.SetSnapshotDiff(true)
+	if err := restarted.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	cls, _ = restarted.Get(int64(1))
+	cls.ParentId = 5
+	cls.Sort = 99
+	if err := restarted.Update(cls, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Exec("UPDATE manager_global SET name = 'db' WHERE auth_id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	restarted.Exit(&sync.WaitGroup{})
+
+	// 只写回比较出的字段, 数据库中其它字段保持不变
+	row = new(ManagerGlobal)
+	if _, err := engine.ID(1).Get(row); err != nil || row.ParentId != 5 || row.Name != "db" {
+		t.Errorf("row = %+v, %v", row, err)
+	}
+}
+
+type CloneGlobal struct {
+	Id   int64    `xorm:"pk"`
+	Tags []string `xorm:"json"`
+}
+
+type HookGlobal struct {
+	Id   int64  `xorm:"pk"`
+	Hook func() `xorm:"-"`
+}
+
+// TestGlobalManager_SyncDeepCopy 测试修改返回后再修改切片不影响未写回的数据, 无法深拷贝时返回错误.
+func TestGlobalManager_SyncDeepCopy(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[CloneGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetSnapshotDiff(true)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Hour, MaxInterval: time.Hour})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	cls := &CloneGlobal{Id: 1, Tags: []string{"a"}}
+	if err := g.Insert(cls); err != nil {
+		t.Fatal(err)
+	}
+	cls.Tags[0] = "inserted"
+	cls, _ = g.Get(int64(1))
+	cls.Tags = append(cls.Tags, "b")
+	if err := g.Update(cls, persist.GlobalBitSet[CloneGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	cls.Tags[0] = "updated"
+	g.Exit(&sync.WaitGroup{})
+
+	row := &CloneGlobal{Id: 1}
+	if has, err := engine.Get(row); err != nil || !has || !slices.Equal(row.Tags, []string{"a", "b"}) {
+		t.Errorf("db row = %+v, %v, %v", row, has, err)
+	}
+
+	hooks := persist.NewGlobalManager[HookGlobal](engine)
+	hooks.SetSnapshotDiff(true)
+	if err := hooks.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer hooks.Exit(&sync.WaitGroup{})
+	if err := hooks.Insert(&HookGlobal{Id: 1}); err == nil {
+		t.Error("Insert() uncloneable error = nil")
+	}
+	if _, ok := hooks.Get(int64(1)); ok {
+		t.Error("Get() after failed Insert ok = true")
+	}
+}
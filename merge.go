@@ -0,0 +1,114 @@
+package persist
+
+const eGlobalOpNone = 0 // 合并后取消的修改, 写回时跳过
+
+// coalesceSync 合并同一主键的两次修改, prev 在前
+// ok 为false时不能合并, 两次修改按顺序保留; merged 为 nil 表示两次修改相互抵消
+//
+//	prev\next  Insert   Update          Delete
+//	Insert     保留     Insert(最新)    抵消, 新建来自失败队列时保留 Delete
+//	Update     保留     Update(合并位图) Delete
+//	Delete     Replace  保留            Delete
+//	Replace    保留     Replace(最新)   Delete
+func coalesceSync[T any](prev, next *GlobalSync[T]) (merged *GlobalSync[T], ok bool) {
+	switch prev.Op {
+	case EGlobalOpInsert:
+		switch next.Op {
+		case EGlobalOpUpdate:
+			return &GlobalSync[T]{Data: next.Data, Op: EGlobalOpInsert, BitSet: prev.BitSet, replay: prev.replay}, true
+		case EGlobalOpDelete:
+			if prev.replay {
+				// 失败的新建可能已经提交, 仍需删除
+				return next, true
+			}
+			return nil, true
+		}
+	case EGlobalOpUpdate:
+		switch next.Op {
+		case EGlobalOpUpdate:
+			bitSet := prev.BitSet.Clone()
+			bitSet.Merge(next.BitSet)
+			return &GlobalSync[T]{Data: next.Data, Op: EGlobalOpUpdate, BitSet: bitSet, Version: prev.Version}, true
+		case EGlobalOpDelete:
+			return next, true
+		}
+	case EGlobalOpDelete:
+		switch next.Op {
+		case EGlobalOpInsert:
+			return &GlobalSync[T]{Data: next.Data, Op: EGlobalOpReplace, BitSet: next.BitSet}, true
+		case EGlobalOpDelete:
+			return next, true
+		}
+	case EGlobalOpReplace:
+		switch next.Op {
+		case EGlobalOpUpdate:
+			return &GlobalSync[T]{Data: next.Data, Op: EGlobalOpReplace, BitSet: prev.BitSet}, true
+		case EGlobalOpDelete:
+			return next, true
+		}
+	}
+	return nil, false
+}
+
+// MergeQueue 按主键合并写回队列, 同一主键的修改保持顺序, 规则见 coalesceSync
+// splitInsert 为true时将可以提前批量写入的新建操作拆分到 insertQueue,
+// 同一主键在新建之前存在其它操作时, 新建操作保留在 otherQueue 中以保证顺序
+func (g *GlobalManager[T]) MergeQueue(queue []*GlobalSync[T], splitInsert bool) (insertQueue, otherQueue []*GlobalSync[T]) {
+	merged := make([]*GlobalSync[T], 0, len(queue))
+	last := make(map[any]int, len(queue))
+	for _, persistSync := range queue {
+		if persistSync == nil || persistSync.Op == eGlobalOpNone {
+			continue
+		}
+		key := g.pkKey(persistSync.Data)
+		if i, ok := last[key]; ok {
+			if result, ok := coalesceSync(merged[i], persistSync); ok {
+				merged[i] = result
+				if result == nil {
+					delete(last, key)
+				}
+				continue
+			}
+		}
+		last[key] = len(merged)
+		merged = append(merged, persistSync)
+	}
+
+	seen := make(map[any]struct{}, len(merged))
+	for _, persistSync := range merged {
+		if persistSync == nil {
+			continue
+		}
+		if !splitInsert {
+			otherQueue = append(otherQueue, persistSync)
+			continue
+		}
+		key := g.pkKey(persistSync.Data)
+		_, ok := seen[key]
+		seen[key] = struct{}{}
+		if persistSync.Op == EGlobalOpInsert && !ok {
+			insertQueue = append(insertQueue, persistSync)
+			continue
+		}
+		otherQueue = append(otherQueue, persistSync)
+	}
+	return
+}
+
+// appendCache 添加到缓存队列, 与同一主键的上一次修改合并, 热点数据在两次写回之间只保留一条
+func (g *GlobalManager[T]) appendCache(persistSync *GlobalSync[T]) {
+	key := g.pkKey(persistSync.Data)
+	if i, ok := g.cacheIndex[key]; ok {
+		if result, ok := coalesceSync((*g.cacheQueue)[i], persistSync); ok {
+			if result == nil {
+				// 保留占位保证位置不变, 写回时跳过
+				result = &GlobalSync[T]{Data: persistSync.Data, Op: eGlobalOpNone}
+				delete(g.cacheIndex, key)
+			}
+			(*g.cacheQueue)[i] = result
+			return
+		}
+	}
+	g.cacheIndex[key] = len(*g.cacheQueue)
+	*g.cacheQueue = append(*g.cacheQueue, persistSync)
+}
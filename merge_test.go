@@ -0,0 +1,151 @@
+package persist_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// opSync 创建测试修改, name 标记数据版本
+func opSync(op int8, id int64, name string, fields ...persist.GlobalFieldIndex) *persist.GlobalSync[ManagerGlobal] {
+	bitSet := persist.InitGlobalBitSet[ManagerGlobal]()
+	if len(fields) == 0 {
+		bitSet.SetAll()
+	}
+	for _, i := range fields {
+		bitSet.Set(i)
+	}
+	return &persist.GlobalSync[ManagerGlobal]{Data: &ManagerGlobal{AuthId: id, Name: name}, Op: op, BitSet: bitSet}
+}
+
+// describeQueue 队列描述: 操作:主键:数据版本:位图字段数量
+func describeQueue(queue []*persist.GlobalSync[ManagerGlobal]) string {
+	items := make([]string, 0, len(queue))
+	for _, s := range queue {
+		items = append(items, fmt.Sprintf("%d:%d:%s:%d", s.Op, s.Data.AuthId, s.Data.Name, s.BitSet.Count()))
+	}
+	return strings.Join(items, " ")
+}
+
+// TestGlobalManager_MergeQueue 测试同一主键的修改合并规则.
+func TestGlobalManager_MergeQueue(t *testing.T) {
+	const (
+		ins = persist.EGlobalOpInsert
+		upd = persist.EGlobalOpUpdate
+		del = persist.EGlobalOpDelete
+		rep = persist.EGlobalOpReplace
+	)
+	g := persist.NewGlobalManager[ManagerGlobal](nil)
+
+	tests := []struct {
+		name   string
+		queue  []*persist.GlobalSync[ManagerGlobal]
+		insert string
+		other  string
+	}{
+		{"insert+update", []*persist.GlobalSync[ManagerGlobal]{opSync(ins, 1, "a"), opSync(upd, 1, "b", 2)}, "1:1:b:5", ""},
+		{"update+update", []*persist.GlobalSync[ManagerGlobal]{opSync(upd, 1, "a", 1), opSync(upd, 1, "b", 2)}, "", "2:1:b:2"},
+		{"insert+delete", []*persist.GlobalSync[ManagerGlobal]{opSync(ins, 1, "a"), opSync(del, 1, "a")}, "", ""},
+		{"delete+insert", []*persist.GlobalSync[ManagerGlobal]{opSync(del, 1, "a"), opSync(ins, 1, "b")}, "", "5:1:b:5"},
+		{"update+delete", []*persist.GlobalSync[ManagerGlobal]{opSync(upd, 1, "a", 1), opSync(del, 1, "b")}, "", "3:1:b:5"},
+		{"replace+update", []*persist.GlobalSync[ManagerGlobal]{opSync(rep, 1, "a"), opSync(upd, 1, "b", 1)}, "", "5:1:b:5"},
+		{"replace+delete", []*persist.GlobalSync[ManagerGlobal]{opSync(rep, 1, "a"), opSync(del, 1, "b")}, "", "3:1:b:5"},
+		{"delete+delete", []*persist.GlobalSync[ManagerGlobal]{opSync(del, 1, "a"), opSync(del, 1, "b")}, "", "3:1:b:5"},
+		{
+			"insert+delete+insert",
+			[]*persist.GlobalSync[ManagerGlobal]{opSync(ins, 1, "a"), opSync(del, 1, "a"), opSync(ins, 1, "c")},
+			"1:1:c:5", "",
+		},
+		{
+			"update+insert keeps order",
+			[]*persist.GlobalSync[ManagerGlobal]{opSync(upd, 1, "a", 1), opSync(ins, 1, "b"), opSync(upd, 1, "c", 2)},
+			"", "2:1:a:1 1:1:c:5",
+		},
+		{
+			"keys interleaved",
+			[]*persist.GlobalSync[ManagerGlobal]{
+				opSync(upd, 1, "a", 1), opSync(ins, 2, "x"), opSync(upd, 1, "b", 3),
+				opSync(upd, 2, "y", 1), opSync(del, 3, "z"), opSync(upd, 1, "c", 1),
+			},
+			"1:2:y:5", "2:1:c:2 3:3:z:5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			insertQueue, otherQueue := g.MergeQueue(tt.queue, true)
+			if got := describeQueue(insertQueue); got != tt.insert {
+				t.Errorf("insertQueue = %q, want %q", got, tt.insert)
+			}
+			if got := describeQueue(otherQueue); got != tt.other {
+				t.Errorf("otherQueue = %q, want %q", got, tt.other)
+			}
+		})
+	}
+}
+
+// TestGlobalManager_MergeInsertUpdate 测试同一写回周期内新建后修改合并为新建最新数据.
+func TestGlobalManager_MergeInsertUpdate(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Hour, MaxInterval: time.Hour})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "menu", Sort: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cls, _ := g.Get(int64(1))
+	cls.Name = "renamed"
+	cls.Sort = 100
+	bitSet := persist.InitGlobalBitSet[ManagerGlobal]()
+	bitSet.Set(2)
+	if err := g.Update(cls, bitSet); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, engine); n != 0 {
+		t.Fatalf("rows before Exit = %d, want 0", n)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	row := new(ManagerGlobal)
+	if has, err := engine.ID(1).Get(row); err != nil || !has || row.Name != "renamed" || row.Sort != 100 {
+		t.Errorf("row = %+v, %v, %v", row, has, err)
+	}
+}
+
+// TestGlobalManager_Replace 测试删除后新建同一主键写回为替换.
+func TestGlobalManager_Replace(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 1, Name: "old", Sort: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		cls, _ := g.Get(int64(1))
+		cls.Sort++
+		if err := g.Update(cls, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Delete(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	cls := new(ManagerGlobal)
+	if has, err := engine.ID(1).Get(cls); err != nil || !has || cls.Name != "new" || cls.Sort != 0 {
+		t.Errorf("row = %+v, %v, %v", cls, has, err)
+	}
+}
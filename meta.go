@@ -0,0 +1,255 @@
+package persist
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"xorm.io/xorm/names"
+)
+
+// GlobalHashGroup hash标签声明的索引组, 如 `hash:"group=1;unique=1"`
+type GlobalHashGroup struct {
+	Group  int                // 组号
+	Unique bool               // 组内字段组合是否唯一
+	Fields []GlobalFieldIndex // 组内字段序号, 按声明顺序
+}
+
+// GlobalMeta 结构体元数据, 每个类型只解析一次, 位图, 编解码和管理器共用
+type GlobalMeta struct {
+	Type       reflect.Type                // 结构体类型
+	FieldNum   int                         // 字段数量
+	FieldNames []string                    // 字段序号 -> 字段名
+	FieldTypes []reflect.Type              // 字段序号 -> 字段类型
+	Offsets    []uintptr                   // 字段序号 -> 字段偏移
+	Tags       []reflect.StructTag         // 字段序号 -> 标签
+	Columns    []string                    // 字段序号 -> 数据库列名(SnakeMapper), 非数据库字段为空
+	PkIndex    []GlobalFieldIndex          // 主键字段序号
+	HashGroups []GlobalHashGroup           // hash索引组, 按组号排序
+	Version    int                         // 版本号字段序号(xorm version), 不存在为 -1
+	Deleted    int                         // 软删除字段序号(xorm deleted), 不存在为 -1
+	fieldIndex map[string]GlobalFieldIndex // 字段名 -> 字段序号
+	tagColumns []string                    // 字段序号 -> 标签指定的列名
+	dbField    []bool                      // 字段序号 -> 是否数据库字段
+
+	codecOnce sync.Once
+	codec     *structCodec
+	codecErr  error
+
+	diffOnce sync.Once
+	diff     []equalFunc
+}
+
+var gGlobalMetaMap sync.Map // reflect.Type -> *GlobalMeta
+
+// GetGlobalMeta 获取 T 的元数据
+func GetGlobalMeta[T any]() *GlobalMeta {
+	return getGlobalMeta(reflect.TypeFor[T]())
+}
+
+// getGlobalMeta 获取结构体类型的元数据, t 为指针时取元素类型
+func getGlobalMeta(t reflect.Type) *GlobalMeta {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if meta, ok := gGlobalMetaMap.Load(t); ok {
+		return meta.(*GlobalMeta)
+	}
+	meta, _ := gGlobalMetaMap.LoadOrStore(t, buildGlobalMeta(t))
+	return meta.(*GlobalMeta)
+}
+
+// buildGlobalMeta 解析结构体元数据
+func buildGlobalMeta(t reflect.Type) *GlobalMeta {
+	meta := &GlobalMeta{
+		Type:       t,
+		Version:    -1,
+		Deleted:    -1,
+		fieldIndex: make(map[string]GlobalFieldIndex),
+	}
+	if t.Kind() != reflect.Struct {
+		return meta
+	}
+
+	meta.FieldNum = t.NumField()
+	meta.FieldNames = make([]string, meta.FieldNum)
+	meta.FieldTypes = make([]reflect.Type, meta.FieldNum)
+	meta.Offsets = make([]uintptr, meta.FieldNum)
+	meta.Tags = make([]reflect.StructTag, meta.FieldNum)
+	meta.tagColumns = make([]string, meta.FieldNum)
+	meta.dbField = make([]bool, meta.FieldNum)
+	groups := make(map[int]*GlobalHashGroup)
+	for i := 0; i < meta.FieldNum; i++ {
+		field := t.Field(i)
+		idx := GlobalFieldIndex(i)
+		meta.FieldNames[i] = field.Name
+		meta.FieldTypes[i] = field.Type
+		meta.Offsets[i] = field.Offset
+		meta.Tags[i] = field.Tag
+		meta.fieldIndex[field.Name] = idx
+
+		for _, value := range TagValues(field.Tag, "hash") {
+			group, unique, ok := ParseHashTag(value)
+			if !ok {
+				continue
+			}
+			if groups[group] == nil {
+				groups[group] = &GlobalHashGroup{Group: group, Unique: unique}
+			}
+			groups[group].Fields = append(groups[group].Fields, idx)
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+		tag := ParseXormTag(field.Tag.Get("xorm"))
+		if tag.Ignore {
+			continue
+		}
+		meta.dbField[i] = true
+		meta.tagColumns[i] = tag.Column
+		if tag.Pk {
+			meta.PkIndex = append(meta.PkIndex, idx)
+		}
+		if tag.Version && meta.Version < 0 {
+			meta.Version = i
+		}
+		if tag.Deleted && meta.Deleted < 0 {
+			meta.Deleted = i
+		}
+	}
+	meta.Columns = meta.ColumnsWith(names.SnakeMapper{})
+
+	for _, group := range groups {
+		meta.HashGroups = append(meta.HashGroups, *group)
+	}
+	sort.Slice(meta.HashGroups, func(i, j int) bool {
+		return meta.HashGroups[i].Group < meta.HashGroups[j].Group
+	})
+	return meta
+}
+
+// ColumnsWith 按映射规则生成 字段序号 -> 数据库列名, 标签指定的列名优先
+func (m *GlobalMeta) ColumnsWith(mapper names.Mapper) []string {
+	columns := make([]string, m.FieldNum)
+	for i := range columns {
+		if !m.dbField[i] {
+			continue
+		}
+		columns[i] = m.tagColumns[i]
+		if columns[i] == "" {
+			columns[i] = mapper.Obj2Table(m.FieldNames[i])
+		}
+	}
+	return columns
+}
+
+// FieldIndex 按字段名获取字段序号
+func (m *GlobalMeta) FieldIndex(name string) (GlobalFieldIndex, bool) {
+	idx, ok := m.fieldIndex[name]
+	return idx, ok
+}
+
+// FieldIndexByOffset 按字段偏移和类型获取字段序号, 零大小字段可能与下一个字段偏移相同, 需要同时比较类型
+func (m *GlobalMeta) FieldIndexByOffset(offset uintptr, t reflect.Type) (GlobalFieldIndex, bool) {
+	for i, fieldOffset := range m.Offsets {
+		if fieldOffset == offset && m.FieldTypes[i] == t {
+			return GlobalFieldIndex(i), true
+		}
+	}
+	return 0, false
+}
+
+// structCodec 结构体编码计划
+func (m *GlobalMeta) structCodec() (*structCodec, error) {
+	m.codecOnce.Do(func() {
+		m.codec, m.codecErr = getStructCodec(m.Type)
+	})
+	return m.codec, m.codecErr
+}
+
+// XormTag xorm标签中持久化关心的部分, 元数据和 persistgen 共用
+type XormTag struct {
+	Column  string // 指定的列名
+	Pk      bool   // 主键
+	Ignore  bool   // 不写入数据库
+	Version bool   // 乐观锁版本号
+	Deleted bool   // 软删除时间
+}
+
+// ParseXormTag 解析xorm标签中的列名, 主键和忽略标记
+func ParseXormTag(tag string) (t XormTag) {
+	for _, token := range strings.Fields(tag) {
+		switch {
+		case token == "-":
+			t.Ignore = true
+		case strings.EqualFold(token, "pk"):
+			t.Pk = true
+		case strings.EqualFold(token, "version"):
+			t.Version = true
+		case strings.EqualFold(token, "deleted"):
+			t.Deleted = true
+		case len(token) > 1 && token[0] == '\'' && token[len(token)-1] == '\'':
+			t.Column = token[1 : len(token)-1]
+		}
+	}
+	return
+}
+
+// ParseHashTag 解析hash标签 group=1;unique=1, 缺少组号时 ok 为 false
+func ParseHashTag(value string) (group int, unique, ok bool) {
+	for _, item := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch key {
+		case "group":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return 0, false, false
+			}
+			group, ok = n, true
+		case "unique":
+			unique = val == "1" || val == "true"
+		}
+	}
+	return
+}
+
+// TagValues 获取标签中 key 的所有值, reflect.StructTag.Get 只返回第一个
+func TagValues(tag reflect.StructTag, key string) (values []string) {
+	for tag != "" {
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := string(tag[:i])
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		quoted := string(tag[:i+1])
+		tag = tag[i+1:]
+		if name == key {
+			if value, err := strconv.Unquote(quoted); err == nil {
+				values = append(values, value)
+			}
+		}
+	}
+	return
+}
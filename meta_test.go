@@ -0,0 +1,133 @@
+package persist_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm/names"
+)
+
+type MetaGlobal struct {
+	AuthId    int64     `xorm:"pk 'id'" hash:"group=1;unique=1" hash:"group=3;unique=0"` // 权限id
+	ParentId  int64     `xorm:""`                                                        // 父菜单ID
+	Type      string    `xorm:"" hash:"group=3;unique=0"`                                // 菜单类型
+	Cache     string    `xorm:"-"`                                                       // 不写入数据库
+	Ver       int64     `xorm:"version"`                                                 // 版本号
+	DeletedAt time.Time `xorm:"deleted"`                                                 // 删除时间
+	local     int
+}
+
+// TestGetGlobalMeta 测试解析结构体元数据.
+func TestGetGlobalMeta(t *testing.T) {
+	meta := persist.GetGlobalMeta[MetaGlobal]()
+	if meta != persist.GetGlobalMeta[MetaGlobal]() {
+		t.Error("meta should be cached")
+	}
+	if meta.FieldNum != 7 || meta.FieldNames[2] != "Type" {
+		t.Errorf("fields = %d %v", meta.FieldNum, meta.FieldNames)
+	}
+	columns := []string{"id", "parent_id", "type", "", "ver", "deleted_at", ""}
+	for i, column := range columns {
+		if meta.Columns[i] != column {
+			t.Errorf("Columns[%d] = %q, want %q", i, meta.Columns[i], column)
+		}
+	}
+	if got := meta.ColumnsWith(names.SameMapper{}); got[1] != "ParentId" || got[0] != "id" {
+		t.Errorf("ColumnsWith() = %v", got)
+	}
+	if len(meta.PkIndex) != 1 || meta.PkIndex[0] != 0 {
+		t.Errorf("PkIndex = %v", meta.PkIndex)
+	}
+	if meta.Version != 4 || meta.Deleted != 5 {
+		t.Errorf("Version = %d, Deleted = %d", meta.Version, meta.Deleted)
+	}
+	if idx, ok := meta.FieldIndex("Cache"); !ok || idx != 3 {
+		t.Errorf("FieldIndex() = %d, %v", idx, ok)
+	}
+
+	if len(meta.HashGroups) != 2 {
+		t.Fatalf("HashGroups = %+v", meta.HashGroups)
+	}
+	if g := meta.HashGroups[0]; g.Group != 1 || !g.Unique || len(g.Fields) != 1 {
+		t.Errorf("group 1 = %+v", g)
+	}
+	if g := meta.HashGroups[1]; g.Group != 3 || g.Unique || len(g.Fields) != 2 || g.Fields[1] != 2 {
+		t.Errorf("group 3 = %+v", g)
+	}
+}
+
+// TestGetFieldValueByTag 测试通过标签获取字段值.
+func TestGetFieldValueByTag(t *testing.T) {
+	cls := &MetaGlobal{Type: "menu"}
+	if value, ok := persist.GetFieldValueByTag(cls, "hash", "group=3;unique=0"); !ok || value != "menu" {
+		t.Errorf("GetFieldValueByTag() = %v, %v", value, ok)
+	}
+	if value, ok := persist.GetFieldValueByTag(*cls, "xorm", `-`); !ok || value != "" {
+		t.Errorf("GetFieldValueByTag() = %v, %v", value, ok)
+	}
+	if persist.GetFieldNum(cls) != 7 || len(persist.GetFieldNames(MetaGlobal{})) != 7 {
+		t.Error("GetFieldNum/GetFieldNames mismatch")
+	}
+}
+
+// TestGlobalBitSet_Zero 测试零值位图.
+func TestGlobalBitSet_Zero(t *testing.T) {
+	var bitSet persist.GlobalBitSet[MetaGlobal]
+	if bitSet.Get(1) {
+		t.Error("zero bitSet Get() = true")
+	}
+	if bitSet.Set(1) == nil || !bitSet.Get(1) || bitSet.Get(0) {
+		t.Error("zero bitSet Set() failed")
+	}
+	if bitSet.Set(7) != nil || bitSet.Get(7) {
+		t.Error("out of range index should be ignored")
+	}
+}
+
+// BenchmarkGlobalBitSet_Get 位图读取性能.
+func BenchmarkGlobalBitSet_Get(b *testing.B) {
+	bitSet := persist.InitGlobalBitSet[MetaGlobal]()
+	bitSet.Set(3)
+	b.ReportAllocs()
+	for b.Loop() {
+		for i := persist.GlobalFieldIndex(0); i < 7; i++ {
+			bitSet.Get(i)
+		}
+	}
+}
+
+// TestGlobalBitSet_Addressing 测试按字段名和字段指针设置位.
+func TestGlobalBitSet_Addressing(t *testing.T) {
+	row := &MetaGlobal{}
+	bitSet := persist.InitGlobalBitSet[MetaGlobal]()
+	if bitSet.SetByName("Type") == nil || bitSet.SetField(row, &row.Ver) == nil {
+		t.Fatal("SetByName/SetField failed")
+	}
+	if bitSet.SetByName("Missing") != nil {
+		t.Error("SetByName() unknown field should return nil")
+	}
+	other := &MetaGlobal{}
+	if bitSet.SetField(row, &other.ParentId) != nil || bitSet.SetField(row, row.ParentId) != nil {
+		t.Error("SetField() foreign pointer should return nil")
+	}
+
+	var got []persist.GlobalFieldIndex
+	for i := range bitSet.Fields() {
+		got = append(got, i)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 4 || bitSet.Count() != 2 {
+		t.Errorf("Fields() = %v, Count() = %d", got, bitSet.Count())
+	}
+	if cols := bitSet.Cols(); len(cols) != 2 || cols[0] != "type" || cols[1] != "ver" {
+		t.Errorf("Cols() = %v", cols)
+	}
+
+	bitSet.SetAll()
+	if bitSet.Count() != 7 {
+		t.Errorf("SetAll() Count() = %d, want 7", bitSet.Count())
+	}
+	if cols := bitSet.Cols(); len(cols) != 5 {
+		t.Errorf("Cols() skip non-db fields = %v", cols)
+	}
+}
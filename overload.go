@@ -0,0 +1,233 @@
+package persist
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GlobalOverloadPolicy 过载策略, 未写回的修改超过上限时如何处理新的修改
+type GlobalOverloadPolicy int8
+
+const (
+	EGlobalOverloadBlock  GlobalOverloadPolicy = 0 // 阻塞等待写回, 超时返回 EPersistErrorOverload
+	EGlobalOverloadReject GlobalOverloadPolicy = 1 // 立即返回 EPersistErrorOverload
+	EGlobalOverloadSpill  GlobalOverloadPolicy = 2 // 继续接收修改, 缓存队列溢出到磁盘, 恢复后按顺序写回
+)
+
+// GlobalOverloadConfig 过载保护配置, 上限为0时不限制
+// 进入过载后未写回的修改低于上限的3/4才解除, 避免状态频繁切换
+type GlobalOverloadConfig struct {
+	MaxQueue  int                  // 未写回的修改数量上限
+	MaxMemory int64                // 未写回的修改估算内存上限, 按结构体大小估算, 不含字符串和切片指向的数据
+	Policy    GlobalOverloadPolicy // 过载策略
+	Timeout   time.Duration        // 阻塞策略的等待时间, 为0时一直等待
+
+	// OnOverload 过载状态变化时在收集协程中调用, 不能阻塞
+	OnOverload func(name string, overloaded bool)
+}
+
+// exceed 是否超过上限, overloaded 为true时按解除阈值判断
+func (c *GlobalOverloadConfig) exceed(pending, syncSize int64, overloaded bool) bool {
+	maxQueue, maxMemory := int64(c.MaxQueue), c.MaxMemory
+	if overloaded {
+		maxQueue -= maxQueue / 4
+		maxMemory -= maxMemory / 4
+	}
+	return (maxQueue > 0 && pending >= maxQueue) || (maxMemory > 0 && pending*syncSize >= maxMemory)
+}
+
+// SetOverload 设置过载保护, 应在 Run 之前调用
+func (g *GlobalManager[T]) SetOverload(config GlobalOverloadConfig) {
+	g.overload = config
+}
+
+// Overloaded 是否过载
+func (g *GlobalManager[T]) Overloaded() bool {
+	return g.overloaded.Load()
+}
+
+// Pending 未写回的修改数量, 包括缓存队列, 正在写回和失败的修改, 不包括溢出到磁盘的修改
+func (g *GlobalManager[T]) Pending() int64 {
+	return g.pending.Load()
+}
+
+// CheckOverload 统计未写回的修改, 更新过载状态, 只在收集协程中调用
+// 存在未读取的溢出文件时保持过载, 直到溢出的修改全部写回
+func (g *GlobalManager[T]) CheckOverload() bool {
+	pending := int64(len(*g.cacheQueue)+g.batchNum) + g.failNum.Load()
+	g.pending.Store(pending)
+	overloaded := g.overload.exceed(pending, g.syncSize, g.overloaded.Load()) || len(g.spillSeqs) > 0
+	g.setOverloaded(overloaded)
+	return overloaded
+}
+
+// setOverloaded 切换过载状态, 解除时唤醒阻塞的修改
+func (g *GlobalManager[T]) setOverloaded(overloaded bool) {
+	if g.overloaded.Load() == overloaded {
+		return
+	}
+	g.reliefMu.Lock()
+	g.overloaded.Store(overloaded)
+	if !overloaded {
+		close(g.relief)
+		g.relief = make(chan struct{})
+	}
+	g.reliefMu.Unlock()
+
+	if overloaded {
+		g.logError("overload", fmt.Errorf("%w: pending %d", EPersistErrorOverload, g.pending.Load()), nil)
+	}
+	if g.overload.OnOverload != nil {
+		g.overload.OnOverload(g.name, overloaded)
+	}
+}
+
+// waitOverload 修改前检查过载状态, 按策略阻塞或返回错误
+func (g *GlobalManager[T]) waitOverload() error {
+	if !g.overloaded.Load() {
+		return nil
+	}
+	switch g.overload.Policy {
+	case EGlobalOverloadReject:
+		return EPersistErrorOverload
+	case EGlobalOverloadSpill:
+		return nil
+	}
+
+	var timeout <-chan time.Time
+	if g.overload.Timeout > 0 {
+		timer := time.NewTimer(g.overload.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		g.reliefMu.Lock()
+		if !g.overloaded.Load() {
+			g.reliefMu.Unlock()
+			return nil
+		}
+		relief := g.relief
+		g.reliefMu.Unlock()
+
+		select {
+		case <-relief:
+		case <-timeout:
+			return EPersistErrorOverload
+		}
+	}
+}
+
+// cacheFull 缓存队列本身达到上限, 溢出策略下立即写入磁盘
+func (g *GlobalManager[T]) cacheFull() bool {
+	return g.overload.Policy == EGlobalOverloadSpill && g.overload.exceed(int64(len(*g.cacheQueue)), g.syncSize, false)
+}
+
+// rotateSpill 每轮写回前调用, 存在溢出文件时缓存队列追加到溢出文件末尾, 保证写回顺序
+// 内存中未写回的修改低于解除阈值或正在退出时, 读取最早的溢出文件作为本轮写回的数据
+func (g *GlobalManager[T]) rotateSpill(exiting bool) {
+	if len(g.spillSeqs) == 0 && (exiting || g.overload.Policy != EGlobalOverloadSpill || !g.overloaded.Load()) {
+		return
+	}
+	if len(*g.cacheQueue) > 0 {
+		_ = g.spillCache()
+	}
+	if len(g.spillSeqs) > 0 && (exiting || !g.overload.exceed(g.failNum.Load(), g.syncSize, true)) {
+		_ = g.loadSpill()
+	}
+}
+
+// spillCache 缓存队列写入新的溢出文件, 写入失败时保留在内存中
+func (g *GlobalManager[T]) spillCache() error {
+	records := make([][]byte, 0, len(*g.cacheQueue))
+	for _, persistSync := range *g.cacheQueue {
+		if persistSync.Op == EGlobalOpUnload {
+			// 溢出的修改写回前不能导出
+			g.cancelUnload(persistSync)
+			continue
+		}
+		if data := g.PersistSyncToBytes(persistSync); data != nil {
+			records = append(records, data)
+		}
+	}
+	if len(records) > 0 {
+		header := NewBombHeader(g.name, EBombKindSpill, len(records))
+		if err := WriteBombFile(SpillFilePath(g.name, g.spillNext), header, records); err != nil {
+			g.logError("spill", err, nil)
+			return err
+		}
+		g.spillSeqs = append(g.spillSeqs, g.spillNext)
+		g.spillNext++
+	}
+	*g.cacheQueue = (*g.cacheQueue)[0:0]
+	clear(g.cacheIndex)
+	return nil
+}
+
+// loadSpill 读取最早的溢出文件, 插入缓存队列头部, 文件在本轮写回结束后删除
+func (g *GlobalManager[T]) loadSpill() error {
+	seq := g.spillSeqs[0]
+	g.spillSeqs = g.spillSeqs[1:]
+	path := SpillFilePath(g.name, seq)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		g.logError("load spill", err, nil)
+		return err
+	}
+	header, records, err := DecodeBomb(data)
+	if err == nil && header.Name != g.name {
+		err = fmt.Errorf("%w: persist %s, want %s", EPersistErrorInvalidBombFile, header.Name, g.name)
+	}
+	var queue []*GlobalSync[T]
+	if err == nil {
+		queue, err = g.BytesToPersistSyncQueue(records)
+	}
+	if err != nil {
+		// 损坏的溢出文件保留在磁盘上, 由 persistctl 检查
+		g.logError("load spill "+path, err, nil)
+		return err
+	}
+
+	*g.cacheQueue = append(queue, *g.cacheQueue...)
+	g.spillLoaded = append(g.spillLoaded, path)
+	return nil
+}
+
+// removeSpilled 删除上一轮读取的溢出文件, 数据已经写回数据库或保存到bomb文件
+func (g *GlobalManager[T]) removeSpilled() {
+	for _, path := range g.spillLoaded {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			g.logError("remove spill", err, nil)
+		}
+	}
+	g.spillLoaded = g.spillLoaded[0:0]
+}
+
+// scanSpill 启动时查找上次运行留下的溢出文件, 按溢出顺序写回
+func (g *GlobalManager[T]) scanSpill() {
+	g.spillSeqs = g.spillSeqs[0:0]
+	g.spillNext = 0
+	entries, err := os.ReadDir(GetBombDir())
+	if err != nil {
+		return
+	}
+	prefix := g.name + "."
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || filepath.Ext(name) != ESpillFileExt {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ESpillFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		g.spillSeqs = append(g.spillSeqs, seq)
+		g.spillNext = max(g.spillNext, seq+1)
+	}
+	slices.Sort(g.spillSeqs)
+}
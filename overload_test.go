@@ -0,0 +1,182 @@
+package persist_test
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// waitFor 等待条件成立
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("wait timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newOverloadManager 创建数据表已删除的管理器, 所有写回都会失败
+func newOverloadManager(t *testing.T, config persist.GlobalOverloadConfig) (*persist.GlobalManager[ManagerGlobal], func()) {
+	t.Helper()
+	g, engine := newTestManager(t)
+	g.SetOverload(config)
+	g.SetFlush(persist.GlobalFlushConfig{MaxInterval: 20 * time.Millisecond})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return g, func() {
+		if err := engine.Sync(new(ManagerGlobal)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestGlobalManager_OverloadReject 测试过载时拒绝修改, 写回恢复后解除.
+func TestGlobalManager_OverloadReject(t *testing.T) {
+	var changes []bool
+	var mu sync.Mutex
+	g, repair := newOverloadManager(t, persist.GlobalOverloadConfig{
+		MaxQueue: 8,
+		Policy:   persist.EGlobalOverloadReject,
+		OnOverload: func(name string, overloaded bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, overloaded)
+		},
+	})
+
+	for i := int64(1); i <= 8; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i}); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	waitFor(t, g.Overloaded)
+	if err := g.Insert(&ManagerGlobal{AuthId: 9}); err != persist.EPersistErrorOverload {
+		t.Errorf("Insert() overload error = %v", err)
+	}
+	if g.Pending() < 8 {
+		t.Errorf("Pending() = %d", g.Pending())
+	}
+
+	repair()
+	waitFor(t, func() bool { return !g.Overloaded() })
+	if err := g.Insert(&ManagerGlobal{AuthId: 9}); err != nil {
+		t.Errorf("Insert() after relief error = %v", err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("OnOverload changes = %v", changes)
+	}
+}
+
+// TestGlobalManager_OverloadBlock 测试过载时阻塞修改, 超时返回错误, 解除后继续执行.
+func TestGlobalManager_OverloadBlock(t *testing.T) {
+	const timeout = 500 * time.Millisecond
+	g, repair := newOverloadManager(t, persist.GlobalOverloadConfig{
+		MaxMemory: 4 << 10,
+		Timeout:   timeout,
+	})
+
+	var id int64
+	for !g.Overloaded() {
+		id++
+		if err := g.Insert(&ManagerGlobal{AuthId: id}); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	begin := time.Now()
+	if err := g.Insert(&ManagerGlobal{AuthId: id + 1}); err != persist.EPersistErrorOverload {
+		t.Errorf("Insert() timeout error = %v", err)
+	}
+	if time.Since(begin) < timeout {
+		t.Error("Insert() returned before timeout")
+	}
+
+	var repaired atomic.Bool
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		repaired.Store(true)
+		repair()
+	}()
+	if err := g.Insert(&ManagerGlobal{AuthId: id + 1}); err != nil {
+		t.Errorf("Insert() after relief error = %v", err)
+	}
+	if !repaired.Load() {
+		t.Error("Insert() should block until relief")
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_OverloadSpill 测试过载时溢出到磁盘, 恢复后按顺序写回.
+func TestGlobalManager_OverloadSpill(t *testing.T) {
+	var overloaded atomic.Bool
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MaxInterval: 20 * time.Millisecond})
+	g.SetOverload(persist.GlobalOverloadConfig{
+		MaxQueue: 10,
+		Policy:   persist.EGlobalOverloadSpill,
+		OnOverload: func(name string, v bool) {
+			if v {
+				overloaded.Store(true)
+			}
+		},
+	})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 50; i++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: i, Name: "spill"}); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+	for i := int64(1); i <= 50; i++ {
+		cls, _ := g.Get(i)
+		cls.Sort = i
+		if err := g.Update(cls, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	spilled, _ := filepath.Glob(filepath.Join(persist.GetBombDir(), "*"+persist.ESpillFileExt))
+	if len(spilled) == 0 || !overloaded.Load() {
+		t.Fatalf("spill files = %v, overloaded = %v", spilled, overloaded.Load())
+	}
+
+	if err := engine.Sync(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	if spilled, _ = filepath.Glob(filepath.Join(persist.GetBombDir(), "*"+persist.ESpillFileExt)); len(spilled) != 0 {
+		t.Errorf("spill files left = %v", spilled)
+	}
+	var rows []ManagerGlobal
+	if err := engine.Asc("auth_id").Find(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 50 {
+		t.Fatalf("rows = %d, want 50", len(rows))
+	}
+	for i, row := range rows {
+		if row.Sort != int64(i+1) {
+			t.Errorf("row = %+v", row)
+		}
+	}
+}
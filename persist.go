@@ -32,8 +32,10 @@ func GetDatabaseDB() *xorm.Engine {
 	return gEngine
 }
 
-// ExitPersists 退出并保存所有持久化数据
+// ExitPersists 停止定时任务和空闲用户导出, 退出并保存所有持久化数据
 func ExitPersists() error {
+	StopScheduler()
+	StopUserIdle()
 	ExitPersist()
 	if err := SyncDataPersist(true); err != nil {
 		return err
@@ -42,7 +44,7 @@ func ExitPersists() error {
 	return nil
 }
 
-// InitPersists 初始化所有持久化数据
+// InitPersists 初始化所有持久化数据, 设置预热时预热完成后就绪
 func InitPersists() error {
 	engine := GetDatabaseDB()
 	if engine == nil {
@@ -51,6 +53,9 @@ func InitPersists() error {
 	if err := SyncPersist(); err != nil {
 		return err
 	}
-
-	return nil
+	if gWarmupConfig == nil {
+		gReady.Store(true)
+		return nil
+	}
+	return Warmup(*gWarmupConfig)
 }
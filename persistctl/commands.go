@@ -0,0 +1,364 @@
+package persistctl
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+// errCorrupted 文件存在损坏
+var errCorrupted = errors.New("file is corrupted")
+
+// inspectRecord inspect 输出的单条记录
+type inspectRecord struct {
+	File   string `json:"file"`
+	Index  int    `json:"index"`
+	Size   int    `json:"size"`
+	Record any    `json:"record,omitempty"`
+	Raw    string `json:"raw,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// inspect 按注册的模型解码记录
+func (a *App) inspect(args []string) error {
+	fs := a.flagSet("inspect")
+	name := fs.String("persist", "", "persist name, defaults to the name in the file header")
+	raw := fs.Bool("raw", false, "also print the raw record in base64")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("no file given")
+	}
+	if err := a.register(nil); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(a.Stdout)
+	var corrupted bool
+	for _, path := range fs.Args() {
+		_, err := scanFile(path, func(header persist.BombHeader, index int, record []byte, recordErr error) error {
+			out := inspectRecord{File: path, Index: index, Size: len(record)}
+			p := lookup(header.Name)
+			if *name != "" {
+				p = lookup(*name)
+			}
+			if recordErr != nil {
+				corrupted = true
+				out.Error = recordErr.Error()
+			} else if p != nil {
+				out.Record = p.StringToPersistSyncInterface(base64.StdEncoding.EncodeToString(record))
+				if out.Record == nil {
+					corrupted = true
+					out.Error = "decode failed"
+				}
+			}
+			if *raw || p == nil || out.Record == nil {
+				out.Raw = base64.StdEncoding.EncodeToString(record)
+			}
+			return encoder.Encode(out)
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if corrupted {
+		return errCorrupted
+	}
+	return nil
+}
+
+// verify 校验文件头和记录校验和
+func (a *App) verify(args []string) error {
+	fs := a.flagSet("verify")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError("no file given")
+	}
+
+	var corrupted bool
+	for _, path := range fs.Args() {
+		var count, bad int
+		header, err := scanFile(path, func(h persist.BombHeader, index int, record []byte, recordErr error) error {
+			count++
+			if recordErr != nil {
+				bad++
+				fmt.Fprintf(a.Stdout, "%s: %v\n", path, recordErr)
+			}
+			return nil
+		})
+		if err != nil {
+			corrupted = true
+			fmt.Fprintf(a.Stdout, "%s: %v\n", path, err)
+			continue
+		}
+		if header.Count >= 0 && header.Count != count {
+			bad++
+			fmt.Fprintf(a.Stdout, "%s: header count %d, found %d records\n", path, header.Count, count)
+		}
+		if bad > 0 {
+			corrupted = true
+		}
+		compression := header.Compression
+		if compression == "" {
+			compression = persist.EBombCompressionNone
+		}
+		fmt.Fprintf(a.Stdout, "%s: persist=%s kind=%s codec=%s records=%d bad=%d\n", path, header.Name, header.Kind, compression, count, bad)
+	}
+	if corrupted {
+		return errCorrupted
+	}
+	return nil
+}
+
+// replay 将文件中的记录写回指定数据库
+func (a *App) replay(args []string) error {
+	fs := a.flagSet("replay")
+	driver := fs.String("driver", "mysql", "database driver")
+	dsn := fs.String("dsn", "", "database DSN")
+	remove := fs.Bool("remove", false, "remove the file after a successful replay")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one file required")
+	}
+	if *dsn == "" {
+		return usageError("-dsn is required")
+	}
+	path := fs.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	header, records, err := persist.DecodeBomb(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w, run split first", path, err)
+	}
+
+	engine, err := xorm.NewEngine(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	if err = engine.Ping(); err != nil {
+		return err
+	}
+	if err = a.register(engine); err != nil {
+		return err
+	}
+	p := lookup(header.Name)
+	if p == nil {
+		return fmt.Errorf("persist %s is not registered", header.Name)
+	}
+
+	switch header.Kind {
+	case persist.EBombKindTrace:
+		err = p.RecoverTrace(records)
+	default:
+		err = p.RecoverBomb(data)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "%s: replayed %d records into %s\n", path, len(records), header.Name)
+
+	if *remove {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// split 分离损坏的记录, 完好的记录写入 <file>.good, 损坏的记录写入 <file>.bad
+func (a *App) split(args []string) error {
+	fs := a.flagSet("split")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one file required")
+	}
+	path := fs.Arg(0)
+	if err := a.register(nil); err != nil {
+		return err
+	}
+
+	var good, bad [][]byte
+	header, err := scanFile(path, func(h persist.BombHeader, index int, record []byte, recordErr error) error {
+		if recordErr == nil {
+			if p := lookup(h.Name); p != nil && p.StringToPersistSyncInterface(base64.StdEncoding.EncodeToString(record)) == nil {
+				recordErr = errors.New("decode failed")
+			}
+		}
+		if recordErr != nil {
+			fmt.Fprintf(a.Stdout, "%s: record %d: %v\n", path, index, recordErr)
+			bad = append(bad, record)
+			return nil
+		}
+		good = append(good, record)
+		return nil
+	})
+	var truncated bool
+	if err != nil {
+		var recordErr *persist.BombRecordError
+		if header.Name == "" || !errors.As(err, &recordErr) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		truncated = true
+		fmt.Fprintf(a.Stdout, "%s: %v, remaining bytes dropped\n", path, err)
+	}
+
+	goodPath, badPath := splitPath(path, "good"), splitPath(path, "bad")
+	header.Time = time.Now()
+	if err = persist.WriteBombFile(goodPath, header, good); err != nil {
+		return err
+	}
+	if len(bad) > 0 {
+		if err = persist.WriteBombFile(badPath, header, bad); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(a.Stdout, "%s: good=%d -> %s bad=%d", path, len(good), goodPath, len(bad))
+	if len(bad) > 0 {
+		fmt.Fprintf(a.Stdout, " -> %s", badPath)
+	}
+	fmt.Fprintln(a.Stdout)
+	if len(bad) > 0 || truncated {
+		return errCorrupted
+	}
+	return nil
+}
+
+// splitPath split 输出文件路径, 临时文件输出为bomb文件
+func splitPath(path, suffix string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if ext == persist.ETmpFileExt {
+		ext = persist.EBombFileExt
+	}
+	return base + "." + suffix + ext
+}
+
+// auditNote discard 写入的审计记录
+type auditNote struct {
+	File     string    `json:"file"`
+	Archive  string    `json:"archive"`
+	Persist  string    `json:"persist"`
+	Kind     string    `json:"kind"`
+	Records  int       `json:"records"`
+	Bad      int       `json:"bad"`
+	Size     int       `json:"size"`
+	Sha256   string    `json:"sha256"`
+	Reason   string    `json:"reason"`
+	Operator string    `json:"operator"`
+	Time     time.Time `json:"time"`
+}
+
+// discard 归档文件并写入审计记录
+func (a *App) discard(args []string) error {
+	fs := a.flagSet("discard")
+	reason := fs.String("reason", "", "why the file is discarded (required)")
+	archive := fs.String("archive", "", "archive directory, defaults to <dir>/discarded")
+	operator := fs.String("operator", "", "operator name, defaults to the current user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one file required")
+	}
+	if *reason == "" {
+		return usageError("-reason is required")
+	}
+	path := fs.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	note := auditNote{
+		File:     path,
+		Size:     len(data),
+		Sha256:   hex.EncodeToString(sum[:]),
+		Reason:   *reason,
+		Operator: *operator,
+		Time:     time.Now(),
+	}
+	if note.Operator == "" {
+		if u, err := user.Current(); err == nil {
+			note.Operator = u.Username
+		}
+	}
+	// 损坏的文件同样允许归档, 审计记录中保留能识别的部分
+	header, _ := scanFile(path, func(header persist.BombHeader, index int, record []byte, recordErr error) error {
+		note.Records++
+		if recordErr != nil {
+			note.Bad++
+		}
+		return nil
+	})
+	note.Persist, note.Kind = header.Name, header.Kind
+
+	dir := *archive
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(path), "discarded")
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	note.Archive = filepath.Join(dir, filepath.Base(path)+"."+note.Time.Format("20060102150405"))
+	noteData, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(note.Archive+".audit.json", noteData, 0o644); err != nil {
+		return err
+	}
+	if err = os.Rename(path, note.Archive); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.Stdout, "%s: archived to %s\n", path, note.Archive)
+	return nil
+}
+
+// scanFile 逐条读取文件记录, 校验失败的记录通过 recordErr 传入, 无法继续读取时返回错误
+func scanFile(path string, fn func(header persist.BombHeader, index int, record []byte, recordErr error) error) (header persist.BombHeader, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer file.Close()
+
+	br, err := persist.NewBombReader(file)
+	if err != nil {
+		return header, err
+	}
+	defer br.Close()
+	for index := 0; ; index++ {
+		record, err := br.Next()
+		if err == io.EOF {
+			return br.Header, nil
+		}
+		if err != nil && !persist.IsBombChecksumError(err) {
+			return br.Header, err
+		}
+		if err = fn(br.Header, index, record, err); err != nil {
+			return br.Header, err
+		}
+	}
+}
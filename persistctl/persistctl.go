@@ -0,0 +1,133 @@
+// Package persistctl 提供 bomb/trace 文件的运维命令.
+//
+//	persistctl inspect [-persist name] file...           按注册的模型解码记录并输出 JSON
+//	persistctl verify file...                            校验文件头和记录校验和
+//	persistctl replay -dsn dsn [-driver mysql] file      将文件中的记录写回指定数据库
+//	persistctl split file                                分离损坏的记录
+//	persistctl discard -reason text [-archive dir] file  归档文件并写入审计记录
+//
+// 记录的解码和写回复用 persist 包的编解码器, 需要通过 Registrar 注册对应的 IPersist.
+package persistctl
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+const (
+	EExitOk    = 0 // 成功
+	EExitFail  = 1 // 执行失败或文件存在损坏
+	EExitUsage = 2 // 参数错误
+)
+
+// Registrar 注册模型persist, replay 时传入指定的数据库连接, 其它命令传入 nil
+type Registrar func(engine *xorm.Engine) error
+
+// App 命令行程序
+type App struct {
+	Stdout   io.Writer
+	Stderr   io.Writer
+	Register Registrar
+}
+
+// Main 使用标准输出运行命令, 返回退出码
+// 未设置 KeyProvider 时从环境变量读取加密文件的密钥, 见 persist.EnvKeyProvider
+func Main(args []string, register Registrar) int {
+	if persist.GetBombKeyProvider() == nil {
+		persist.SetBombKeyProvider(persist.EnvKeyProvider{})
+	}
+	app := &App{
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		Register: register,
+	}
+	return app.Run(args)
+}
+
+// Run 运行命令, 返回退出码
+func (a *App) Run(args []string) int {
+	if len(args) == 0 {
+		a.usage()
+		return EExitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "inspect":
+		err = a.inspect(args[1:])
+	case "verify":
+		err = a.verify(args[1:])
+	case "replay":
+		err = a.replay(args[1:])
+	case "split":
+		err = a.split(args[1:])
+	case "discard":
+		err = a.discard(args[1:])
+	case "help", "-h", "-help", "--help":
+		a.usage()
+		return EExitOk
+	default:
+		fmt.Fprintf(a.Stderr, "persistctl: unknown command %q\n", args[0])
+		a.usage()
+		return EExitUsage
+	}
+
+	var usageErr usageError
+	switch {
+	case err == nil:
+		return EExitOk
+	case errors.As(err, &usageErr), errors.Is(err, flag.ErrHelp):
+		fmt.Fprintf(a.Stderr, "persistctl %s: %v\n", args[0], err)
+		return EExitUsage
+	default:
+		fmt.Fprintf(a.Stderr, "persistctl %s: %v\n", args[0], err)
+		return EExitFail
+	}
+}
+
+// usage 输出帮助信息
+func (a *App) usage() {
+	fmt.Fprint(a.Stderr, `usage: persistctl <command> [flags] file...
+
+commands:
+  inspect   decode records to JSON using the registered persist
+  verify    check header and record checksums
+  replay    apply a bomb or trace file to the database given by -dsn
+  split     separate corrupted records into <file>.bad
+  discard   archive the file with an audit note
+`)
+}
+
+// usageError 参数错误
+type usageError string
+
+// Error 实现 error 接口
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// flagSet 创建子命令参数解析
+func (a *App) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.Stderr)
+	return fs
+}
+
+// register 注册模型persist
+func (a *App) register(engine *xorm.Engine) error {
+	if a.Register == nil {
+		return nil
+	}
+	return a.Register(engine)
+}
+
+// lookup 按名字查找注册的persist
+func lookup(name string) persist.IPersist {
+	return persist.GetIPersistByName(name)
+}
@@ -0,0 +1,212 @@
+package persistctl_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+	"github.com/spelens-gud/persist/persistctl"
+)
+
+// fakePersist 测试用persist, 记录内容为字符串, 以 bad 开头的记录无法解码
+type fakePersist struct {
+	persist.IPersist
+}
+
+func (f *fakePersist) PersistName() string { return "FakeGlobal" }
+
+func (f *fakePersist) StringToPersistSyncInterface(data string) any {
+	buf, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || strings.HasPrefix(string(buf), "bad") {
+		return nil
+	}
+	return map[string]string{"value": string(buf)}
+}
+
+func (f *fakePersist) RecoverBomb(bomb []byte) error { return nil }
+
+func (f *fakePersist) RecoverTrace(trace [][]byte) error { return nil }
+
+var registerOnce sync.Once
+
+// newApp 创建测试用命令行程序
+func newApp() (*persistctl.App, *bytes.Buffer) {
+	registerOnce.Do(func() {
+		persist.RegisterPersist(&fakePersist{})
+	})
+	var out bytes.Buffer
+	return &persistctl.App{Stdout: &out, Stderr: &out}, &out
+}
+
+// writeFile 写入测试bomb文件
+func writeFile(t *testing.T, records ...string) string {
+	t.Helper()
+	list := make([][]byte, len(records))
+	for i, record := range records {
+		list[i] = []byte(record)
+	}
+	path := filepath.Join(t.TempDir(), "FakeGlobal"+persist.EBombFileExt)
+	if err := persist.WriteBombFile(path, persist.BombHeader{Name: "FakeGlobal"}, list); err != nil {
+		t.Fatalf("WriteBombFile() error = %v", err)
+	}
+	return path
+}
+
+// corrupt 破坏文件中指定内容的校验
+func corrupt(t *testing.T, path, record string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := bytes.Index(data, []byte(record))
+	data[pos] ^= 0xff
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestApp_Usage 测试参数错误.
+func TestApp_Usage(t *testing.T) {
+	tests := [][]string{
+		nil,
+		{"unknown"},
+		{"inspect"},
+		{"replay", "file"},
+		{"discard", "file"},
+	}
+
+	for _, args := range tests {
+		app, _ := newApp()
+		if code := app.Run(args); code != persistctl.EExitUsage {
+			t.Errorf("Run(%q) = %d, want %d", args, code, persistctl.EExitUsage)
+		}
+	}
+}
+
+// TestApp_Inspect 测试按注册的模型解码记录.
+func TestApp_Inspect(t *testing.T) {
+	path := writeFile(t, "first", "second")
+	app, out := newApp()
+
+	if code := app.Run([]string{"inspect", path}); code != persistctl.EExitOk {
+		t.Fatalf("Run() = %d, output %s", code, out)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("inspect output %d lines, want 2", len(lines))
+	}
+	var record struct {
+		Index  int               `json:"index"`
+		Record map[string]string `json:"record"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Index != 1 || record.Record["value"] != "second" {
+		t.Errorf("inspect record = %+v", record)
+	}
+}
+
+// TestApp_InspectCompressed 测试边解压边解码.
+func TestApp_InspectCompressed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "FakeGlobal"+persist.EBombFileExt)
+	header := persist.BombHeader{Name: "FakeGlobal", Compression: persist.EBombCompressionSnappy}
+	if err := persist.WriteBombFile(path, header, [][]byte{[]byte("first")}); err != nil {
+		t.Fatal(err)
+	}
+	app, out := newApp()
+
+	if code := app.Run([]string{"inspect", path}); code != persistctl.EExitOk {
+		t.Fatalf("Run() = %d, output %s", code, out)
+	}
+	if !strings.Contains(out.String(), `"value":"first"`) {
+		t.Errorf("inspect output = %s", out)
+	}
+}
+
+// TestApp_Verify 测试校验损坏的文件.
+func TestApp_Verify(t *testing.T) {
+	path := writeFile(t, "first", "second")
+	app, out := newApp()
+	if code := app.Run([]string{"verify", path}); code != persistctl.EExitOk {
+		t.Fatalf("Run() = %d, output %s", code, out)
+	}
+
+	corrupt(t, path, "second")
+	app, out = newApp()
+	if code := app.Run([]string{"verify", path}); code != persistctl.EExitFail {
+		t.Fatalf("Run() = %d, want %d", code, persistctl.EExitFail)
+	}
+	if !strings.Contains(out.String(), "bad=1") {
+		t.Errorf("verify output = %s", out)
+	}
+}
+
+// TestApp_Split 测试分离损坏的记录.
+func TestApp_Split(t *testing.T) {
+	path := writeFile(t, "first", "bad record", "third", "fourth")
+	corrupt(t, path, "third")
+	app, out := newApp()
+
+	if code := app.Run([]string{"split", path}); code != persistctl.EExitFail {
+		t.Fatalf("Run() = %d, output %s", code, out)
+	}
+
+	dir := filepath.Dir(path)
+	goodData, err := os.ReadFile(filepath.Join(dir, "FakeGlobal.good.bomb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, good, err := persist.DecodeBomb(goodData)
+	if err != nil || len(good) != 2 || string(good[1]) != "fourth" {
+		t.Errorf("good records = %q, %v", good, err)
+	}
+	badData, err := os.ReadFile(filepath.Join(dir, "FakeGlobal.bad.bomb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, bad, err := persist.DecodeBomb(badData); err != nil || len(bad) != 2 {
+		t.Errorf("bad records = %q, %v", bad, err)
+	}
+}
+
+// TestApp_Discard 测试归档文件并写入审计记录.
+func TestApp_Discard(t *testing.T) {
+	path := writeFile(t, "first")
+	archive := t.TempDir()
+	app, out := newApp()
+
+	args := []string{"discard", "-reason", "rows already fixed by hand", "-operator", "ops", "-archive", archive, path}
+	if code := app.Run(args); code != persistctl.EExitOk {
+		t.Fatalf("Run() = %d, output %s", code, out)
+	}
+	if persist.DirExists(path) {
+		t.Error("discarded file should be moved")
+	}
+
+	notes, err := filepath.Glob(filepath.Join(archive, "*.audit.json"))
+	if err != nil || len(notes) != 1 {
+		t.Fatalf("audit notes = %v, %v", notes, err)
+	}
+	data, err := os.ReadFile(notes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var note map[string]any
+	if err = json.Unmarshal(data, &note); err != nil {
+		t.Fatal(err)
+	}
+	if note["persist"] != "FakeGlobal" || note["reason"] != "rows already fixed by hand" || note["operator"] != "ops" || note["records"] != 1.0 {
+		t.Errorf("audit note = %v", note)
+	}
+	if !persist.DirExists(strings.TrimSuffix(notes[0], ".audit.json")) {
+		t.Error("archived file not found")
+	}
+}
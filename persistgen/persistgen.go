@@ -0,0 +1,600 @@
+// Package persistgen 为模型结构体生成持久化代码.
+//
+// 读取带 xorm 和 hash 标签的结构体, 标签解析与 persist.GlobalMeta 共用, 生成:
+//
+//	<Type>FieldIndex<Field>   字段序号常量, 与位图位置一致
+//	<Type>DBFiledMap          字段序号 -> 数据库列名
+//	<Type>HashGroups          hash标签声明的索引组, 每组生成键类型 <Type>Hash<Fields> 和方法 Hash<Fields>
+//	Set<Field>                修改字段并标记位图的setter
+//	PersistEncodeField/...    实现 persist.PersistFieldCodec, 编解码不再使用反射
+//	<Type>Manager             按主键类型封装的 persist.GlobalManager
+//
+// 生成的编码格式与 persist.MarshalPersist 相同, 新旧数据可以互相读取.
+package persistgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/spelens-gud/persist"
+)
+
+const (
+	EPersistImport   = "github.com/spelens-gud/persist" // persist包路径
+	EGeneratedSuffix = "_persist.go"                    // 生成文件后缀
+)
+
+// Field 字段信息
+type Field struct {
+	Name     string // 字段名
+	Type     string // 字段类型源码
+	Column   string // 数据库列名, 不写入数据库为空
+	Pk       bool   // 是否主键
+	Exported bool   // 是否导出
+	Kind     string // 基础类型编码方式, 为空时使用编码计划
+	Comment  string // 字段注释
+}
+
+// HashGroup hash标签声明的索引组
+type HashGroup struct {
+	Group  int     // 组号
+	Unique bool    // 组内字段组合是否唯一
+	Fields []Field // 组内字段, 按声明顺序
+}
+
+// Name 组内字段名拼接, 用于生成的键类型和方法名
+func (h HashGroup) Name() string {
+	var b strings.Builder
+	for _, field := range h.Fields {
+		r := []rune(field.Name)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+// Model 模型信息
+type Model struct {
+	Package    string            // 包名
+	Name       string            // 结构体名
+	Fields     []Field           // 字段, 按声明顺序
+	HashGroups []HashGroup       // hash索引组, 按组号排序
+	Imports    map[string]string // 字段类型引用的包, 包名 -> 路径
+}
+
+// Pks 主键字段
+func (m *Model) Pks() []Field {
+	var pks []Field
+	for _, field := range m.Fields {
+		if field.Pk {
+			pks = append(pks, field)
+		}
+	}
+	return pks
+}
+
+// Parse 解析目录下的Go文件, 返回指定结构体的模型信息
+func Parse(dir, typeName string) (*Model, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var model *Model
+	methods := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, EGeneratedSuffix) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if recv := receiverName(decl); recv == typeName {
+					methods[decl.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					typeSpec, ok := spec.(*ast.TypeSpec)
+					if !ok || typeSpec.Name.Name != typeName {
+						continue
+					}
+					structType, ok := typeSpec.Type.(*ast.StructType)
+					if !ok {
+						return nil, fmt.Errorf("persistgen: %s is not a struct", typeName)
+					}
+					if typeSpec.TypeParams != nil {
+						return nil, fmt.Errorf("persistgen: generic type %s is not supported", typeName)
+					}
+					if model, err = parseStruct(fset, file, typeName, structType); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	if model == nil {
+		return nil, fmt.Errorf("persistgen: type %s not found in %s", typeName, dir)
+	}
+
+	// 生成的方法不能与已有方法冲突
+	for _, name := range model.methodNames() {
+		if methods[name] {
+			return nil, fmt.Errorf("persistgen: method %s.%s already declared", typeName, name)
+		}
+	}
+	return model, nil
+}
+
+// receiverName 方法接收者类型名
+func receiverName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// parseStruct 解析结构体字段
+func parseStruct(fset *token.FileSet, file *ast.File, typeName string, structType *ast.StructType) (*Model, error) {
+	model := &Model{
+		Package: file.Name.Name,
+		Name:    typeName,
+		Imports: make(map[string]string),
+	}
+	imports := fileImports(file)
+	groups := make(map[int]*HashGroup)
+
+	for _, astField := range structType.Fields.List {
+		var typeBuf bytes.Buffer
+		if err := printer.Fprint(&typeBuf, fset, astField.Type); err != nil {
+			return nil, err
+		}
+		typeStr := typeBuf.String()
+		if err := checkType(astField.Type); err != nil {
+			return nil, fmt.Errorf("persistgen: %s: %w", typeName, err)
+		}
+		for _, pkg := range typePackages(astField.Type) {
+			path, ok := imports[pkg]
+			if !ok {
+				return nil, fmt.Errorf("persistgen: %s: unknown package %s", typeName, pkg)
+			}
+			model.Imports[pkg] = path
+		}
+
+		var tag reflect.StructTag
+		if astField.Tag != nil {
+			value, err := strconv.Unquote(astField.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(value)
+		}
+		xormTag := persist.ParseXormTag(tag.Get("xorm"))
+		var hashTags [][2]int
+		for _, value := range persist.TagValues(tag, "hash") {
+			if group, unique, ok := persist.ParseHashTag(value); ok {
+				hashTags = append(hashTags, [2]int{group, boolInt(unique)})
+			}
+		}
+		comment := ""
+		if astField.Comment != nil {
+			comment = strings.TrimSpace(astField.Comment.Text())
+		}
+
+		names := astField.Names
+		if len(names) == 0 {
+			// 嵌入字段, 字段名为类型名
+			names = []*ast.Ident{ast.NewIdent(embeddedName(astField.Type))}
+		}
+		for _, ident := range names {
+			if ident.Name == "_" {
+				return nil, fmt.Errorf("persistgen: %s: blank field is not supported", typeName)
+			}
+			field := Field{
+				Name:     ident.Name,
+				Type:     typeStr,
+				Pk:       xormTag.Pk,
+				Exported: ast.IsExported(ident.Name),
+				Kind:     basicKind(astField.Type, imports),
+				Comment:  comment,
+			}
+			if field.Exported && !xormTag.Ignore {
+				field.Column = xormTag.Column
+				if field.Column == "" {
+					field.Column = snakeCase(ident.Name)
+				}
+			}
+			model.Fields = append(model.Fields, field)
+			for _, hashTag := range hashTags {
+				group := groups[hashTag[0]]
+				if group == nil {
+					group = &HashGroup{Group: hashTag[0], Unique: hashTag[1] == 1}
+					groups[hashTag[0]] = group
+				}
+				group.Fields = append(group.Fields, field)
+			}
+		}
+	}
+	if len(model.Fields) == 0 {
+		return nil, fmt.Errorf("persistgen: %s has no fields", typeName)
+	}
+
+	names := make(map[string]int)
+	for _, group := range groups {
+		model.HashGroups = append(model.HashGroups, *group)
+	}
+	sort.Slice(model.HashGroups, func(i, j int) bool {
+		return model.HashGroups[i].Group < model.HashGroups[j].Group
+	})
+	for _, group := range model.HashGroups {
+		if other, ok := names[group.Name()]; ok {
+			return nil, fmt.Errorf("persistgen: %s: hash groups %d and %d have the same fields", typeName, other, group.Group)
+		}
+		names[group.Name()] = group.Group
+	}
+	return model, nil
+}
+
+// boolInt bool 转 0 或 1
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// methodNames 生成的方法名
+func (m *Model) methodNames() []string {
+	names := []string{"PersistFieldNum", "PersistEncodeField", "PersistDecodeField"}
+	for _, field := range m.Fields {
+		if field.Exported {
+			names = append(names, "Set"+field.Name)
+		}
+	}
+	for _, group := range m.HashGroups {
+		names = append(names, "Hash"+group.Name())
+	}
+	return names
+}
+
+// fileImports 文件导入的包, 包名 -> 路径
+func fileImports(file *ast.File) map[string]string {
+	imports := make(map[string]string)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// typePackages 类型引用的包名
+func typePackages(expr ast.Expr) []string {
+	var pkgs []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				pkgs = append(pkgs, ident.Name)
+			}
+			return false
+		}
+		return true
+	})
+	return pkgs
+}
+
+// checkType 检查编码不支持的类型
+func checkType(expr ast.Expr) (err error) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FuncType, *ast.ChanType, *ast.InterfaceType:
+			err = fmt.Errorf("unsupported type %T", n)
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// embeddedName 嵌入字段名
+func embeddedName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(expr.X)
+	case *ast.SelectorExpr:
+		return expr.Sel.Name
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// basicKind 基础类型的编码方式, 自定义类型按编码计划处理
+func basicKind(expr ast.Expr, imports map[string]string) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		switch expr.Name {
+		case "bool", "string", "float32", "float64":
+			return expr.Name
+		case "int", "int8", "int16", "int32", "int64":
+			return "int"
+		case "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte":
+			return "uint"
+		}
+	case *ast.ArrayType:
+		if ident, ok := expr.Elt.(*ast.Ident); ok && expr.Len == nil && (ident.Name == "byte" || ident.Name == "uint8") {
+			return "bytes"
+		}
+	case *ast.SelectorExpr:
+		if ident, ok := expr.X.(*ast.Ident); ok && imports[ident.Name] == "time" && expr.Sel.Name == "Time" {
+			return "time"
+		}
+	}
+	return ""
+}
+
+// snakeCase 与 xorm SnakeMapper 相同的列名转换
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// lowerFirst 首字母小写, 用作参数名, 与关键字或接收者 g 同名时加后缀
+func lowerFirst(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	s := string(r)
+	if token.IsKeyword(s) || s == "g" {
+		return s + "_"
+	}
+	return s
+}
+
+// Generate 生成模型的持久化代码
+func Generate(model *Model) ([]byte, error) {
+	// 标准库和第三方包分组
+	var std, third []string
+	third = append(third, strconv.Quote(EPersistImport), strconv.Quote("xorm.io/xorm"))
+	for name, path := range model.Imports {
+		spec := strconv.Quote(path)
+		if filepath.Base(path) != name {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+			third = append(third, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(third)
+	var imports []string
+	imports = append(imports, std...)
+	if len(std) > 0 {
+		imports = append(imports, "")
+	}
+	imports = append(imports, third...)
+
+	var buf bytes.Buffer
+	err := gTemplate.Execute(&buf, struct {
+		*Model
+		Imports []string
+	}{model, imports})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("persistgen: format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// OutputName 生成文件名
+func OutputName(typeName string) string {
+	return snakeCase(typeName) + EGeneratedSuffix
+}
+
+var gTemplate = template.Must(template.New("persist").Funcs(template.FuncMap{
+	"lower": lowerFirst,
+}).Parse(`// Code generated by persistgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+{{if .}}	{{.}}{{end}}
+{{- end}}
+)
+
+{{$name := .Name -}}
+// {{$name}} 字段序号, 与位图位置一致
+const (
+{{- range $i, $f := .Fields}}
+	{{$name}}FieldIndex{{$f.Name}} persist.GlobalFieldIndex = {{$i}}{{if $f.Comment}} // {{$f.Comment}}{{end}}
+{{- end}}
+
+	{{$name}}FieldNum = {{len .Fields}} // 字段数量
+)
+
+// {{$name}}DBFiledMap 字段序号 -> 数据库列名, 非数据库字段为空
+var {{$name}}DBFiledMap = [{{$name}}FieldNum]string{
+{{- range .Fields}}
+	{{printf "%q" .Column}},
+{{- end}}
+}
+{{- if .HashGroups}}
+
+// {{$name}}HashGroups hash标签声明的索引组, 与 persist.GlobalMeta.HashGroups 相同
+var {{$name}}HashGroups = []persist.GlobalHashGroup{
+{{- range .HashGroups}}
+	{Group: {{.Group}}, Unique: {{.Unique}}, Fields: []persist.GlobalFieldIndex{ {{- range $i, $f := .Fields}}{{if $i}}, {{end}}{{$name}}FieldIndex{{$f.Name}}{{end -}} }},
+{{- end}}
+}
+{{- range .HashGroups}}
+
+// {{$name}}Hash{{.Name}} hash索引组{{.Group}}的键{{if .Unique}}, 组内唯一{{end}}
+type {{$name}}Hash{{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+}
+
+// Hash{{.Name}} hash索引组{{.Group}}的键
+func (m *{{$name}}) Hash{{.Name}}() {{$name}}Hash{{.Name}} {
+	return {{$name}}Hash{{.Name}}{ {{- range $i, $f := .Fields}}{{if $i}}, {{end}}{{$f.Name}}: m.{{$f.Name}}{{end -}} }
+}
+{{- end}}
+{{- end}}
+{{range .Fields}}{{if .Exported}}
+// Set{{.Name}} 修改 {{.Name}} 并标记位图
+func (m *{{$name}}) Set{{.Name}}(v {{.Type}}, bitSet *persist.GlobalBitSet[{{$name}}]) {
+	m.{{.Name}} = v
+	bitSet.Set({{$name}}FieldIndex{{.Name}})
+}
+{{end}}{{end}}
+// PersistFieldNum 字段数量
+func (m *{{$name}}) PersistFieldNum() int {
+	return {{$name}}FieldNum
+}
+
+// PersistEncodeField 编码第 i 个字段
+func (m *{{$name}}) PersistEncodeField(buf []byte, i persist.GlobalFieldIndex) ([]byte, error) {
+	switch i {
+{{- range .Fields}}
+	case {{$name}}FieldIndex{{.Name}}:
+{{- if eq .Kind "bool"}}
+		return persist.AppendPersistBool(buf, m.{{.Name}}), nil
+{{- else if and (eq .Kind "int") (eq .Type "int64")}}
+		return persist.AppendPersistInt(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "int"}}
+		return persist.AppendPersistInt(buf, int64(m.{{.Name}})), nil
+{{- else if and (eq .Kind "uint") (eq .Type "uint64")}}
+		return persist.AppendPersistUint(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "uint"}}
+		return persist.AppendPersistUint(buf, uint64(m.{{.Name}})), nil
+{{- else if eq .Kind "float32"}}
+		return persist.AppendPersistFloat32(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "float64"}}
+		return persist.AppendPersistFloat64(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "string"}}
+		return persist.AppendPersistString(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "bytes"}}
+		return persist.AppendPersistBytes(buf, m.{{.Name}}), nil
+{{- else if eq .Kind "time"}}
+		return persist.AppendPersistTime(buf, m.{{.Name}}), nil
+{{- else}}
+		return persist.AppendPersistValue(buf, &m.{{.Name}})
+{{- end}}
+{{- end}}
+	}
+	return buf, persist.EPersistErrorInvalidData
+}
+
+// PersistDecodeField 解码第 i 个字段
+func (m *{{$name}}) PersistDecodeField(d *persist.PersistDecoder, i persist.GlobalFieldIndex) (err error) {
+	switch i {
+{{- range .Fields}}
+	case {{$name}}FieldIndex{{.Name}}:
+{{- if eq .Kind "bool"}}
+		m.{{.Name}}, err = d.Bool()
+{{- else if and (eq .Kind "int") (eq .Type "int64")}}
+		m.{{.Name}}, err = d.Int()
+{{- else if and (eq .Kind "uint") (eq .Type "uint64")}}
+		m.{{.Name}}, err = d.Uint()
+{{- else if eq .Kind "int"}}
+		var v int64
+		v, err = d.Int()
+		m.{{.Name}} = {{.Type}}(v)
+{{- else if eq .Kind "uint"}}
+		var v uint64
+		v, err = d.Uint()
+		m.{{.Name}} = {{.Type}}(v)
+{{- else if eq .Kind "float32"}}
+		m.{{.Name}}, err = d.Float32()
+{{- else if eq .Kind "float64"}}
+		m.{{.Name}}, err = d.Float64()
+{{- else if eq .Kind "string"}}
+		m.{{.Name}}, err = d.String()
+{{- else if eq .Kind "bytes"}}
+		m.{{.Name}}, err = d.Bytes()
+{{- else if eq .Kind "time"}}
+		m.{{.Name}}, err = d.Time()
+{{- else}}
+		err = persist.DecodePersistValue(d, &m.{{.Name}})
+{{- end}}
+{{- end}}
+	default:
+		err = persist.EPersistErrorInvalidData
+	}
+	return
+}
+
+// {{$name}}Manager {{$name}} 管理器
+type {{$name}}Manager struct {
+	*persist.GlobalManager[{{$name}}]
+}
+
+// New{{$name}}Manager 创建 {{$name}} 管理器, engine 为 nil 时需要惰性注册
+func New{{$name}}Manager(engine *xorm.Engine) *{{$name}}Manager {
+	return &{{$name}}Manager{GlobalManager: persist.NewGlobalManager[{{$name}}](engine)}
+}
+
+// NewBitSet 创建空位图
+func (g *{{$name}}Manager) NewBitSet() persist.GlobalBitSet[{{$name}}] {
+	return persist.InitGlobalBitSet[{{$name}}]()
+}
+{{- $pks := .Pks}}{{if $pks}}
+
+// Get 按主键获取数据副本
+func (g *{{$name}}Manager) Get({{range $i, $f := $pks}}{{if $i}}, {{end}}{{lower $f.Name}} {{$f.Type}}{{end}}) (*{{$name}}, bool) {
+	return g.GlobalManager.Get({{range $i, $f := $pks}}{{if $i}}, {{end}}{{lower $f.Name}}{{end}})
+}
+
+// Delete 按主键删除数据
+func (g *{{$name}}Manager) Delete({{range $i, $f := $pks}}{{if $i}}, {{end}}{{lower $f.Name}} {{$f.Type}}{{end}}) error {
+	return g.GlobalManager.Delete({{range $i, $f := $pks}}{{if $i}}, {{end}}{{lower $f.Name}}{{end}})
+}
+{{- end}}
+`))
@@ -0,0 +1,164 @@
+package persistgen_test
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spelens-gud/persist/persistgen"
+)
+
+// writePackage 写入测试包
+func writePackage(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestParse 测试解析字段, 列名和主键.
+func TestParse(t *testing.T) {
+	dir := writePackage(t, map[string]string{"user.go": `package user
+
+import (
+	"time"
+
+	j "encoding/json"
+)
+
+type UserGlobal struct {
+	Id      int64  ` + "`xorm:\"pk 'user_id'\"`" + ` // 用户ID
+	Zone    int32  ` + "`xorm:\"pk\"`" + `
+	NickName string
+	Raw     j.RawMessage
+	Cache   string ` + "`xorm:\"-\"`" + `
+	login   time.Time
+}
+`})
+
+	model, err := persistgen.Parse(dir, "UserGlobal")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	columns := []string{"user_id", "zone", "nick_name", "raw", "", ""}
+	if len(model.Fields) != len(columns) {
+		t.Fatalf("fields = %+v", model.Fields)
+	}
+	for i, field := range model.Fields {
+		if field.Column != columns[i] {
+			t.Errorf("field %s column = %q, want %q", field.Name, field.Column, columns[i])
+		}
+	}
+	if pks := model.Pks(); len(pks) != 2 || pks[1].Name != "Zone" {
+		t.Errorf("Pks() = %+v", pks)
+	}
+	if model.Imports["j"] != "encoding/json" || model.Imports["time"] != "time" {
+		t.Errorf("Imports = %v", model.Imports)
+	}
+
+	src, err := persistgen.Generate(model)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if _, err = parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+		t.Fatalf("generated code does not parse: %v", err)
+	}
+	for _, want := range []string{
+		`j "encoding/json"`,
+		"func (g *UserGlobalManager) Get(id int64, zone int32) (*UserGlobal, bool)",
+		"func (m *UserGlobal) SetNickName(v string,",
+		"m.login, err = d.Time()",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+	if strings.Contains(string(src), "Setlogin") {
+		t.Error("unexported field should not have setter")
+	}
+}
+
+// TestGenerate_PkReceiver 测试主键参数与接收者同名时改名, 生成的代码可以编译.
+func TestGenerate_PkReceiver(t *testing.T) {
+	dir := writePackage(t, map[string]string{"m.go": "package m\ntype Model struct{ G int64 `xorm:\"pk\"` }\n"})
+	model, err := persistgen.Parse(dir, "Model")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	src, err := persistgen.Generate(model)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for _, want := range []string{
+		"func (g *ModelManager) Get(g_ int64) (*Model, bool)",
+		"return g.GlobalManager.Get(g_)",
+		"return g.GlobalManager.Delete(g_)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+// TestParse_Hash 测试hash标签生成索引组和键类型.
+func TestParse_Hash(t *testing.T) {
+	dir := writePackage(t, map[string]string{"m.go": "package m\ntype Model struct {\n" +
+		"\tId int64 `xorm:\"pk\" hash:\"group=2;unique=1\" hash:\"group=1\"`\n" +
+		"\tName string\n" +
+		"\tkind int32 `hash:\"group=1\" hash:\"unique=1\"`\n" +
+		"}\n"})
+	model, err := persistgen.Parse(dir, "Model")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(model.HashGroups) != 2 {
+		t.Fatalf("HashGroups = %+v", model.HashGroups)
+	}
+	if group := model.HashGroups[0]; group.Group != 1 || group.Unique || group.Name() != "IdKind" {
+		t.Errorf("HashGroups[0] = %+v", group)
+	}
+	if group := model.HashGroups[1]; group.Group != 2 || !group.Unique || group.Name() != "Id" {
+		t.Errorf("HashGroups[1] = %+v", group)
+	}
+
+	src, err := persistgen.Generate(model)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	for _, want := range []string{
+		"{Group: 1, Unique: false, Fields: []persist.GlobalFieldIndex{ModelFieldIndexId, ModelFieldIndexkind}},",
+		"{Group: 2, Unique: true, Fields: []persist.GlobalFieldIndex{ModelFieldIndexId}},",
+		"func (m *Model) HashIdKind() ModelHashIdKind {",
+		"return ModelHashIdKind{Id: m.Id, kind: m.kind}",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code missing %q", want)
+		}
+	}
+}
+
+// TestParse_Error 测试无法生成的模型.
+func TestParse_Error(t *testing.T) {
+	tests := map[string]string{
+		"missing":   "package m\n",
+		"func":      "package m\ntype Model struct{ Fn func() }\n",
+		"conflict":  "package m\ntype Model struct{ Name string }\nfunc (m *Model) SetName(string) {}\n",
+		"blank":     "package m\ntype Model struct{ _ int }\n",
+		"notstruct": "package m\ntype Model int\n",
+		"hash":      "package m\ntype Model struct{ A int `hash:\"group=1\" hash:\"group=2\"` }\n",
+		"hashfunc":  "package m\ntype Model struct{ A int `hash:\"group=1\"` }\nfunc (m *Model) HashA() {}\n",
+	}
+	for name, src := range tests {
+		dir := writePackage(t, map[string]string{"m.go": src})
+		if _, err := persistgen.Parse(dir, "Model"); err == nil {
+			t.Errorf("%s: Parse() should fail", name)
+		}
+	}
+}
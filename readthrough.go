@@ -0,0 +1,346 @@
+package persist
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+)
+
+// globalKeyLoad 按主键导入的状态, 同一主键同时只有一次数据库查询
+type globalKeyLoad struct {
+	state  int32         // EGlobalLoadState*
+	done   chan struct{} // 导入结束时关闭
+	err    error         // 导入失败的错误
+	pk     []any         // 按字段类型转换后的主键
+	access int64         // 最近访问时间, 纳秒, 开启淘汰时更新
+	evict  bool          // 由淘汰发起的导出
+	seq    int64         // 导出序号, 导出操作携带, 取消后旧的导出操作不再生效
+	miss   bool          // 数据库中不存在且之后没有修改, 未配置淘汰时可以移除
+}
+
+// SetReadThrough 开启按主键导入, 应在 Run 之前调用
+// 开启后 Get 和修改未在内存中的主键时从数据库导入并缓存, 之后内存数据为准, 不再查询数据库;
+// UnloadKey 在之前的修改写回后从内存移除. 已经全导入的表不按主键导入
+// 数据库中不存在的主键也记录导入状态, 未配置淘汰时数量达到上限后清除没有新建的记录, 之后访问重新查询
+func (g *GlobalManager[T]) SetReadThrough(enable bool) {
+	g.readThrough = enable
+}
+
+// KeyLoadState 主键的导入状态 EGlobalLoadState*, 全导入的表返回 EGlobalLoadStateMemory
+func (g *GlobalManager[T]) KeyLoadState(pk ...any) int32 {
+	if g.LoadState() == EGlobalTableStateMemory {
+		return EGlobalLoadStateMemory
+	}
+	if len(pk) != len(g.meta.PkIndex) {
+		return EGlobalLoadStateDisk
+	}
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	if load, ok := g.keyLoads[g.keyOf(pk)]; ok {
+		return load.state
+	}
+	return EGlobalLoadStateDisk
+}
+
+// LoadKey 从数据库导入主键对应的数据, 已导入时不查询, 同一主键并发导入只查询一次
+func (g *GlobalManager[T]) LoadKey(pk ...any) error {
+	if !g.readThrough {
+		return nil
+	}
+	if g.LoadState() == EGlobalTableStateMemory {
+		return EPersistErrorAlreadyLoadAll
+	}
+	if len(pk) != len(g.meta.PkIndex) {
+		return fmt.Errorf("%w: %d primary keys, want %d", EPersistErrorInvalidData, len(pk), len(g.meta.PkIndex))
+	}
+	model := g.pkModel(pk)
+	key := g.pkKey(model)
+	for {
+		g.keyMu.Lock()
+		load, ok := g.keyLoads[key]
+		if !ok {
+			load = &globalKeyLoad{state: EGlobalLoadStateLoading, done: make(chan struct{}), pk: g.pkValues(model)}
+			g.keyLoads[key] = load
+			g.keyMu.Unlock()
+			g.cacheMisses.Add(1)
+			return g.loadKey(key, load)
+		}
+		state, done := load.state, load.done
+		if state != EGlobalLoadStateLoading {
+			// 已导入或正在导出, 内存数据为准
+			g.touchLocked(load)
+			g.keyMu.Unlock()
+			g.cacheHits.Add(1)
+			return nil
+		}
+		g.keyMu.Unlock()
+		<-done
+		if load.err != nil {
+			return load.err
+		}
+	}
+}
+
+// loadKey 查询数据库, 结束后唤醒等待的导入
+func (g *GlobalManager[T]) loadKey(key any, load *globalKeyLoad) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", EPersistErrorUnknownError, r)
+		}
+		g.keyMu.Lock()
+		if err != nil {
+			load.err = err
+			delete(g.keyLoads, key)
+		} else {
+			load.state = EGlobalLoadStateMemory
+			g.touchLocked(load)
+		}
+		g.keyMu.Unlock()
+		close(load.done)
+	}()
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	row := g.pkModel(load.pk)
+	session := g.keyReadEngine(key).NewSession()
+	defer session.Close()
+	has, err := g.on(session, row).ID(schemas.PK(load.pk)).Get(row)
+	if err != nil {
+		g.logError("load key", err, nil)
+		return err
+	}
+	if has {
+		// 导入期间新建的数据以内存为准
+		g.rows.LoadOrStore(key, row)
+	} else if !g.evictEnabled() {
+		g.recordMiss(load)
+	}
+	return nil
+}
+
+// recordMiss 记录数据库中不存在的主键, 未配置淘汰时数量达到 eGlobalKeyMissPrune 后移除全部没有新建的记录
+func (g *GlobalManager[T]) recordMiss(load *globalKeyLoad) {
+	g.keyMu.Lock()
+	load.miss = true
+	g.keyMisses++
+	prune := g.keyMisses >= eGlobalKeyMissPrune
+	g.keyMu.Unlock()
+	if !prune {
+		return
+	}
+
+	// 持有 opMu 时没有进行中的新建, 不会移除已导入但还未写入内存的主键
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	for key, other := range g.keyLoads {
+		if other.miss && other.state == EGlobalLoadStateMemory {
+			delete(g.keyLoads, key)
+		}
+	}
+	g.keyMisses = 0
+}
+
+// keepKey 新建后内存数据为准, 主键不再作为数据库中不存在的记录移除, 调用时持有 opMu
+func (g *GlobalManager[T]) keepKey(key any) {
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	if load, ok := g.keyLoads[key]; ok {
+		load.miss = false
+	}
+}
+
+// lockKey 修改前导入主键并加锁, 成功时持有 opMu; 正在导出时返回 EPersistErrorUnloading
+func (g *GlobalManager[T]) lockKey(pk []any) error {
+	if !g.readThrough || g.LoadState() == EGlobalTableStateMemory {
+		g.opMu.Lock()
+		return nil
+	}
+	if len(pk) != len(g.meta.PkIndex) {
+		g.opMu.Lock()
+		return nil
+	}
+	key := g.keyOf(pk)
+	for {
+		if err := g.LoadKey(pk...); err != nil {
+			return err
+		}
+		g.opMu.Lock()
+		g.keyMu.Lock()
+		state := int32(EGlobalLoadStateDisk)
+		if load, ok := g.keyLoads[key]; ok {
+			state = load.state
+		}
+		g.keyMu.Unlock()
+		switch state {
+		case EGlobalLoadStateMemory:
+			g.touchKey(key)
+			return nil
+		case EGlobalLoadStatePrepareUnloading:
+			if g.cancelEvict(key) {
+				return nil
+			}
+			g.opMu.Unlock()
+			return EPersistErrorUnloading
+		case EGlobalLoadStateUnloading:
+			g.opMu.Unlock()
+			return EPersistErrorUnloading
+		}
+		// 导入后又被导出, 重新导入
+		g.opMu.Unlock()
+	}
+}
+
+// UnloadKey 导出主键, 之前的修改写回数据库后从内存移除, 写回失败时取消导出
+// 导出开始后到移除前可以读取, 修改返回 EPersistErrorUnloading
+func (g *GlobalManager[T]) UnloadKey(pk ...any) error {
+	if !g.readThrough {
+		return EPersistErrorIncorrectState
+	}
+	if g.LoadState() == EGlobalTableStateMemory {
+		return EPersistErrorAlreadyLoadAll
+	}
+	if len(pk) != len(g.meta.PkIndex) {
+		return fmt.Errorf("%w: %d primary keys, want %d", EPersistErrorInvalidData, len(pk), len(g.meta.PkIndex))
+	}
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	if atomic.LoadInt32(&g.managerState) != EGlobalManagerStateNormal {
+		return EPersistErrorIncorrectState
+	}
+
+	return g.unloadKey(g.keyOf(pk), false)
+}
+
+// unloadKey 发送导出操作, 调用时持有 opMu
+func (g *GlobalManager[T]) unloadKey(key any, evict bool) error {
+	g.keyMu.Lock()
+	load, ok := g.keyLoads[key]
+	if !ok {
+		g.keyMu.Unlock()
+		return EPersistErrorAlreadyUnload
+	}
+	switch load.state {
+	case EGlobalLoadStateLoading:
+		g.keyMu.Unlock()
+		return EPersistErrorLoading
+	case EGlobalLoadStatePrepareUnloading, EGlobalLoadStateUnloading:
+		g.keyMu.Unlock()
+		return EPersistErrorUnloading
+	}
+	load.state = EGlobalLoadStatePrepareUnloading
+	load.evict = evict
+	load.seq++
+	seq := load.seq
+	g.keyMu.Unlock()
+
+	g.syncChan <- &GlobalSync[T]{Data: g.pkModel(load.pk), Op: EGlobalOpUnload, Version: seq}
+	return nil
+}
+
+// finishUnload 写回协程执行到导出操作, 之前的修改都已写回, 从内存移除
+func (g *GlobalManager[T]) finishUnload(persistSync *GlobalSync[T]) {
+	key := g.pkKey(persistSync.Data)
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	load, ok := g.keyLoads[key]
+	if !ok || load.state != EGlobalLoadStatePrepareUnloading || load.seq != persistSync.Version {
+		return
+	}
+	load.state = EGlobalLoadStateUnloading
+	g.rows.Delete(key)
+	delete(g.keyLoads, key)
+	if load.evict {
+		g.evictions.Add(1)
+	}
+	if g.replica != nil {
+		g.recordUnload(key)
+	}
+}
+
+// recordUnload 记录主键的导出时间, 调用时持有 keyMu; 数量翻倍时清理过期的记录
+func (g *GlobalManager[T]) recordUnload(key any) {
+	now := time.Now()
+	g.unloadedAt[key] = now
+	if len(g.unloadedAt) < g.unloadPrune {
+		return
+	}
+	window := g.replica.config.staleWindow()
+	for k, at := range g.unloadedAt {
+		if now.Sub(at) >= window {
+			delete(g.unloadedAt, k)
+		}
+	}
+	g.unloadPrune = max(eGlobalUnloadPrune, 2*len(g.unloadedAt))
+}
+
+// keyReadEngine 按主键导入使用的数据库连接
+// 导出时修改刚写入主库, 从库可能还未同步, 导出后的一段时间内从主库读取
+func (g *GlobalManager[T]) keyReadEngine(key any) *xorm.Engine {
+	if g.replica == nil {
+		return g.engine
+	}
+	g.keyMu.Lock()
+	at, ok := g.unloadedAt[key]
+	delete(g.unloadedAt, key)
+	g.keyMu.Unlock()
+	if ok && time.Since(at) < g.replica.config.staleWindow() {
+		g.replica.primaryReads.Add(1)
+		return g.engine
+	}
+	return g.replica.pick()
+}
+
+// cancelUnload 导出之前的修改写回失败, 保留在内存中
+func (g *GlobalManager[T]) cancelUnload(persistSync *GlobalSync[T]) {
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	load, ok := g.keyLoads[g.pkKey(persistSync.Data)]
+	if ok && load.state == EGlobalLoadStatePrepareUnloading && load.seq == persistSync.Version {
+		load.state = EGlobalLoadStateMemory
+		load.evict = false
+	}
+}
+
+// cancelEvict 修改淘汰中的主键时取消淘汰, 调用时持有 opMu, 已发送的导出操作不再生效
+func (g *GlobalManager[T]) cancelEvict(key any) bool {
+	g.keyMu.Lock()
+	defer g.keyMu.Unlock()
+	load, ok := g.keyLoads[key]
+	if !ok || load.state != EGlobalLoadStatePrepareUnloading || !load.evict {
+		return false
+	}
+	load.state = EGlobalLoadStateMemory
+	load.evict = false
+	load.seq++
+	g.touchLocked(load)
+	return true
+}
+
+// keyOf 按字段类型转换后的主键, 与内存数据的主键一致
+func (g *GlobalManager[T]) keyOf(pk []any) any {
+	return g.pkKey(g.pkModel(pk))
+}
+
+// pkModel 只设置主键字段的数据
+func (g *GlobalManager[T]) pkModel(pk []any) *T {
+	cls := new(T)
+	v := reflect.ValueOf(cls).Elem()
+	for i, idx := range g.meta.PkIndex {
+		field := v.Field(int(idx))
+		value := reflect.ValueOf(pk[i])
+		if !value.IsValid() {
+			continue
+		}
+		// 只在数值之间转换, 避免整数转换为字符
+		if value.Type().ConvertibleTo(field.Type()) && (value.Kind() == reflect.String) == (field.Kind() == reflect.String) {
+			field.Set(value.Convert(field.Type()))
+		}
+	}
+	return cls
+}
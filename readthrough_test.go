@@ -0,0 +1,185 @@
+package persist_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestGlobalManager_ReadThrough 测试按主键导入: 不在内存中的主键从数据库导入, 并发导入结果一致, 导入后以内存为准.
+func TestGlobalManager_ReadThrough(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 1, Name: "db"}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetReadThrough(true)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.KeyLoadState(int64(1)); got != persist.EGlobalLoadStateDisk {
+		t.Errorf("KeyLoadState() = %d, want disk", got)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			// 整数类型与字段类型不同时按字段类型转换
+			if row, ok := g.Get(1); !ok || row.Name != "db" {
+				t.Errorf("Get() = %+v, %v", row, ok)
+			}
+		})
+	}
+	wg.Wait()
+	if got := g.KeyLoadState(int64(1)); got != persist.EGlobalLoadStateMemory {
+		t.Errorf("KeyLoadState() = %d, want memory", got)
+	}
+
+	// 导入后不再查询数据库
+	if _, err := engine.ID(int64(1)).Cols("name").Update(&ManagerGlobal{Name: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := g.Get(int64(1)); !ok || row.Name != "db" {
+		t.Errorf("Get() after db change = %+v, %v", row, ok)
+	}
+	// 数据库中不存在的主键只查询一次, 之后新建不会冲突
+	if _, ok := g.Get(int64(2)); ok {
+		t.Error("Get() missing key ok = true")
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 2, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadKey(int64(1), int64(2)); !errors.Is(err, persist.EPersistErrorInvalidData) {
+		t.Errorf("LoadKey() wrong pk count error = %v", err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	got := new(ManagerGlobal)
+	if has, err := engine.ID(int64(2)).Get(got); err != nil || !has || got.Name != "new" {
+		t.Errorf("inserted row = %+v, %v, %v", got, has, err)
+	}
+}
+
+// TestGlobalManager_ReadThroughWrite 测试修改和删除不在内存中的主键时先导入, 已存在的主键新建失败, 删除的主键不再导入.
+func TestGlobalManager_ReadThroughWrite(t *testing.T) {
+	g, engine := newTestManager(t)
+	if _, err := engine.Insert([]*ManagerGlobal{{AuthId: 1, Name: "a"}, {AuthId: 2, Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetReadThrough(true)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.Update(&ManagerGlobal{AuthId: 1, Name: "a2"}, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatalf("Update() cold key error = %v", err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 2}); !errors.Is(err, persist.EPersistErrorAlreadyExist) {
+		t.Errorf("Insert() existing key error = %v", err)
+	}
+	if err := g.Delete(int64(2)); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, ok := g.Get(int64(2)); ok {
+		t.Error("Get() deleted key reloaded")
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	got := new(ManagerGlobal)
+	if has, err := engine.ID(int64(1)).Get(got); err != nil || !has || got.Name != "a2" {
+		t.Errorf("updated row = %+v, %v, %v", got, has, err)
+	}
+	if n := countRows(t, engine); n != 1 {
+		t.Errorf("rows = %d, want 1", n)
+	}
+}
+
+// TestGlobalManager_ReadThroughMissPrune 测试未配置淘汰时数据库中不存在的主键数量有上限, 新建过的主键不移除.
+func TestGlobalManager_ReadThroughMissPrune(t *testing.T) {
+	const prune = 4096 // 与 eGlobalKeyMissPrune 一致
+	g, engine := newTestManager(t)
+	g.SetReadThrough(true)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	for id := int64(1); id < prune; id++ {
+		if _, ok := g.Get(id); ok {
+			t.Fatalf("Get(%d) missing key ok = true", id)
+		}
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 5, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete(int64(6)); err != nil {
+		t.Fatal(err)
+	}
+	if stats := g.CacheStats(); stats.Rows != prune-1 {
+		t.Errorf("CacheStats().Rows = %d, want %d", stats.Rows, prune-1)
+	}
+
+	if _, ok := g.Get(int64(prune)); ok {
+		t.Fatal("Get() missing key ok = true")
+	}
+	// 保留新建过的 5, 6 和触发清理时正在导入的主键
+	if stats := g.CacheStats(); stats.Rows != 3 {
+		t.Errorf("CacheStats().Rows after prune = %d, want 3", stats.Rows)
+	}
+	// 删除的主键仍以内存为准, 不从数据库重新导入
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 6, Name: "stale"}); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := g.Get(int64(6)); ok {
+		t.Errorf("Get() deleted key = %+v", row)
+	}
+	if row, ok := g.Get(int64(5)); !ok || row.Name != "new" {
+		t.Errorf("Get() inserted key = %+v, %v", row, ok)
+	}
+	if got := g.KeyLoadState(int64(1)); got != persist.EGlobalLoadStateDisk {
+		t.Errorf("KeyLoadState() pruned key = %d, want disk", got)
+	}
+}
+
+// TestGlobalManager_UnloadKey 测试导出主键: 之前的修改写回后从内存移除, 导出期间修改返回 EPersistErrorUnloading, 移除后再次导入.
+func TestGlobalManager_UnloadKey(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetReadThrough(true)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: 20 * time.Millisecond, MaxInterval: 20 * time.Millisecond})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.UnloadKey(int64(1)); !errors.Is(err, persist.EPersistErrorAlreadyUnload) {
+		t.Errorf("UnloadKey() unloaded key error = %v", err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.UnloadKey(int64(1)); err != nil {
+		t.Fatalf("UnloadKey() error = %v", err)
+	}
+	if err := g.Update(&ManagerGlobal{AuthId: 1, Name: "b"}, persist.GlobalBitSet[ManagerGlobal]{}); !errors.Is(err, persist.EPersistErrorUnloading) {
+		t.Errorf("Update() while unloading error = %v", err)
+	}
+	if err := g.UnloadKey(int64(1)); !errors.Is(err, persist.EPersistErrorUnloading) {
+		t.Errorf("UnloadKey() twice error = %v", err)
+	}
+
+	waitFor(t, func() bool { return g.KeyLoadState(int64(1)) == persist.EGlobalLoadStateDisk })
+	if n := countRows(t, engine); n != 1 {
+		t.Fatalf("rows after unload = %d, want 1", n)
+	}
+	// 移除后数据库中的修改可以重新导入
+	if _, err := engine.ID(int64(1)).Cols("name").Update(&ManagerGlobal{Name: "db"}); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := g.Get(int64(1)); !ok || row.Name != "db" {
+		t.Errorf("Get() after unload = %+v, %v", row, ok)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
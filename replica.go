@@ -0,0 +1,177 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+)
+
+const (
+	eGlobalReplicaLagTTL = time.Second // 默认延迟检查结果的缓存时间
+	eMysqlErrParse       = 1064        // MySQL 语法错误 ER_PARSE_ERROR
+	eGlobalUnloadPrune   = 64          // 导出时间记录清理的最小数量
+)
+
+// GlobalReplicaConfig 从库读取配置
+// 全导入, 预热和按主键导入从从库读取; Sync, 写回, 冲突处理和bomb恢复始终使用主库
+// 主键导出后 MaxLag+LagTTL 内重新导入从主库读取, 避免读到从库未同步的旧数据
+type GlobalReplicaConfig struct {
+	MaxLag time.Duration // 复制延迟上限, 超过时换其它从库, 都超过时从主库读取, 为0时不检查
+	LagTTL time.Duration // 延迟检查结果的缓存时间, 为0时按 eGlobalReplicaLagTTL
+
+	// Lag 查询从库的复制延迟, 为 nil 时 MySQL 按 SHOW REPLICA STATUS 查询, 其它数据库需要指定
+	Lag func(replica *xorm.Engine) (time.Duration, error)
+}
+
+// staleWindow 导出后从库可能未同步的时间, 其间按主键导入从主库读取
+func (c *GlobalReplicaConfig) staleWindow() time.Duration {
+	return c.MaxLag + c.LagTTL
+}
+
+// GlobalReplicaStats 读取统计
+type GlobalReplicaStats struct {
+	ReplicaReads int64 // 从从库读取的次数
+	PrimaryReads int64 // 从库不可用或延迟过大, 从主库读取的次数
+}
+
+// globalReplica 从库选择和延迟检查
+type globalReplica struct {
+	group  *xorm.EngineGroup
+	config GlobalReplicaConfig
+
+	mu     sync.Mutex
+	checks map[*xorm.Engine]replicaCheck // 从库 -> 最近一次延迟检查结果
+
+	replicaReads atomic.Int64
+	primaryReads atomic.Int64
+}
+
+// replicaCheck 延迟检查结果
+type replicaCheck struct {
+	at time.Time
+	ok bool
+}
+
+// SetEngineGroup 使用数据库组, 主库写入, 从库读取, 应在 Sync 之前调用
+// 配置了延迟上限但无法查询延迟时返回 EPersistErrorInvalidConfig
+func (g *GlobalManager[T]) SetEngineGroup(group *xorm.EngineGroup, config GlobalReplicaConfig) error {
+	if group == nil {
+		return EPersistErrorEngineNil
+	}
+	if config.MaxLag < 0 || config.LagTTL < 0 {
+		return fmt.Errorf("%w: replica max lag %v, lag ttl %v", EPersistErrorInvalidConfig, config.MaxLag, config.LagTTL)
+	}
+	if config.MaxLag > 0 && config.Lag == nil {
+		if group.Master().Dialect().URI().DBType != schemas.MYSQL {
+			return fmt.Errorf("%w: replica lag check needs Lag for %s", EPersistErrorInvalidConfig, group.Master().Dialect().URI().DBType)
+		}
+		config.Lag = mysqlReplicaLag
+	}
+	if config.LagTTL == 0 {
+		config.LagTTL = eGlobalReplicaLagTTL
+	}
+	g.engine = group.Master()
+	g.initFields()
+	g.replica = &globalReplica{group: group, config: config, checks: make(map[*xorm.Engine]replicaCheck)}
+	return nil
+}
+
+// ReplicaStats 从库读取统计, 未使用数据库组时为零值
+func (g *GlobalManager[T]) ReplicaStats() GlobalReplicaStats {
+	if g.replica == nil {
+		return GlobalReplicaStats{}
+	}
+	return GlobalReplicaStats{
+		ReplicaReads: g.replica.replicaReads.Load(),
+		PrimaryReads: g.replica.primaryReads.Load(),
+	}
+}
+
+// readEngine 导入使用的数据库连接, 未使用数据库组时为主库
+func (g *GlobalManager[T]) readEngine() *xorm.Engine {
+	if g.replica == nil {
+		return g.engine
+	}
+	return g.replica.pick()
+}
+
+// pick 按数据库组的策略选择从库, 延迟过大时按顺序换其它从库, 都不可用时返回主库
+func (r *globalReplica) pick() *xorm.Engine {
+	slaves := r.group.Slaves()
+	if len(slaves) > 0 {
+		if first := r.group.Slave(); r.healthy(first) {
+			r.replicaReads.Add(1)
+			return first
+		}
+		for _, slave := range slaves {
+			if r.healthy(slave) {
+				r.replicaReads.Add(1)
+				return slave
+			}
+		}
+	}
+	r.primaryReads.Add(1)
+	return r.group.Master()
+}
+
+// healthy 从库延迟是否不超过上限, 检查结果在 LagTTL 内复用
+func (r *globalReplica) healthy(slave *xorm.Engine) bool {
+	if slave == nil || slave == r.group.Master() {
+		return false
+	}
+	if r.config.MaxLag <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	check, ok := r.checks[slave]
+	r.mu.Unlock()
+	if ok && time.Since(check.at) < r.config.LagTTL {
+		return check.ok
+	}
+
+	lag, err := r.config.Lag(slave)
+	check = replicaCheck{at: time.Now(), ok: err == nil && lag <= r.config.MaxLag}
+	if err != nil {
+		msg := &Error{Err: fmt.Errorf("replica lag: %w", err), Type: ErrorTypeOp}
+		msg.Println(DefaultErrorWriter)
+	}
+	r.mu.Lock()
+	r.checks[slave] = check
+	r.mu.Unlock()
+	return check.ok
+}
+
+// mysqlReplicaLag 按 SHOW REPLICA STATUS 查询 MySQL 从库延迟, 复制停止时返回错误
+// 不支持该语句的旧版本 (MySQL 8.0.22 之前) 按 SHOW SLAVE STATUS 查询;
+// MariaDB 支持 SHOW REPLICA STATUS 但仍返回 Seconds_Behind_Master, 按列是否存在选择列名
+func mysqlReplicaLag(replica *xorm.Engine) (time.Duration, error) {
+	rows, err := replica.QueryString("SHOW REPLICA STATUS")
+	if mysqlErr := (*mysql.MySQLError)(nil); errors.As(err, &mysqlErr) && mysqlErr.Number == eMysqlErrParse {
+		rows, err = replica.QueryString("SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, fmt.Errorf("%w: not a replica", EPersistErrorIncorrectState)
+	}
+	value, ok := rows[0]["Seconds_Behind_Source"]
+	if !ok {
+		value, ok = rows[0]["Seconds_Behind_Master"]
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: no replication lag column", EPersistErrorIncorrectState)
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: replication stopped", EPersistErrorIncorrectState)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,171 @@
+package persist_test
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+// newTestGroup 创建主库和一个从库的数据库组, 主从各有一行主键相同但名字不同的数据
+func newTestGroup(t *testing.T) (*xorm.EngineGroup, *xorm.Engine, *xorm.Engine) {
+	t.Helper()
+	_, primary := newTestManager(t)
+	replica, err := xorm.NewEngine("sqlite", filepath.Join(t.TempDir(), "replica.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = replica.Close() })
+	if err = replica.Sync(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = primary.Insert(&ManagerGlobal{AuthId: 1, Name: "primary"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replica.Insert(&ManagerGlobal{AuthId: 1, Name: "replica"}); err != nil {
+		t.Fatal(err)
+	}
+	group, err := xorm.NewEngineGroup(primary, []*xorm.Engine{replica})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return group, primary, replica
+}
+
+// TestGlobalManager_ReplicaLoad 测试全导入从从库读取, 写回写入主库.
+func TestGlobalManager_ReplicaLoad(t *testing.T) {
+	group, primary, replica := newTestGroup(t)
+	g := persist.NewGlobalManager[ManagerGlobal](nil)
+	if err := g.SetEngineGroup(group, persist.GlobalReplicaConfig{MaxLag: time.Second}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("SetEngineGroup() sqlite without Lag error = %v", err)
+	}
+	if err := g.SetEngineGroup(group, persist.GlobalReplicaConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := g.Get(int64(1)); !ok || row.Name != "replica" {
+		t.Errorf("Get() = %+v, %v, want replica row", row, ok)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 2}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	if n, _ := primary.Count(new(ManagerGlobal)); n != 2 {
+		t.Errorf("primary rows = %d, want 2", n)
+	}
+	if n, _ := replica.Count(new(ManagerGlobal)); n != 1 {
+		t.Errorf("replica rows = %d, want 1", n)
+	}
+	if stats := g.ReplicaStats(); stats.ReplicaReads != 1 || stats.PrimaryReads != 0 {
+		t.Errorf("ReplicaStats() = %+v", stats)
+	}
+}
+
+// TestGlobalManager_ReplicaLag 测试从库延迟超过上限时从主库导入, 延迟检查结果在缓存时间内复用.
+func TestGlobalManager_ReplicaLag(t *testing.T) {
+	group, _, _ := newTestGroup(t)
+	var checks atomic.Int32
+	g := persist.NewGlobalManager[ManagerGlobal](nil)
+	err := g.SetEngineGroup(group, persist.GlobalReplicaConfig{
+		MaxLag: time.Second,
+		LagTTL: time.Hour,
+		Lag: func(*xorm.Engine) (time.Duration, error) {
+			checks.Add(1)
+			return 10 * time.Second, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.SetReadThrough(true)
+	if err = g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if row, ok := g.Get(int64(1)); !ok || row.Name != "primary" {
+		t.Errorf("Get() lagging replica = %+v, %v, want primary row", row, ok)
+	}
+	if _, ok := g.Get(int64(3)); ok {
+		t.Error("Get() missing key ok = true")
+	}
+	if stats := g.ReplicaStats(); stats.PrimaryReads != 2 || stats.ReplicaReads != 0 {
+		t.Errorf("ReplicaStats() = %+v", stats)
+	}
+	if checks.Load() != 1 {
+		t.Errorf("lag checks = %d, want 1", checks.Load())
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_ReplicaUnloadReload 测试主键导出后从库未同步的时间内从主库重新导入, 之后恢复从从库读取.
+func TestGlobalManager_ReplicaUnloadReload(t *testing.T) {
+	group, primary, _ := newTestGroup(t)
+	g := persist.NewGlobalManager[ManagerGlobal](nil)
+	if err := g.SetEngineGroup(group, persist.GlobalReplicaConfig{LagTTL: 100 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetReadThrough(true)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	row, ok := g.Get(int64(1))
+	if !ok || row.Name != "replica" {
+		t.Fatalf("Get() = %+v, %v, want replica row", row, ok)
+	}
+	row.Name = "fresh"
+	if err := g.Update(row, persist.GlobalBitSet[ManagerGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.UnloadKey(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.KeyLoadState(int64(1)) == persist.EGlobalLoadStateDisk })
+	if got := getName(t, primary, 1); got != "fresh" {
+		t.Fatalf("primary name = %q, want fresh", got)
+	}
+
+	// 从库仍是旧数据, 导出后立即导入从主库读取
+	if row, ok = g.Get(int64(1)); !ok || row.Name != "fresh" {
+		t.Errorf("Get() after unload = %+v, %v, want primary row", row, ok)
+	}
+	if stats := g.ReplicaStats(); stats.ReplicaReads != 1 || stats.PrimaryReads != 1 {
+		t.Errorf("ReplicaStats() after unload = %+v", stats)
+	}
+
+	if err := g.UnloadKey(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.KeyLoadState(int64(1)) == persist.EGlobalLoadStateDisk })
+	time.Sleep(150 * time.Millisecond)
+	if row, ok = g.Get(int64(1)); !ok || row.Name != "replica" {
+		t.Errorf("Get() after window = %+v, %v, want replica row", row, ok)
+	}
+	if stats := g.ReplicaStats(); stats.ReplicaReads != 2 || stats.PrimaryReads != 1 {
+		t.Errorf("ReplicaStats() after window = %+v", stats)
+	}
+}
+
+// getName 直接从数据库读取名字
+func getName(t *testing.T, engine *xorm.Engine, id int64) string {
+	t.Helper()
+	row := &ManagerGlobal{AuthId: id}
+	if has, err := engine.Get(row); err != nil || !has {
+		t.Fatalf("db Get(%d) = %v, %v", id, has, err)
+	}
+	return row.Name
+}
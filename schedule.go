@@ -0,0 +1,259 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScheduleJob 定时任务类型
+type ScheduleJob int8
+
+const (
+	EScheduleJobSegmentation ScheduleJob = 1 // 调用 Segmentation 提前创建分表
+	EScheduleJobSyncData     ScheduleJob = 2 // 调用 SyncData 写回失败队列, 管理器运行中时跳过
+)
+
+// String 任务名
+func (j ScheduleJob) String() string {
+	switch j {
+	case EScheduleJobSegmentation:
+		return "segmentation"
+	case EScheduleJobSyncData:
+		return "sync data"
+	}
+	return fmt.Sprintf("job(%d)", int8(j))
+}
+
+// ScheduleConfig 定时任务配置, Cron 不为空时按 cron 表达式执行, 否则按 Interval 执行
+type ScheduleConfig struct {
+	Cron     string        // cron 表达式, 格式见 CronSchedule
+	Interval time.Duration // 执行间隔
+	Jitter   time.Duration // 每次执行前随机等待 [0, Jitter), 避免多台机器同时执行
+}
+
+// ScheduleStats 定时任务统计
+type ScheduleStats struct {
+	Name     string      // persist名
+	Job      ScheduleJob // 任务类型
+	Runs     int64       // 执行次数
+	Skips    int64       // 上一次执行未结束而跳过的次数
+	Failures int64       // 执行失败次数
+}
+
+// validate 检查配置, 返回解析后的 cron 表达式
+func (c *ScheduleConfig) validate(name string, job ScheduleJob) (*CronSchedule, error) {
+	if job != EScheduleJobSegmentation && job != EScheduleJobSyncData {
+		return nil, fmt.Errorf("%w: schedule %s", EPersistErrorInvalidConfig, job)
+	}
+	if c.Cron != "" {
+		return ParseCron(c.Cron)
+	}
+	if c.Interval <= 0 {
+		return nil, fmt.Errorf("%w: schedule %s %s needs cron or interval", EPersistErrorInvalidConfig, name, job)
+	}
+	return nil, nil
+}
+
+// scheduleEntry 一个 persist 的一个定时任务
+type scheduleEntry struct {
+	persist IPersist
+	job     ScheduleJob
+	config  ScheduleConfig
+	cron    *CronSchedule
+
+	running  atomic.Bool
+	runs     atomic.Int64
+	skips    atomic.Int64
+	failures atomic.Int64
+}
+
+// next 下一次执行时间, 不含随机等待
+func (e *scheduleEntry) next(now time.Time) time.Time {
+	if e.cron != nil {
+		return e.cron.Next(now)
+	}
+	return now.Add(e.config.Interval)
+}
+
+// Scheduler 定时执行 persist 的分表和失败队列写回
+// 每个任务在独立的协程中执行, 上一次执行未结束时跳过本次, 错误按 Error 输出到 DefaultErrorWriter
+type Scheduler struct {
+	mu      sync.Mutex
+	entries []*scheduleEntry
+	stop    chan struct{}
+	wg      sync.WaitGroup // 调度协程和正在执行的任务
+}
+
+// NewScheduler 创建调度器
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add 添加定时任务, 配置无效时返回 EPersistErrorInvalidConfig, 应在 Start 之前调用
+func (s *Scheduler) Add(persist IPersist, job ScheduleJob, config ScheduleConfig) error {
+	cron, err := config.validate(persist.PersistName(), job)
+	if err != nil {
+		return err
+	}
+	entry := &scheduleEntry{persist: persist, job: job, config: config, cron: cron}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// Start 启动调度, 重复调用不重复启动
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	for _, entry := range s.entries {
+		s.wg.Add(1)
+		go s.loop(entry, s.stop)
+	}
+}
+
+// Stop 停止调度, 等待正在执行的任务结束
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	s.wg.Wait()
+}
+
+// Stats 所有任务的统计
+func (s *Scheduler) Stats() []ScheduleStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]ScheduleStats, 0, len(s.entries))
+	for _, entry := range s.entries {
+		stats = append(stats, ScheduleStats{
+			Name:     entry.persist.PersistName(),
+			Job:      entry.job,
+			Runs:     entry.runs.Load(),
+			Skips:    entry.skips.Load(),
+			Failures: entry.failures.Load(),
+		})
+	}
+	return stats
+}
+
+// loop 调度协程, 到达执行时间后加上随机等待再执行
+func (s *Scheduler) loop(entry *scheduleEntry, stop chan struct{}) {
+	defer s.wg.Done()
+	for {
+		next := entry.next(time.Now())
+		if next.IsZero() {
+			return
+		}
+		wait := time.Until(next)
+		if entry.config.Jitter > 0 {
+			wait += rand.N(entry.config.Jitter)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return
+		}
+		if !entry.running.CompareAndSwap(false, true) {
+			entry.skips.Add(1)
+			continue
+		}
+		s.wg.Add(1)
+		go s.run(entry)
+	}
+}
+
+// run 执行一次任务
+func (s *Scheduler) run(entry *scheduleEntry) {
+	defer s.wg.Done()
+	defer entry.running.Store(false)
+	entry.runs.Add(1)
+
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%w: %v", EPersistErrorUnknownError, r)
+			}
+		}()
+		var wg sync.WaitGroup
+		switch entry.job {
+		case EScheduleJobSegmentation:
+			err = entry.persist.Segmentation(&wg)
+		case EScheduleJobSyncData:
+			if err = entry.persist.SyncData(&wg, false); errors.Is(err, EPersistErrorIncorrectState) {
+				// 运行中的管理器由写回协程处理失败队列
+				err = nil
+			}
+		}
+	}()
+	if err != nil {
+		entry.failures.Add(1)
+		msg := &Error{Err: fmt.Errorf("%s %s: %w", entry.persist.PersistName(), entry.job, err), Type: ErrorTypeOp}
+		msg.SetMeta(H{"persist": entry.persist.PersistName(), "job": entry.job.String()})
+		msg.Println(DefaultErrorWriter)
+	}
+}
+
+var (
+	gScheduler       = NewScheduler()
+	gScheduleConfigs = make(map[string]map[ScheduleJob]ScheduleConfig) // persist名 -> 任务配置, 空名为默认配置
+)
+
+// SetSchedule 设置 persist 的定时任务, name 为空时作为所有 persist 的默认配置, 应在 StartScheduler 之前调用
+func SetSchedule(name string, job ScheduleJob, config ScheduleConfig) error {
+	if _, err := config.validate(name, job); err != nil {
+		return err
+	}
+	if gScheduleConfigs[name] == nil {
+		gScheduleConfigs[name] = make(map[ScheduleJob]ScheduleConfig)
+	}
+	gScheduleConfigs[name][job] = config
+	return nil
+}
+
+// StartScheduler 按 SetSchedule 的配置为所有注册的 persist 启动定时任务, ExitPersists 时停止
+func StartScheduler() error {
+	StopScheduler()
+	gScheduler = NewScheduler()
+	for name, persist := range gPersistMap {
+		for _, job := range []ScheduleJob{EScheduleJobSegmentation, EScheduleJobSyncData} {
+			config, ok := gScheduleConfigs[name][job]
+			if !ok {
+				if config, ok = gScheduleConfigs[""][job]; !ok {
+					continue
+				}
+			}
+			if err := gScheduler.Add(persist, job, config); err != nil {
+				return err
+			}
+		}
+	}
+	gScheduler.Start()
+	return nil
+}
+
+// StopScheduler 停止定时任务, 等待正在执行的任务结束
+func StopScheduler() {
+	gScheduler.Stop()
+}
+
+// GetScheduleStats 定时任务统计
+func GetScheduleStats() []ScheduleStats {
+	return gScheduler.Stats()
+}
@@ -0,0 +1,126 @@
+package persist_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestParseCron 测试 cron 表达式解析和下一次执行时间.
+func TestParseCron(t *testing.T) {
+	base := time.Date(2026, 10, 18, 10, 30, 15, 0, time.UTC) // 周日
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 18, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 18, 10, 45, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"5 9-17/4 * * 1-5", time.Date(2026, 10, 19, 9, 5, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)},
+		{"0 0 31 2,4 *", time.Time{}},
+		{"0 0 13 * 5", time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 2", time.Date(2026, 10, 27, 0, 0, 0, 0, time.UTC)}, // 日以 * 开头时与周同时满足
+		{"30 10 18 10 *", time.Date(2027, 10, 18, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := persist.ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron() error = %v", err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := persist.ParseCron(expr); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+			t.Errorf("ParseCron(%q) error = %v", expr, err)
+		}
+	}
+}
+
+// schedulePersist 记录定时任务调用的 persist
+type schedulePersist struct {
+	persist.IPersist
+	name     string
+	running  atomic.Int32
+	overlap  atomic.Bool
+	segments atomic.Int32
+	delay    time.Duration
+	err      error
+}
+
+func (p *schedulePersist) PersistName() string { return p.name }
+
+func (p *schedulePersist) Segmentation(wg *sync.WaitGroup) error {
+	if p.running.Add(1) > 1 {
+		p.overlap.Store(true)
+	}
+	defer p.running.Add(-1)
+	p.segments.Add(1)
+	time.Sleep(p.delay)
+	return p.err
+}
+
+func (p *schedulePersist) SyncData(wg *sync.WaitGroup, sentryDebug bool) error {
+	return persist.EPersistErrorIncorrectState
+}
+
+// TestScheduler 测试定时任务按间隔执行, 跳过重叠的执行, 统计失败并在停止时等待执行结束.
+func TestScheduler(t *testing.T) {
+	slow := &schedulePersist{name: "slow", delay: 30 * time.Millisecond}
+	failed := &schedulePersist{name: "failed", err: errors.New("boom")}
+	s := persist.NewScheduler()
+	for _, p := range []*schedulePersist{slow, failed} {
+		if err := s.Add(p, persist.EScheduleJobSegmentation, persist.ScheduleConfig{Interval: 5 * time.Millisecond, Jitter: time.Millisecond}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(slow, persist.EScheduleJobSyncData, persist.ScheduleConfig{Interval: 5 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(slow, persist.EScheduleJobSyncData, persist.ScheduleConfig{}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("Add() without schedule error = %v", err)
+	}
+
+	s.Start()
+	waitFor(t, func() bool { return slow.segments.Load() >= 3 && failed.segments.Load() >= 3 })
+	s.Stop()
+	if slow.running.Load() != 0 {
+		t.Error("Stop() returned while job running")
+	}
+	if slow.overlap.Load() {
+		t.Error("overlapping runs")
+	}
+
+	segments := slow.segments.Load()
+	time.Sleep(20 * time.Millisecond)
+	if slow.segments.Load() != segments {
+		t.Error("job ran after Stop()")
+	}
+
+	for _, stats := range s.Stats() {
+		switch {
+		case stats.Name == "slow" && stats.Job == persist.EScheduleJobSegmentation:
+			if stats.Skips == 0 || stats.Failures != 0 {
+				t.Errorf("slow stats = %+v", stats)
+			}
+		case stats.Name == "failed":
+			if stats.Failures != stats.Runs || stats.Runs == 0 {
+				t.Errorf("failed stats = %+v", stats)
+			}
+		case stats.Job == persist.EScheduleJobSyncData:
+			if stats.Runs == 0 || stats.Failures != 0 {
+				t.Errorf("sync data stats = %+v", stats)
+			}
+		}
+	}
+}
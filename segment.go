@@ -0,0 +1,244 @@
+package persist
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+)
+
+// GlobalSegmentPeriod 分表周期
+type GlobalSegmentPeriod int8
+
+const (
+	EGlobalSegmentNone  GlobalSegmentPeriod = 0 // 不分表
+	EGlobalSegmentDay   GlobalSegmentPeriod = 1 // 按天分表, 表名后缀如 _20261018
+	EGlobalSegmentMonth GlobalSegmentPeriod = 2 // 按月分表, 表名后缀如 _202610
+)
+
+// GlobalSegmentConfig 按时间分表配置, 适用于只追加的日志表
+// 每条数据按时间字段写入对应周期的表, 跨越周期边界时正在写回的数据仍写入各自的表
+type GlobalSegmentConfig struct {
+	Period   GlobalSegmentPeriod // 分表周期
+	Field    string              // 时间字段名, time.Time 或 Unix 秒的整数, 为零值时新建时设置为当前时间
+	Ahead    int                 // 提前创建之后几个周期的表, 为0时提前创建1个
+	Location *time.Location      // 周期边界的时区, 为 nil 时使用 time.Local
+}
+
+// globalSegment 分表状态
+type globalSegment struct {
+	GlobalSegmentConfig
+	field  int // 时间字段序号
+	mu     sync.Mutex
+	tables []string // 已创建的表名, 按时间顺序
+}
+
+// layout 表名后缀的时间格式
+func (p GlobalSegmentPeriod) layout() string {
+	if p == EGlobalSegmentDay {
+		return "20060102"
+	}
+	return "200601"
+}
+
+// begin 时间所在周期的开始时间
+func (s *globalSegment) begin(t time.Time) time.Time {
+	t = t.In(s.Location)
+	if s.Period == EGlobalSegmentDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.Location)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.Location)
+}
+
+// next 下一个周期的开始时间
+func (s *globalSegment) next(t time.Time) time.Time {
+	t = s.begin(t)
+	if s.Period == EGlobalSegmentDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+// SetSegment 设置按时间分表, 应在 Sync 之前调用, 时间字段不存在或类型不支持时返回 EPersistErrorInvalidConfig
+func (g *GlobalManager[T]) SetSegment(config GlobalSegmentConfig) error {
+	if config.Period == EGlobalSegmentNone {
+		g.segment = nil
+		return nil
+	}
+	if config.Period != EGlobalSegmentDay && config.Period != EGlobalSegmentMonth {
+		return fmt.Errorf("%w: segment period %d", EPersistErrorInvalidConfig, config.Period)
+	}
+	idx, ok := g.meta.FieldIndex(config.Field)
+	if !ok {
+		return fmt.Errorf("%w: segment field %s", EPersistErrorInvalidConfig, config.Field)
+	}
+	switch t := g.meta.FieldTypes[idx]; {
+	case t.ConvertibleTo(schemas.TimeType):
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+	default:
+		return fmt.Errorf("%w: segment field %s type %s", EPersistErrorInvalidConfig, config.Field, t)
+	}
+	if config.Ahead <= 0 {
+		config.Ahead = 1
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	g.segment = &globalSegment{GlobalSegmentConfig: config, field: int(idx)}
+	return nil
+}
+
+// SegmentTable 时间对应的表名, 未分表时返回原表名
+func (g *GlobalManager[T]) SegmentTable(t time.Time) string {
+	name := g.baseTable()
+	if g.segment == nil {
+		return name
+	}
+	return name + "_" + t.In(g.segment.Location).Format(g.segment.Period.layout())
+}
+
+// SegmentTables 已创建的分表, 按时间顺序
+func (g *GlobalManager[T]) SegmentTables() []string {
+	if g.segment == nil {
+		return nil
+	}
+	g.segment.mu.Lock()
+	defer g.segment.mu.Unlock()
+	return slices.Clone(g.segment.tables)
+}
+
+// stampSegment 新建时时间字段为零值则设置为当前时间, 之后的修改和删除写入同一张表
+func (g *GlobalManager[T]) stampSegment(cls *T) {
+	if g.segment == nil {
+		return
+	}
+	v := reflect.ValueOf(cls).Elem().Field(g.segment.field)
+	if !v.IsZero() {
+		return
+	}
+	now := time.Now()
+	switch {
+	case v.Type().ConvertibleTo(schemas.TimeType):
+		v.Set(reflect.ValueOf(now).Convert(v.Type()))
+	case v.CanInt():
+		v.SetInt(now.Unix())
+	default:
+		v.SetUint(uint64(now.Unix()))
+	}
+}
+
+// timeOf 数据的时间字段, 零值时为当前时间
+func (g *GlobalManager[T]) timeOf(cls *T) time.Time {
+	v := reflect.ValueOf(cls).Elem().Field(g.segment.field)
+	var t time.Time
+	switch {
+	case v.Type().ConvertibleTo(schemas.TimeType):
+		t = v.Convert(schemas.TimeType).Interface().(time.Time)
+	case v.CanInt():
+		if v.Int() != 0 {
+			t = time.Unix(v.Int(), 0)
+		}
+	default:
+		if v.Uint() != 0 {
+			t = time.Unix(int64(v.Uint()), 0)
+		}
+	}
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+// tableOf 数据写入的表名, 未分表且未指定表名时为空
+func (g *GlobalManager[T]) tableOf(cls *T) string {
+	if g.segment == nil {
+		return g.table
+	}
+	return g.SegmentTable(g.timeOf(cls))
+}
+
+// on 按数据选择写入的表, 未分表且未指定表名时直接返回 session
+func (g *GlobalManager[T]) on(session *xorm.Session, cls *T) *xorm.Session {
+	if name := g.tableOf(cls); name != "" {
+		return session.Table(name)
+	}
+	return session
+}
+
+// tableName 数据写入的表名, 包含 schema
+func (g *GlobalManager[T]) tableName(cls *T) string {
+	if name := g.tableOf(cls); name != "" {
+		return g.engine.TableName(name, true)
+	}
+	return g.engine.TableName(cls, true)
+}
+
+// ensureSegment 数据对应的分表不存在时创建, 写回延迟或未按时执行 Segmentation 时不会丢失数据
+func (g *GlobalManager[T]) ensureSegment(cls *T) error {
+	if g.segment == nil {
+		return nil
+	}
+	return g.createSegment(g.tableOf(cls), false)
+}
+
+// createSegment 创建分表, force 为false时已创建的表不重复同步表结构
+func (g *GlobalManager[T]) createSegment(name string, force bool) error {
+	s := g.segment
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 表名后缀为定长时间, 按字符串排序即按时间排序
+	i, found := slices.BinarySearch(s.tables, name)
+	if found && !force {
+		return nil
+	}
+	if err := g.engine.Table(name).Sync(new(T)); err != nil {
+		g.logError("segment "+name, err, nil)
+		return err
+	}
+	if !found {
+		s.tables = slices.Insert(s.tables, i, name)
+	}
+	return nil
+}
+
+// scanSegments 查找之前创建的分表
+func (g *GlobalManager[T]) scanSegments() error {
+	metas, err := g.engine.DBMetas()
+	if err != nil {
+		return err
+	}
+	prefix := g.baseTable() + "_"
+	layout := g.segment.Period.layout()
+	s := g.segment
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, meta := range metas {
+		suffix, ok := strings.CutPrefix(meta.Name, prefix)
+		if !ok || len(suffix) != len(layout) || slices.Contains(s.tables, meta.Name) {
+			continue
+		}
+		if _, err = time.Parse(layout, suffix); err != nil {
+			continue
+		}
+		s.tables = append(s.tables, meta.Name)
+	}
+	slices.Sort(s.tables)
+	return nil
+}
+
+// segmentation 创建当前周期和之后 Ahead 个周期的表, 已存在的表同步表结构
+func (g *GlobalManager[T]) segmentation(now time.Time) error {
+	t := g.segment.begin(now)
+	for i := 0; i <= g.segment.Ahead; i++ {
+		if err := g.createSegment(g.SegmentTable(t), true); err != nil {
+			return err
+		}
+		t = g.segment.next(t)
+	}
+	return nil
+}
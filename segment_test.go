@@ -0,0 +1,130 @@
+package persist_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+type SegmentGlobal struct {
+	Id      int64     `xorm:"pk"`
+	Name    string    `xorm:""`
+	Created time.Time `xorm:""`
+}
+
+// segmentRows 分表的行数
+func segmentRows(t *testing.T, engine *xorm.Engine, table string) int64 {
+	t.Helper()
+	n, err := engine.Table(table).Count(new(SegmentGlobal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// TestGlobalManager_SetSegment 测试分表配置校验.
+func TestGlobalManager_SetSegment(t *testing.T) {
+	g := persist.NewGlobalManager[SegmentGlobal](nil)
+	tests := []struct {
+		name   string
+		config persist.GlobalSegmentConfig
+		ok     bool
+	}{
+		{"none", persist.GlobalSegmentConfig{}, true},
+		{"month", persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentMonth, Field: "Created"}, true},
+		{"int field", persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentDay, Field: "Id"}, true},
+		{"bad period", persist.GlobalSegmentConfig{Period: 9, Field: "Created"}, false},
+		{"no field", persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentDay, Field: "Missing"}, false},
+		{"string field", persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentDay, Field: "Name"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.SetSegment(tt.config)
+			if tt.ok != (err == nil) || (err != nil && !errors.Is(err, persist.EPersistErrorInvalidConfig)) {
+				t.Errorf("SetSegment() error = %v, ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+// TestGlobalManager_Segmentation 测试按时间分表: 提前创建分表, 每条数据写入时间对应的表, 跨越边界的数据不丢失.
+func TestGlobalManager_Segmentation(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[SegmentGlobal](engine)
+	loc := time.FixedZone("UTC+8", 8*3600)
+	if err := g.SetSegment(persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentMonth, Field: "Created", Ahead: 2, Location: loc}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(loc)
+	begin := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	for i := range 3 {
+		table := g.SegmentTable(begin.AddDate(0, i, 0))
+		if ok, err := engine.IsTableExist(table); err != nil || !ok {
+			t.Errorf("IsTableExist(%s) = %v, %v", table, ok, err)
+		}
+	}
+	if got := len(g.SegmentTables()); got != 3 {
+		t.Errorf("SegmentTables() = %d, want 3", got)
+	}
+
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Hour, MaxInterval: time.Hour})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// 边界前后各一条, 旧的表未提前创建
+	boundary := time.Date(2024, 3, 1, 0, 0, 0, 0, loc)
+	if err := g.Insert(&SegmentGlobal{Id: 1, Name: "feb", Created: boundary.Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&SegmentGlobal{Id: 2, Name: "mar", Created: boundary}); err != nil {
+		t.Fatal(err)
+	}
+	stamped := &SegmentGlobal{Id: 3, Name: "now"}
+	if err := g.Insert(stamped); err != nil {
+		t.Fatal(err)
+	}
+	if stamped.Created.IsZero() {
+		t.Error("Insert() did not stamp Created")
+	}
+	row, _ := g.Get(int64(2))
+	row.Name = "mar2"
+	if err := g.Update(row, persist.GlobalBitSet[SegmentGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	before, after := g.SegmentTable(boundary.Add(-time.Second)), g.SegmentTable(boundary)
+	if before != engine.TableName(new(SegmentGlobal))+"_202402" || after != engine.TableName(new(SegmentGlobal))+"_202403" {
+		t.Fatalf("SegmentTable() = %s, %s", before, after)
+	}
+	if n := segmentRows(t, engine, before); n != 1 {
+		t.Errorf("%s rows = %d, want 1", before, n)
+	}
+	got := new(SegmentGlobal)
+	if has, err := engine.Table(after).ID(2).Get(got); err != nil || !has || got.Name != "mar2" {
+		t.Errorf("%s row = %+v, %v, %v", after, got, has, err)
+	}
+	if n := segmentRows(t, engine, g.SegmentTable(stamped.Created)); n != 1 {
+		t.Errorf("current rows = %d, want 1", n)
+	}
+
+	// 重启后查找已有的分表
+	g2 := persist.NewGlobalManager[SegmentGlobal](engine)
+	if err := g2.SetSegment(persist.GlobalSegmentConfig{Period: persist.EGlobalSegmentMonth, Field: "Created", Location: loc}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g2.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	tables := g2.SegmentTables()
+	if len(tables) < 5 || tables[0] != before || tables[1] != after {
+		t.Errorf("SegmentTables() = %v", tables)
+	}
+}
@@ -0,0 +1,373 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sync"
+
+	"xorm.io/xorm"
+)
+
+// GlobalShardConfig 水平分片配置
+// 每个分片是独立的全局管理器, 有自己的写回协程, 失败队列和bomb文件, 一个分片写回失败不影响其它分片
+type GlobalShardConfig struct {
+	Shards  int               // 分片数量, 修改后需要迁移数据
+	Engines []*xorm.Engine    // 分片的数据库连接, 分片 i 使用 Engines[i%len(Engines)], 为空时在 LazyInit 中获取
+	Tables  bool              // 每个分片写入独立的表 表名_序号, 为false时各分片写入各自数据库中的同名表
+	Field   string            // 分片字段名, 必须是主键字段, 为空时使用第一个主键字段
+	Shard   func(key any) int // 自定义分片函数, 返回值按分片数量取模, 为 nil 时整数按值取模, 其它类型按 FNV-1a 哈希取模
+}
+
+// ShardedManager 按主键字段哈希分片的全局管理器, 实现 IPersist
+// 与 GlobalManager 一样不实现 IPersistUser, 注册表的 Load, Unload 和空闲导出不会调用分片管理器的按用户导入导出
+type ShardedManager[T any] struct {
+	name   string
+	config GlobalShardConfig
+	field  int // 分片字段在主键中的位置
+	shards []*GlobalManager[T]
+}
+
+// NewShardedManager 创建分片管理器, 分片字段不是主键字段时返回 EPersistErrorInvalidConfig
+// 不使用独立的表时每个分片需要独立的数据库连接, 否则多个分片共用同一张表, 全导入时重复导入
+func NewShardedManager[T any](config GlobalShardConfig) (*ShardedManager[T], error) {
+	if config.Shards <= 0 {
+		return nil, fmt.Errorf("%w: %d shards", EPersistErrorInvalidConfig, config.Shards)
+	}
+	if !config.Tables && config.Shards > 1 && len(config.Engines) < config.Shards {
+		return nil, fmt.Errorf("%w: %d shards share %d engines without tables", EPersistErrorInvalidConfig, config.Shards, len(config.Engines))
+	}
+	meta := GetGlobalMeta[T]()
+	if len(meta.PkIndex) == 0 {
+		return nil, fmt.Errorf("%w: %s has no primary key", EPersistErrorInvalidConfig, meta.Type.Name())
+	}
+	s := &ShardedManager[T]{name: meta.Type.Name(), config: config, field: -1}
+	if config.Field == "" {
+		s.field = 0
+	} else if idx, ok := meta.FieldIndex(config.Field); ok {
+		for i, pk := range meta.PkIndex {
+			if pk == idx {
+				s.field = i
+			}
+		}
+	}
+	if s.field < 0 {
+		return nil, fmt.Errorf("%w: shard field %s is not a primary key", EPersistErrorInvalidConfig, config.Field)
+	}
+
+	s.shards = make([]*GlobalManager[T], config.Shards)
+	for i := range s.shards {
+		var engine *xorm.Engine
+		if len(config.Engines) > 0 {
+			engine = config.Engines[i%len(config.Engines)]
+		}
+		g := NewGlobalManager[T](engine)
+		// 分片名用于bomb文件和日志
+		g.name = fmt.Sprintf("%s_%d", s.name, i)
+		if config.Tables && engine != nil {
+			g.SetTable(fmt.Sprintf("%s_%d", engine.TableName(new(T)), i))
+		}
+		s.shards[i] = g
+	}
+	return s, nil
+}
+
+// Shards 所有分片, 可在 Run 之前逐个设置写回调度, 过载保护等配置
+func (s *ShardedManager[T]) Shards() []*GlobalManager[T] {
+	return s.shards
+}
+
+// ShardIndex 分片字段值对应的分片序号
+func (s *ShardedManager[T]) ShardIndex(key any) int {
+	n := len(s.shards)
+	if s.config.Shard != nil {
+		return ((s.config.Shard(key) % n) + n) % n
+	}
+	v := reflect.ValueOf(key)
+	switch {
+	case v.CanInt():
+		return int(uint64(v.Int()) % uint64(n))
+	case v.CanUint():
+		return int(v.Uint() % uint64(n))
+	}
+	h := fnv.New64a()
+	_, _ = fmt.Fprint(h, key)
+	return int(h.Sum64() % uint64(n))
+}
+
+// Shard 数据所在的分片
+func (s *ShardedManager[T]) Shard(cls *T) *GlobalManager[T] {
+	g := s.shards[0]
+	return s.shards[s.ShardIndex(g.pkValues(cls)[s.field])]
+}
+
+// shardOfPk 主键所在的分片, 主键数量不正确时返回 nil
+func (s *ShardedManager[T]) shardOfPk(pk []any) *GlobalManager[T] {
+	if len(pk) != len(s.shards[0].meta.PkIndex) {
+		return nil
+	}
+	return s.shards[s.ShardIndex(pk[s.field])]
+}
+
+// ShardByName 按分片名查找分片, 用于按bomb文件头中的名字恢复
+func (s *ShardedManager[T]) ShardByName(name string) IPersist {
+	for _, g := range s.shards {
+		if g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// Insert 新建数据, 写入分片字段对应的分片
+func (s *ShardedManager[T]) Insert(cls *T) error {
+	if cls == nil {
+		return EPersistErrorNil
+	}
+	return s.Shard(cls).Insert(cls)
+}
+
+// Update 修改数据, 规则同 GlobalManager.Update
+func (s *ShardedManager[T]) Update(cls *T, bitSet GlobalBitSet[T]) error {
+	if cls == nil {
+		return EPersistErrorNil
+	}
+	return s.Shard(cls).Update(cls, bitSet)
+}
+
+// Delete 按主键删除数据, 联合主键按字段顺序传入
+func (s *ShardedManager[T]) Delete(pk ...any) error {
+	g := s.shardOfPk(pk)
+	if g == nil {
+		return EPersistErrorNotInMemory
+	}
+	return g.Delete(pk...)
+}
+
+// Get 按主键获取数据副本
+func (s *ShardedManager[T]) Get(pk ...any) (*T, bool) {
+	g := s.shardOfPk(pk)
+	if g == nil {
+		return nil, false
+	}
+	return g.Get(pk...)
+}
+
+// SetReadThrough 所有分片开启按主键导入, 应在 Run 之前调用
+func (s *ShardedManager[T]) SetReadThrough(enable bool) {
+	for _, g := range s.shards {
+		g.SetReadThrough(enable)
+	}
+}
+
+// KeyLoadState 主键在所在分片中的导入状态 EGlobalLoadState*
+func (s *ShardedManager[T]) KeyLoadState(pk ...any) int32 {
+	g := s.shardOfPk(pk)
+	if g == nil {
+		return EGlobalLoadStateDisk
+	}
+	return g.KeyLoadState(pk...)
+}
+
+// LoadKey 从主键所在的分片导入数据, 规则同 GlobalManager.LoadKey
+func (s *ShardedManager[T]) LoadKey(pk ...any) error {
+	g := s.shardOfPk(pk)
+	if g == nil {
+		return fmt.Errorf("%w: %d primary keys, want %d", EPersistErrorInvalidData, len(pk), len(s.shards[0].meta.PkIndex))
+	}
+	return g.LoadKey(pk...)
+}
+
+// UnloadKey 从主键所在的分片导出数据, 规则同 GlobalManager.UnloadKey
+func (s *ShardedManager[T]) UnloadKey(pk ...any) error {
+	g := s.shardOfPk(pk)
+	if g == nil {
+		return fmt.Errorf("%w: %d primary keys, want %d", EPersistErrorInvalidData, len(pk), len(s.shards[0].meta.PkIndex))
+	}
+	return g.UnloadKey(pk...)
+}
+
+// uidPk 按用户导入导出的主键, 只在用户ID是唯一主键时可用
+func (s *ShardedManager[T]) uidPk(uid int32) ([]any, error) {
+	if len(s.shards[0].meta.PkIndex) != 1 {
+		return nil, fmt.Errorf("%w: %s load by uid needs a single primary key", EPersistErrorInvalidConfig, s.name)
+	}
+	return []any{uid}, nil
+}
+
+// Load 导入用户UID的数据, 按 uid 路由到分片, 用户ID是唯一主键时可用, 需要开启按主键导入
+// 注册表的 Load 不会调用, 需要直接调用; 空闲导出时在 IdleConfig.Unload 中调用 Unload
+func (s *ShardedManager[T]) Load(uid int32) error {
+	pk, err := s.uidPk(uid)
+	if err != nil {
+		return err
+	}
+	return s.LoadKey(pk...)
+}
+
+// Unload 导出用户UID的数据, 按 uid 路由到分片, 用户ID是唯一主键时可用
+func (s *ShardedManager[T]) Unload(uid int32) error {
+	pk, err := s.uidPk(uid)
+	if err != nil {
+		return err
+	}
+	return s.UnloadKey(pk...)
+}
+
+// LoadState 用户UID的数据在所在分片中的导入状态 EGlobalLoadState*
+func (s *ShardedManager[T]) LoadState(uid int32) int32 {
+	pk, err := s.uidPk(uid)
+	if err != nil {
+		return EGlobalLoadStateDisk
+	}
+	return s.KeyLoadState(pk...)
+}
+
+// Range 按分片顺序遍历内存数据副本, fn 返回false时停止
+func (s *ShardedManager[T]) Range(fn func(cls *T) bool) {
+	for _, g := range s.shards {
+		stop := false
+		g.Range(func(cls *T) bool {
+			stop = !fn(cls)
+			return !stop
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// LoadAll 所有分片全导入, 返回各分片的错误
+func (s *ShardedManager[T]) LoadAll() error {
+	return s.each(func(_ int, g *GlobalManager[T]) error { return g.LoadAll() })
+}
+
+// each 并行执行所有分片, 一个分片失败不影响其它分片, 返回合并的错误
+func (s *ShardedManager[T]) each(fn func(i int, g *GlobalManager[T]) error) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, g := range s.shards {
+		wg.Go(func() {
+			if err := fn(i, g); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", g.name, err)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Sync 创建所有分片的表
+func (s *ShardedManager[T]) Sync(wg *sync.WaitGroup) error {
+	return s.each(func(_ int, g *GlobalManager[T]) error { return g.Sync(wg) })
+}
+
+// Exit 退出所有分片
+func (s *ShardedManager[T]) Exit(wg *sync.WaitGroup) {
+	_ = s.each(func(_ int, g *GlobalManager[T]) error {
+		g.Exit(wg)
+		return nil
+	})
+}
+
+// Run 启动所有分片, 启动失败的分片不影响其它分片, 返回合并的错误
+func (s *ShardedManager[T]) Run() error {
+	return s.each(func(_ int, g *GlobalManager[T]) error { return g.Run() })
+}
+
+// Dead 任一分片不可用
+func (s *ShardedManager[T]) Dead() bool {
+	for _, g := range s.shards {
+		if g.Dead() {
+			return true
+		}
+	}
+	return false
+}
+
+// PersistName 获取结构名
+func (s *ShardedManager[T]) PersistName() string {
+	return s.name
+}
+
+// RecoverBomb 按bomb文件头中的分片名恢复到对应分片
+func (s *ShardedManager[T]) RecoverBomb(bomb []byte) error {
+	header, _, err := DecodeBomb(bomb)
+	if err != nil {
+		return err
+	}
+	g := s.ShardByName(header.Name)
+	if g == nil {
+		return fmt.Errorf("%w: persist %s, want shard of %s", EPersistErrorInvalidBombFile, header.Name, s.name)
+	}
+	return g.RecoverBomb(bomb)
+}
+
+// SyncData 所有分片写回失败队列
+func (s *ShardedManager[T]) SyncData(wg *sync.WaitGroup, sentryDebug bool) error {
+	return s.each(func(_ int, g *GlobalManager[T]) error { return g.SyncData(wg, sentryDebug) })
+}
+
+// RecoverTrace 按数据所在的分片恢复 trace 数据, 同一分片保持顺序
+func (s *ShardedManager[T]) RecoverTrace(trace [][]byte) error {
+	queue, err := s.shards[0].BytesToPersistSyncQueue(trace)
+	if err != nil {
+		return err
+	}
+	records := make([][][]byte, len(s.shards))
+	for i, persistSync := range queue {
+		idx := s.ShardIndex(s.shards[0].pkValues(persistSync.Data)[s.field])
+		records[idx] = append(records[idx], trace[i])
+	}
+	return s.each(func(i int, g *GlobalManager[T]) error {
+		if len(records[i]) == 0 {
+			return nil
+		}
+		return g.RecoverTrace(records[i])
+	})
+}
+
+// StringToPersistSyncInterface base64数据转化为 *GlobalSync[T], 失败返回 nil
+func (s *ShardedManager[T]) StringToPersistSyncInterface(data string) any {
+	return s.shards[0].StringToPersistSyncInterface(data)
+}
+
+// BytesToPersistInterface bytes转化为persist
+func (s *ShardedManager[T]) BytesToPersistInterface(data []byte) any {
+	return s.shards[0].BytesToPersistInterface(data)
+}
+
+// PersistInterfaceToBytes persist转化为bytes
+func (s *ShardedManager[T]) PersistInterfaceToBytes(i any) []byte {
+	return s.shards[0].PersistInterfaceToBytes(i)
+}
+
+// PersistInterfaceToPkStruct persist转化为主键
+func (s *ShardedManager[T]) PersistInterfaceToPkStruct(i any) any {
+	return s.shards[0].PersistInterfaceToPkStruct(i)
+}
+
+// LazyInit 惰性注册初始化, 未指定数据库连接的分片使用默认连接
+func (s *ShardedManager[T]) LazyInit() error {
+	for i, g := range s.shards {
+		if g.engine == nil {
+			engine := GetDatabaseDB()
+			if engine == nil {
+				return EPersistErrorEngineNil
+			}
+			g.engine = engine
+			if s.config.Tables {
+				g.SetTable(fmt.Sprintf("%s_%d", engine.TableName(new(T)), i))
+			}
+		}
+		g.initFields()
+	}
+	RegisterPersist(s)
+	return nil
+}
+
+// Segmentation 所有分片按时间分表
+func (s *ShardedManager[T]) Segmentation(wg *sync.WaitGroup) error {
+	return s.each(func(_ int, g *GlobalManager[T]) error { return g.Segmentation(wg) })
+}
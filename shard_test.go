@@ -0,0 +1,212 @@
+package persist_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+type ShardGlobal struct {
+	Uid    int64 `xorm:"pk"`
+	ItemId int64 `xorm:"pk"`
+	Count  int   `xorm:""`
+}
+
+// TestShardedManager_Config 测试分片配置校验和默认分片函数.
+func TestShardedManager_Config(t *testing.T) {
+	if _, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("NewShardedManager() zero shards error = %v", err)
+	}
+	if _, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 2, Field: "Count", Tables: true}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("NewShardedManager() non pk field error = %v", err)
+	}
+	if _, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 2, Engines: []*xorm.Engine{nil}}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("NewShardedManager() shared table error = %v", err)
+	}
+	s, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 4, Field: "ItemId", Tables: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Shard(&ShardGlobal{Uid: 1, ItemId: 7}); got != s.Shards()[3] {
+		t.Errorf("Shard() = %s, want shard 3", got.PersistName())
+	}
+	if got := s.ShardIndex("uid"); got != s.ShardIndex("uid") || got < 0 || got >= 4 {
+		t.Errorf("ShardIndex(string) = %d", got)
+	}
+	if s.ShardByName("ShardGlobal_2") != s.Shards()[2] || s.ShardByName("ShardGlobal") != nil {
+		t.Error("ShardByName() mismatch")
+	}
+
+	custom, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 3, Tables: true, Shard: func(key any) int { return -int(key.(int64)) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := custom.ShardIndex(int64(1)); got != 2 {
+		t.Errorf("ShardIndex() custom = %d, want 2", got)
+	}
+}
+
+// TestShardedManager_Tables 测试分片写入同一数据库的不同表, 按 uid 路由新建, 修改和删除.
+func TestShardedManager_Tables(t *testing.T) {
+	_, engine := newTestManager(t)
+	s, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 3, Engines: []*xorm.Engine{engine}, Tables: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for uid := int64(0); uid < 9; uid++ {
+		if err = s.Insert(&ShardGlobal{Uid: uid, ItemId: 1, Count: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row, ok := s.Get(int64(4), int64(1))
+	if !ok {
+		t.Fatal("Get() ok = false")
+	}
+	row.Count = 5
+	if err = s.Update(row, persist.GlobalBitSet[ShardGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete(int64(8), int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete(int64(8)); !errors.Is(err, persist.EPersistErrorNotInMemory) {
+		t.Errorf("Delete() partial pk error = %v", err)
+	}
+	s.Exit(&sync.WaitGroup{})
+
+	base := engine.TableName(new(ShardGlobal))
+	for i, want := range []int64{3, 3, 2} {
+		table := fmt.Sprintf("%s_%d", base, i)
+		n, err := engine.Table(table).Count(new(ShardGlobal))
+		if err != nil || n != want {
+			t.Errorf("%s rows = %d, %v, want %d", table, n, err, want)
+		}
+	}
+	got := new(ShardGlobal)
+	if has, err := engine.Table(base + "_1").ID([]any{int64(4), int64(1)}).Get(got); err != nil || !has || got.Count != 5 {
+		t.Errorf("updated row = %+v, %v, %v", got, has, err)
+	}
+}
+
+// TestShardedManager_Isolation 测试分片写入不同数据库, 一个分片写回失败只保存该分片的bomb文件, 不影响其它分片.
+func TestShardedManager_Isolation(t *testing.T) {
+	_, good := newTestManager(t)
+	bad, err := xorm.NewEngine("sqlite", filepath.Join(t.TempDir(), "bad.db")+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = bad.Close() })
+
+	s, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 2, Engines: []*xorm.Engine{good, bad}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range s.Shards() {
+		g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Millisecond, MaxInterval: 20 * time.Millisecond})
+	}
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err = bad.DropTables(new(ShardGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	for uid := int64(0); uid < 6; uid++ {
+		if err = s.Insert(&ShardGlobal{Uid: uid, ItemId: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		n, _ := good.Count(new(ShardGlobal))
+		return n == 3
+	})
+	s.Exit(&sync.WaitGroup{})
+
+	if persist.DirExists(persist.BombFilePath("ShardGlobal_0")) {
+		t.Error("good shard left a bomb file")
+	}
+	if !persist.DirExists(persist.BombFilePath("ShardGlobal_1")) {
+		t.Fatal("bad shard bomb file missing")
+	}
+	if got := len(s.Shards()[1].FailQueue); got != 3 {
+		t.Errorf("bad shard FailQueue = %d, want 3", got)
+	}
+}
+
+type ShardUserGlobal struct {
+	Uid  int64 `xorm:"pk"`
+	Gold int   `xorm:""`
+}
+
+// TestShardedManager_LoadKey 测试按主键和按用户导入导出路由到所在分片.
+func TestShardedManager_LoadKey(t *testing.T) {
+	_, engine := newTestManager(t)
+	s, err := persist.NewShardedManager[ShardUserGlobal](persist.GlobalShardConfig{Shards: 3, Engines: []*xorm.Engine{engine}, Tables: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	base := engine.TableName(new(ShardUserGlobal))
+	for uid := int64(0); uid < 6; uid++ {
+		if _, err = engine.Table(fmt.Sprintf("%s_%d", base, uid%3)).Insert(&ShardUserGlobal{Uid: uid, Gold: int(uid)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetReadThrough(true)
+	if err = s.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Load(4); err != nil {
+		t.Fatal(err)
+	}
+	if s.LoadState(4) != persist.EGlobalLoadStateMemory || s.Shards()[1].KeyLoadState(int64(4)) != persist.EGlobalLoadStateMemory {
+		t.Errorf("LoadState(4) = %d", s.LoadState(4))
+	}
+	if s.Shards()[0].KeyLoadState(int64(4)) != persist.EGlobalLoadStateDisk {
+		t.Error("uid 4 loaded into shard 0")
+	}
+	if row, ok := s.Get(int64(4)); !ok || row.Gold != 4 {
+		t.Errorf("Get(4) = %+v, %v", row, ok)
+	}
+	if err = s.LoadKey(int64(5)); err != nil || s.Shards()[2].KeyLoadState(int64(5)) != persist.EGlobalLoadStateMemory {
+		t.Errorf("LoadKey(5) error = %v, state = %d", err, s.Shards()[2].KeyLoadState(int64(5)))
+	}
+	if err = s.LoadKey(int64(1), int64(2)); !errors.Is(err, persist.EPersistErrorInvalidData) {
+		t.Errorf("LoadKey() wrong pk count error = %v", err)
+	}
+
+	if err = s.Unload(4); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return s.LoadState(4) == persist.EGlobalLoadStateDisk })
+	if err = s.UnloadKey(int64(5)); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return s.KeyLoadState(int64(5)) == persist.EGlobalLoadStateDisk })
+	s.Exit(&sync.WaitGroup{})
+
+	items, err := persist.NewShardedManager[ShardGlobal](persist.GlobalShardConfig{Shards: 2, Tables: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = items.Load(1); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("Load() composite pk error = %v", err)
+	}
+}
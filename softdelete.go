@@ -0,0 +1,141 @@
+package persist
+
+import (
+	"fmt"
+	"time"
+
+	"xorm.io/xorm/dialects"
+	"xorm.io/xorm/schemas"
+)
+
+const eGlobalPurgeInterval = time.Minute // 默认清理间隔
+const eGlobalZeroTime = "0001-01-01 00:00:00"
+
+// GlobalPurgeConfig 软删除清理配置, 有 xorm deleted 字段的表删除时只标记删除时间,
+// 标记删除超过保留时间的行由后台协程物理删除
+type GlobalPurgeConfig struct {
+	Retention time.Duration // 标记删除后的保留时间, 为0时不清理
+	Interval  time.Duration // 清理间隔, 为0时按 eGlobalPurgeInterval
+}
+
+// SetPurge 设置软删除清理, 应在 Run 之前调用
+func (g *GlobalManager[T]) SetPurge(config GlobalPurgeConfig) {
+	g.purge = config
+}
+
+// softDelete 是否有软删除字段, 删除时按 xorm 标记删除时间, 新建时覆盖已标记删除的行
+func (g *GlobalManager[T]) softDelete() bool {
+	return g.meta.Deleted >= 0
+}
+
+// deletedColumn 软删除列, 没有时返回 nil
+func (g *GlobalManager[T]) deletedColumn() (*schemas.Column, error) {
+	if !g.softDelete() {
+		return nil, nil
+	}
+	table, err := g.engine.TableInfo(new(T))
+	if err != nil {
+		return nil, err
+	}
+	return table.DeletedColumn(), nil
+}
+
+// liveDeletedValue 未删除行的软删除列的值, 与 xorm 查询时过滤已删除行的条件一致
+func (g *GlobalManager[T]) liveDeletedValue(col *schemas.Column) any {
+	switch {
+	case col.SQLType.IsNumeric():
+		return 0
+	case col.SQLType.Name == schemas.TimeStamp || col.SQLType.Name == schemas.TimeStampz:
+		tz := g.engine.GetTZDatabase()
+		if col.TimeZone != nil {
+			tz = col.TimeZone
+		}
+		return time.Unix(0, 0).In(tz).Format("2006-01-02 15:04:05.999999999")
+	}
+	return eGlobalZeroTime
+}
+
+// Purge 物理删除标记删除超过保留时间的行, 分表时清理已创建的分表, 返回删除的行数
+func (g *GlobalManager[T]) Purge() (int64, error) {
+	if g.engine == nil {
+		return 0, EPersistErrorEngineNil
+	}
+	if g.purge.Retention <= 0 {
+		return 0, nil
+	}
+	col, err := g.deletedColumn()
+	if err != nil || col == nil {
+		return 0, err
+	}
+	cutoff, err := dialects.FormatColumnTime(g.engine.Dialect(), g.engine.GetTZDatabase(), col, time.Now().Add(-g.purge.Retention))
+	if err != nil {
+		return 0, err
+	}
+
+	tables := []string{g.baseTable()}
+	if g.segment != nil {
+		tables = g.SegmentTables()
+	}
+
+	session := g.engine.NewSession()
+	defer session.Close()
+	name := g.engine.Quote(col.Name)
+	var total int64
+	for _, table := range tables {
+		// 未删除的行是零值, 排除后按删除时间比较; NULL 与任何值比较都不成立
+		affected, err := session.Table(table).Unscoped().Where(name+" < ?", cutoff).
+			And(name+" <> ?", g.liveDeletedValue(col)).Delete(new(T))
+		if err != nil {
+			g.logError("purge "+table, err, nil)
+			return total, err
+		}
+		total += affected
+	}
+	return total, nil
+}
+
+// startPurge 启动清理协程, 未配置保留时间或没有软删除字段时不启动
+func (g *GlobalManager[T]) startPurge() {
+	if g.purge.Retention <= 0 || !g.softDelete() {
+		return
+	}
+	interval := g.purge.Interval
+	if interval <= 0 {
+		interval = eGlobalPurgeInterval
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	g.purgeStop, g.purgeDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				g.purgeOnce()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// purgeOnce 执行一次清理, 异常不影响后续清理
+func (g *GlobalManager[T]) purgeOnce() {
+	defer func() {
+		if r := recover(); r != nil {
+			g.logError("purge", fmt.Errorf("%w: %v", EPersistErrorUnknownError, r), nil)
+		}
+	}()
+	_, _ = g.Purge()
+}
+
+// stopPurge 停止清理协程, 等待正在进行的清理结束
+func (g *GlobalManager[T]) stopPurge() {
+	if g.purgeStop == nil {
+		return
+	}
+	close(g.purgeStop)
+	<-g.purgeDone
+	g.purgeStop, g.purgeDone = nil, nil
+}
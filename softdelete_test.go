@@ -0,0 +1,150 @@
+package persist_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+type SoftGlobal struct {
+	Id        int64     `xorm:"pk"`
+	Name      string    `xorm:""`
+	DeletedAt time.Time `xorm:"deleted"`
+}
+
+// newSoftManager 创建有软删除字段的管理器
+func newSoftManager(t *testing.T) (*persist.GlobalManager[SoftGlobal], *xorm.Engine) {
+	t.Helper()
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[SoftGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	return g, engine
+}
+
+// softRows 未删除和全部行数
+func softRows(t *testing.T, engine *xorm.Engine) (live, all int64) {
+	t.Helper()
+	live, err := engine.Count(new(SoftGlobal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all, err = engine.Unscoped().Count(new(SoftGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	return live, all
+}
+
+// TestGlobalManager_SoftDelete 测试软删除: 删除后从内存移除并标记删除, 之后新建同一主键恢复已删除的行.
+func TestGlobalManager_SoftDelete(t *testing.T) {
+	g, engine := newSoftManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Millisecond, MaxInterval: 20 * time.Millisecond})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	for id := int64(1); id <= 2; id++ {
+		if err := g.Insert(&SoftGlobal{Id: id, Name: "old"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { live, _ := softRows(t, engine); return live == 2 })
+
+	if err := g.Delete(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.Get(int64(1)); ok {
+		t.Error("Get() deleted row ok = true")
+	}
+	waitFor(t, func() bool { live, all := softRows(t, engine); return live == 1 && all == 2 })
+	tomb := new(SoftGlobal)
+	if has, err := engine.Unscoped().ID(1).Get(tomb); err != nil || !has || tomb.DeletedAt.IsZero() {
+		t.Fatalf("tombstone = %+v, %v, %v", tomb, has, err)
+	}
+
+	// 已写回的删除之后新建, 覆盖已标记删除的行
+	if err := g.Insert(&SoftGlobal{Id: 1, Name: "revived"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		got := new(SoftGlobal)
+		has, _ := engine.ID(1).Get(got)
+		return has && got.Name == "revived"
+	})
+	if live, all := softRows(t, engine); live != 2 || all != 2 {
+		t.Errorf("rows = %d/%d, want 2/2", live, all)
+	}
+}
+
+// TestGlobalManager_SoftDeleteReplace 测试同一轮写回中的删除和新建合并后恢复已删除的行.
+func TestGlobalManager_SoftDeleteReplace(t *testing.T) {
+	g, engine := newSoftManager(t)
+	if _, err := engine.Insert(&SoftGlobal{Id: 1, Name: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Hour, MaxInterval: time.Hour})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&SoftGlobal{Id: 1, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	got := new(SoftGlobal)
+	if has, err := engine.ID(1).Get(got); err != nil || !has || got.Name != "new" {
+		t.Errorf("row = %+v, %v, %v", got, has, err)
+	}
+	if live, all := softRows(t, engine); live != 1 || all != 1 {
+		t.Errorf("rows = %d/%d, want 1/1", live, all)
+	}
+}
+
+// TestGlobalManager_Purge 测试物理删除超过保留时间的已删除行, 未删除和保留时间内的行不受影响.
+func TestGlobalManager_Purge(t *testing.T) {
+	g, engine := newSoftManager(t)
+	for id := int64(1); id <= 3; id++ {
+		if _, err := engine.Insert(&SoftGlobal{Id: id, Name: "row"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := int64(1); id <= 2; id++ {
+		if _, err := engine.ID(id).Delete(new(SoftGlobal)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expired := &SoftGlobal{DeletedAt: time.Now().Add(-2 * time.Hour)}
+	if _, err := engine.Unscoped().ID(2).Cols("deleted_at").Update(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	g.SetPurge(persist.GlobalPurgeConfig{Retention: time.Hour})
+	if n, err := g.Purge(); err != nil || n != 1 {
+		t.Fatalf("Purge() = %d, %v, want 1", n, err)
+	}
+	if live, all := softRows(t, engine); live != 1 || all != 2 {
+		t.Errorf("rows = %d/%d, want 1/2", live, all)
+	}
+
+	// 后台清理
+	g.SetPurge(persist.GlobalPurgeConfig{Retention: time.Millisecond, Interval: 5 * time.Millisecond})
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { _, all := softRows(t, engine); return all == 1 })
+	g.Exit(&sync.WaitGroup{})
+	if live, _ := softRows(t, engine); live != 1 {
+		t.Errorf("live rows = %d, want 1", live)
+	}
+}
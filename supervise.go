@@ -0,0 +1,252 @@
+package persist
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// GlobalRestartConfig 收集或写回协程崩溃后的自动重启配置
+type GlobalRestartConfig struct {
+	MaxRestarts int           // Run 之后自动重启的次数上限, 为0时不自动重启, 保持崩溃状态直到再次 Run
+	Backoff     time.Duration // 第一次重启前的等待时间, 之后每次翻倍
+	MaxBackoff  time.Duration // 重启等待时间上限, 为0时不限制
+}
+
+// DefaultRestartConfig 默认自动重启配置
+var DefaultRestartConfig = GlobalRestartConfig{
+	MaxRestarts: 5,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  30 * time.Second,
+}
+
+// backoff 第 attempt 次重启前的等待时间
+func (c *GlobalRestartConfig) backoff(attempt int) time.Duration {
+	wait := c.Backoff << min(attempt, 30)
+	if wait < 0 || (c.MaxBackoff > 0 && wait > c.MaxBackoff) {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// GlobalPanic 收集或写回协程的崩溃信息
+type GlobalPanic struct {
+	Goroutine string    // 崩溃的协程, collect 或 save
+	Err       error     // 恢复的错误
+	Stack     string    // 崩溃时的调用栈
+	At        time.Time // 崩溃时间
+}
+
+// SetRestart 设置自动重启配置, 应在 Run 之前调用
+func (g *GlobalManager[T]) SetRestart(config GlobalRestartConfig) error {
+	if config.MaxRestarts < 0 || config.Backoff < 0 || config.MaxBackoff < 0 {
+		return fmt.Errorf("%w: max restarts %d, backoff %v, max backoff %v",
+			EPersistErrorInvalidConfig, config.MaxRestarts, config.Backoff, config.MaxBackoff)
+	}
+	g.restart = config
+	return nil
+}
+
+// LastPanic 最近一次崩溃信息, 没有崩溃过时为 nil
+func (g *GlobalManager[T]) LastPanic() *GlobalPanic {
+	return g.lastPanic.Load()
+}
+
+// Restarts 崩溃后自动重启成功的次数
+func (g *GlobalManager[T]) Restarts() int64 {
+	return g.restarts.Load()
+}
+
+// supervise 运行收集协程, 收集或写回协程崩溃时保存队列并切换为崩溃状态, 按退避时间自动重启
+func (g *GlobalManager[T]) supervise() {
+	generation := g.generation.Add(1)
+	attempt := 0
+	for {
+		p := g.runCollect()
+		if p == nil || !g.crashed(p) {
+			return
+		}
+		for {
+			if attempt >= g.restart.MaxRestarts {
+				g.logError("restart", fmt.Errorf("%w: stopped after %d restarts", EPersistErrorPanic, attempt), nil)
+				return
+			}
+			time.Sleep(g.restart.backoff(attempt))
+			attempt++
+			restarted, err := g.restartFromPanic(generation)
+			if !restarted {
+				// 已经手动 Run 或 Exit
+				return
+			}
+			if err == nil {
+				break
+			}
+		}
+	}
+}
+
+// runCollect 运行收集协程直到退出, 崩溃时停止写回协程并返回崩溃信息
+func (g *GlobalManager[T]) runCollect() (p *GlobalPanic) {
+	g.panicked, g.saving, g.saveRunning, g.stopSaving, g.exiting = nil, false, false, false, false
+	defer func() {
+		if r := recover(); r != nil {
+			p = g.newPanic("collect", r)
+			g.stopSave()
+		}
+	}()
+	g.Collect()
+	return g.panicked
+}
+
+// newPanic 记录恢复的崩溃, 通过 ReturnError 输出错误和调用栈
+func (g *GlobalManager[T]) newPanic(goroutine string, r any) *GlobalPanic {
+	err := fmt.Errorf("%w: %s %s: %v", EPersistErrorPanic, g.name, goroutine, r)
+	msg := &Error{Err: err, Type: ErrorTypeState}
+	msg.SetMeta(H{"persist": g.name, "goroutine": goroutine})
+	ReturnError(log.New(DefaultErrorWriter, "\n\n\x1b[31m", log.LstdFlags), msg)
+	return &GlobalPanic{Goroutine: goroutine, Err: err, Stack: string(stack(3)), At: time.Now()}
+}
+
+// takeCrash 取出写回协程的崩溃信息, 写回协程崩溃后已退出
+func (g *GlobalManager[T]) takeCrash() *GlobalPanic {
+	select {
+	case p := <-g.crash:
+		g.saveRunning = false
+		return p
+	default:
+		return nil
+	}
+}
+
+// recordPanic 记录最近一次崩溃, 在健康状态中报告
+func (g *GlobalManager[T]) recordPanic(p *GlobalPanic) {
+	g.lastPanic.Store(p)
+	g.recordError(p.Err)
+}
+
+// stopSave 收集协程崩溃后等待进行中的一轮写回结束, 停止写回协程
+func (g *GlobalManager[T]) stopSave() {
+	if !g.saveRunning {
+		return
+	}
+	if g.saving {
+		<-g.syncEnd
+		g.saving = false
+		if g.takeCrash() != nil {
+			return
+		}
+	}
+	g.stopSaving = true
+	g.syncBegin <- false
+	<-g.syncEnd
+	g.saveRunning = false
+}
+
+// crashed 切换为崩溃状态, 等待正在进行的修改结束后将未写回的修改保存到bomb文件, 可以自动重启时返回true
+// 退出过程中崩溃时完成退出
+func (g *GlobalManager[T]) crashed(p *GlobalPanic) bool {
+	g.restartMu.Lock()
+	defer g.restartMu.Unlock()
+	running := atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStateNormal, EGlobalManagerStatePanic)
+
+	// 已发送的修改在持有 opMu 时进入通道, 取得 opMu 后不再有新的修改
+	locked := make(chan struct{})
+	go func() {
+		g.opMu.Lock()
+		close(locked)
+	}()
+	for waiting := true; waiting; {
+		select {
+		case persistSync := <-g.syncChan:
+			g.appendCache(persistSync)
+		case <-locked:
+			waiting = false
+		}
+	}
+	for len(g.syncChan) > 0 {
+		g.appendCache(<-g.syncChan)
+	}
+	g.opMu.Unlock()
+
+	err := g.dumpQueues()
+	g.pending.Store(int64(len(*g.cacheQueue)) + g.failNum.Load())
+	g.recordPanic(p)
+	// 唤醒阻塞的修改, 崩溃状态下修改直接返回错误
+	g.setOverloaded(false)
+	if !running {
+		if !g.exiting {
+			<-g.exitBegin
+		}
+		g.exitEnd <- true
+		return false
+	}
+	if err != nil {
+		g.logError("panic dump", err, nil)
+		return false
+	}
+	return true
+}
+
+// dumpQueues 未写回的修改按顺序保存到bomb文件, 未读取的溢出文件之后的修改写入新的溢出文件
+// 保存失败时修改保留在内存中
+func (g *GlobalManager[T]) dumpQueues() error {
+	g.DataToFailQueue()
+	if len(g.spillSeqs) > 0 {
+		if err := g.spillCache(); err != nil {
+			return err
+		}
+	} else {
+		for _, persistSync := range *g.cacheQueue {
+			if persistSync.Op == EGlobalOpUnload {
+				g.cancelUnload(persistSync)
+				continue
+			}
+			g.FailQueue = append(g.FailQueue, persistSync)
+		}
+		*g.cacheQueue = (*g.cacheQueue)[0:0]
+		clear(g.cacheIndex)
+	}
+	g.batchNum = 0
+	g.failNum.Store(int64(len(g.FailQueue)))
+	if len(g.FailQueue) == 0 {
+		g.removeSpilled()
+		return nil
+	}
+	if err := g.SaveFile(); err != nil {
+		return err
+	}
+	g.FailQueue = g.FailQueue[0:0]
+	g.failNum.Store(0)
+	g.removeSpilled()
+	return nil
+}
+
+// exitPanic 崩溃状态时退出, 停止自动重启; 等待的自动重启已完成时返回true, 按运行状态退出
+func (g *GlobalManager[T]) exitPanic() (retry bool) {
+	g.restartMu.Lock()
+	defer g.restartMu.Unlock()
+	if atomic.CompareAndSwapInt32(&g.managerState, EGlobalManagerStatePanic, EGlobalManagerStateIdle) {
+		g.stopPurge()
+		g.stopEvict()
+		return false
+	}
+	return atomic.LoadInt32(&g.managerState) == EGlobalManagerStateNormal
+}
+
+// restartFromPanic 从崩溃状态重启, 先写回bomb文件; 状态已被 Run 或 Exit 改变时 restarted 为false
+func (g *GlobalManager[T]) restartFromPanic(generation int64) (restarted bool, err error) {
+	g.restartMu.Lock()
+	defer g.restartMu.Unlock()
+	if g.generation.Load() != generation || atomic.LoadInt32(&g.managerState) != EGlobalManagerStatePanic {
+		return false, nil
+	}
+	if err = g.LoadFile(); err != nil {
+		g.logError("restart", err, nil)
+		return true, err
+	}
+	g.scanSpill()
+	g.restarts.Add(1)
+	atomic.StoreInt32(&g.managerState, EGlobalManagerStateNormal)
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package persist_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+// panicOnce 第一次进入过载时崩溃的回调
+func panicOnce() func(string, bool) {
+	var panicked atomic.Bool
+	return func(_ string, overloaded bool) {
+		if overloaded && panicked.CompareAndSwap(false, true) {
+			panic("overload callback")
+		}
+	}
+}
+
+// TestGlobalManager_PanicRestart 测试收集协程崩溃时保存bomb文件并切换为崩溃状态, 数据库恢复后自动重启写回.
+func TestGlobalManager_PanicRestart(t *testing.T) {
+	g, repair := newOverloadManager(t, persist.GlobalOverloadConfig{MaxQueue: 2, OnOverload: panicOnce()})
+	for id := int64(1); id <= 2; id++ {
+		if err := g.Insert(&ManagerGlobal{AuthId: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return g.LastPanic() != nil })
+	if p := g.LastPanic(); p.Goroutine != "collect" || !errors.Is(p.Err, persist.EPersistErrorPanic) || p.Stack == "" {
+		t.Errorf("LastPanic() = %+v", p)
+	}
+	h := g.Health()
+	if h.State != persist.EHealthStateDown || !strings.Contains(h.Reason, "overload callback") || h.Panic == "" || !h.BombFile {
+		t.Errorf("Health() after panic = %+v", h)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 3}); !errors.Is(err, persist.EPersistErrorIncorrectState) {
+		t.Errorf("Insert() after panic error = %v", err)
+	}
+
+	repair()
+	waitFor(t, func() bool { return !g.Dead() })
+	if g.Restarts() != 1 {
+		t.Errorf("Restarts() = %d, want 1", g.Restarts())
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 3}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+	if h = g.Health(); h.BombFile || h.Restarts != 1 {
+		t.Errorf("Health() after Exit = %+v", h)
+	}
+	n := 0
+	g.Range(func(*ManagerGlobal) bool { n++; return true })
+	if n != 3 {
+		t.Errorf("rows = %d, want 3", n)
+	}
+}
+
+// TestGlobalManager_PanicRestartLimit 测试重启次数用完后保持崩溃状态, 手动 Run 后恢复写回.
+func TestGlobalManager_PanicRestartLimit(t *testing.T) {
+	g, engine := newTestManager(t)
+	if err := g.SetRestart(persist.GlobalRestartConfig{MaxRestarts: -1}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("SetRestart() negative error = %v", err)
+	}
+	if err := g.SetRestart(persist.GlobalRestartConfig{MaxRestarts: 1, Backoff: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetOverload(persist.GlobalOverloadConfig{MaxQueue: 1, OnOverload: panicOnce()})
+	g.SetFlush(persist.GlobalFlushConfig{MaxInterval: 20 * time.Millisecond})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.LastPanic() != nil })
+	time.Sleep(50 * time.Millisecond)
+	if !g.Dead() || g.Restarts() != 0 {
+		t.Errorf("Dead() = %v, Restarts() = %d after failed restart", g.Dead(), g.Restarts())
+	}
+
+	if err := engine.Sync(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if g.Dead() {
+		t.Error("Dead() = true after Run")
+	}
+	if n := countRows(t, engine); n != 1 {
+		t.Errorf("db rows = %d, want 1", n)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
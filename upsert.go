@@ -0,0 +1,211 @@
+package persist
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/convert"
+	"xorm.io/xorm/dialects"
+	"xorm.io/xorm/schemas"
+)
+
+// SetUpsertReplay 重放bomb文件, trace和失败队列时新建是否使用 Upsert, 默认开启, 应在 Run 之前调用
+// 写回提交后删除bomb文件前崩溃, 重启时重放已经写入的新建不会因主键重复失败
+func (g *GlobalManager[T]) SetUpsertReplay(enable bool) {
+	g.upsertReplay = enable
+}
+
+// ReplayDB 重放写回记录, 重复执行结果相同: 新建按 Upsert 写入, 删除不存在的行和修改都不会失败
+func (g *GlobalManager[T]) ReplayDB(session *xorm.Session, persistSync *GlobalSync[T]) (err error) {
+	if persistSync.Op != EGlobalOpInsert || !g.upsertReplay {
+		return g.SaveDB(session, persistSync)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if err == nil {
+				err = fmt.Errorf("%w: %v", EPersistErrorUnknownError, r)
+			}
+		}
+	}()
+	if err = g.Upsert(session, persistSync.Data); err != nil {
+		g.logError("upsert", err, persistSync)
+	}
+	return
+}
+
+// Upsert 新建数据, 主键已存在时覆盖所有列, 已标记删除的行恢复为未删除
+// MySQL 使用 ON DUPLICATE KEY UPDATE, PostgreSQL 和 SQLite 使用 ON CONFLICT, 其它数据库先查询是否存在再新建或修改
+func (g *GlobalManager[T]) Upsert(session *xorm.Session, cls *T) (err error) {
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	if err = g.ensureSegment(cls); err != nil {
+		return err
+	}
+	dbType := g.engine.Dialect().URI().DBType
+	if dbType != schemas.MYSQL && dbType != schemas.POSTGRES && dbType != schemas.SQLITE {
+		pk := schemas.PK(g.pkValues(cls))
+		// 已标记删除的行同样覆盖
+		has, err := g.on(session, cls).Unscoped().ID(pk).Exist(new(T))
+		if err != nil {
+			return err
+		}
+		if has {
+			_, err = g.on(session, cls).Unscoped().ID(pk).AllCols().Update(cls)
+		} else {
+			_, err = g.on(session, cls).Insert(cls)
+		}
+		return err
+	}
+
+	table, err := g.engine.TableInfo(cls)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(cls).Elem()
+	columns := make([]string, 0, len(table.Columns()))
+	updates := make([]string, 0, len(table.Columns()))
+	args := make([]any, 0, len(table.Columns()))
+	for _, col := range table.Columns() {
+		if col.MapType == schemas.ONLYFROMDB {
+			continue
+		}
+		field, err := col.ValueOfV(&v)
+		if err != nil {
+			return err
+		}
+		arg, err := g.columnValue(col, *field)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+		if col.IsDeleted {
+			// 新建的行总是未删除
+			arg = nil
+			if !col.Nullable {
+				arg = g.liveDeletedValue(col)
+			}
+		}
+		name := g.engine.Quote(col.Name)
+		columns = append(columns, name)
+		args = append(args, arg)
+		if col.IsPrimaryKey {
+			continue
+		}
+		if dbType == schemas.MYSQL {
+			updates = append(updates, name+" = VALUES("+name+")")
+		} else {
+			updates = append(updates, name+" = excluded."+name)
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString("INSERT INTO ")
+	builder.WriteString(g.engine.Quote(g.tableName(cls)))
+	builder.WriteString(" (")
+	builder.WriteString(strings.Join(columns, ", "))
+	builder.WriteString(") VALUES (")
+	builder.WriteString(strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+	builder.WriteString(")")
+	if dbType == schemas.MYSQL {
+		if len(updates) == 0 {
+			// 只有主键列时保持原值
+			pk := g.engine.Quote(table.PrimaryKeys[0])
+			updates = append(updates, pk+" = "+pk)
+		}
+		builder.WriteString(" ON DUPLICATE KEY UPDATE ")
+		builder.WriteString(strings.Join(updates, ", "))
+	} else {
+		pks := make([]string, len(table.PrimaryKeys))
+		for i, pk := range table.PrimaryKeys {
+			pks[i] = g.engine.Quote(pk)
+		}
+		builder.WriteString(" ON CONFLICT (")
+		builder.WriteString(strings.Join(pks, ", "))
+		if len(updates) == 0 {
+			builder.WriteString(") DO NOTHING")
+		} else {
+			builder.WriteString(") DO UPDATE SET ")
+			builder.WriteString(strings.Join(updates, ", "))
+		}
+	}
+
+	_, err = session.Exec(append([]any{builder.String()}, args...)...)
+	return err
+}
+
+// columnValue 字段值转化为数据库参数, 与 xorm 新建时的转换规则一致: 自定义转换, 时间, 指针, json
+func (g *GlobalManager[T]) columnValue(col *schemas.Column, v reflect.Value) (any, error) {
+	if v.CanAddr() {
+		if conversion, ok := v.Addr().Interface().(convert.Conversion); ok {
+			return conversionValue(col, conversion)
+		}
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil, nil
+		}
+		if conversion, ok := v.Interface().(convert.Conversion); ok {
+			return conversionValue(col, conversion)
+		}
+		v = v.Elem()
+	} else if conversion, ok := v.Interface().(convert.Conversion); ok {
+		return conversionValue(col, conversion)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type().ConvertibleTo(schemas.TimeType) {
+			t := v.Convert(schemas.TimeType).Interface().(time.Time)
+			return dialects.FormatColumnTime(g.engine.Dialect(), g.engine.GetTZDatabase(), col, t)
+		}
+		if valuer, ok := v.Interface().(driver.Valuer); ok && !col.IsJSON {
+			return valuer.Value()
+		}
+		return jsonValue(col, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && col.SQLType.IsBlob() {
+			return v.Bytes(), nil
+		}
+		return jsonValue(col, v)
+	case reflect.Array, reflect.Map, reflect.Complex64, reflect.Complex128:
+		return jsonValue(col, v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	}
+	return v.Interface(), nil
+}
+
+// conversionValue 自定义转换的字段值
+func conversionValue(col *schemas.Column, conversion convert.Conversion) (any, error) {
+	data, err := conversion.ToDB()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if col.Nullable {
+			return nil, nil
+		}
+		data = []byte{}
+	}
+	if col.SQLType.IsBlob() {
+		return data, nil
+	}
+	return string(data), nil
+}
+
+// jsonValue 复合类型按json保存
+func jsonValue(col *schemas.Column, v reflect.Value) (any, error) {
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	if col.SQLType.IsBlob() {
+		return data, nil
+	}
+	return string(data), nil
+}
@@ -0,0 +1,190 @@
+package persist_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spelens-gud/persist"
+)
+
+type UpsertGlobal struct {
+	Id      int64             `xorm:"pk"`
+	Name    string            `xorm:""`
+	Tags    []string          `xorm:""`
+	Attr    map[string]int    `xorm:"json"`
+	Data    []byte            `xorm:""`
+	Score   uint32            `xorm:""`
+	Parent  *int64            `xorm:""`
+	Updated time.Time         `xorm:""`
+	Extra   map[string]string `xorm:"-"`
+}
+
+// TestGlobalManager_Upsert 测试 Upsert 新建和覆盖, 写入结果与 xorm 新建一致.
+func TestGlobalManager_Upsert(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[UpsertGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	parent := int64(9)
+	want := &UpsertGlobal{
+		Id:      1,
+		Name:    "old",
+		Tags:    []string{"a", "b"},
+		Attr:    map[string]int{"x": 1},
+		Data:    []byte{1, 2, 3},
+		Score:   7,
+		Parent:  &parent,
+		Updated: time.Date(2024, 5, 6, 7, 8, 9, 0, time.Local),
+	}
+	if _, err := engine.Insert(want); err != nil {
+		t.Fatal(err)
+	}
+	inserted := new(UpsertGlobal)
+	if _, err := engine.ID(1).Get(inserted); err != nil {
+		t.Fatal(err)
+	}
+
+	session := engine.NewSession()
+	defer session.Close()
+	cp := *want
+	cp.Id = 2
+	if err := g.Upsert(session, &cp); err != nil {
+		t.Fatalf("Upsert() insert error = %v", err)
+	}
+	upserted := new(UpsertGlobal)
+	if has, err := engine.ID(2).Get(upserted); err != nil || !has {
+		t.Fatalf("Get() = %v, %v", has, err)
+	}
+	upserted.Id = 1
+	if !reflect.DeepEqual(upserted, inserted) {
+		t.Errorf("Upsert() row = %+v, want %+v", upserted, inserted)
+	}
+
+	cp = *want
+	cp.Name = "new"
+	cp.Parent = nil
+	if err := g.Upsert(session, &cp); err != nil {
+		t.Fatalf("Upsert() update error = %v", err)
+	}
+	got := new(UpsertGlobal)
+	if _, err := engine.ID(1).Get(got); err != nil || got.Name != "new" || got.Parent != nil {
+		t.Errorf("Upsert() row = %+v, %v", got, err)
+	}
+	if n, _ := engine.Count(new(UpsertGlobal)); n != 2 {
+		t.Errorf("rows = %d, want 2", n)
+	}
+}
+
+// TestGlobalManager_ReplayIdempotent 测试写回提交后bomb文件未删除, 重启重放不会失败.
+func TestGlobalManager_ReplayIdempotent(t *testing.T) {
+	for _, upsert := range []bool{true, false} {
+		g, engine := newTestManager(t)
+		if _, err := engine.Insert(&ManagerGlobal{AuthId: 1, Name: "committed"}); err != nil {
+			t.Fatal(err)
+		}
+		// 模拟已经提交但没有删除的bomb文件
+		g.FailQueue = []*persist.GlobalSync[ManagerGlobal]{
+			opSync(persist.EGlobalOpInsert, 1, "bomb"),
+			opSync(persist.EGlobalOpDelete, 2, ""),
+			opSync(persist.EGlobalOpInsert, 3, "new"),
+		}
+		if err := g.SaveFile(); err != nil {
+			t.Fatal(err)
+		}
+
+		restarted := persist.NewGlobalManager[ManagerGlobal](engine)
+		restarted.SetUpsertReplay(upsert)
+		err := restarted.Run()
+		if !upsert {
+			if err == nil {
+				t.Error("Run() without upsert should fail on duplicate key")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		restarted.Exit(&sync.WaitGroup{})
+
+		if persist.DirExists(persist.BombFilePath(g.PersistName())) {
+			t.Error("bomb file should be removed after replay")
+		}
+		var rows []ManagerGlobal
+		if err := engine.Asc("auth_id").Find(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0].Name != "bomb" || rows[1].Name != "new" {
+			t.Errorf("rows = %+v", rows)
+		}
+	}
+}
+
+// TestGlobalManager_ReplayFailQueue 测试失败队列中的新建已经提交时, 重试按重放写入, 失败队列可以清空.
+func TestGlobalManager_ReplayFailQueue(t *testing.T) {
+	g, engine := newTestManager(t)
+	g.SetFlush(persist.GlobalFlushConfig{MaxInterval: 20 * time.Millisecond})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.Health().FailQueue > 0 })
+
+	// 模拟写回已经提交但没有收到结果
+	if err := engine.Sync(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 1, Name: "committed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 2, Name: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.Health().FailQueue == 0 && countRows(t, engine) == 2 })
+	g.Exit(&sync.WaitGroup{})
+
+	row := new(ManagerGlobal)
+	if has, err := engine.ID(1).Get(row); err != nil || !has || row.Name != "local" {
+		t.Errorf("row = %+v, %v, %v", row, has, err)
+	}
+}
+
+// TestGlobalManager_ReplayInsertDelete 测试失败队列中的新建与之后的删除不相互抵消, 新建已经提交时仍删除.
+func TestGlobalManager_ReplayInsertDelete(t *testing.T) {
+	g, engine := newTestManager(t)
+	// 失败期间不按批量触发写回, 新建和删除在退出时同一轮重试
+	g.SetFlush(persist.GlobalFlushConfig{MinInterval: time.Millisecond, MaxInterval: time.Hour, BatchSize: 1})
+	if err := engine.DropTables(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Insert(&ManagerGlobal{AuthId: 1, Name: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return g.Health().FailQueue > 0 })
+
+	// 模拟写回已经提交但没有收到结果
+	if err := engine.Sync(new(ManagerGlobal)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Insert(&ManagerGlobal{AuthId: 1, Name: "committed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Delete(int64(1)); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	if n := countRows(t, engine); n != 0 {
+		t.Errorf("db rows = %d, want 0", n)
+	}
+}
@@ -7,37 +7,24 @@ import (
 
 // GetFieldNames 获取结构体字段名
 func GetFieldNames(obj any) []string {
-	t := reflect.TypeOf(obj)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-	var fields []string
-	for i := 0; i < t.NumField(); i++ {
-		fields = append(fields, t.Field(i).Name)
-	}
-	return fields
+	meta := getGlobalMeta(reflect.TypeOf(obj))
+	return append([]string(nil), meta.FieldNames...)
 }
 
 // GetFieldNum 获取结构体字段数量
 func GetFieldNum(obj any) int {
-	t := reflect.TypeOf(obj)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-	return t.NumField()
+	return getGlobalMeta(reflect.TypeOf(obj)).FieldNum
 }
 
 // GetFieldValueByTag 获取结构体字段值通过tag
 func GetFieldValueByTag(obj any, tagName, tagValue string) (value any, found bool) {
-	t := reflect.TypeOf(obj)
-	v := reflect.ValueOf(obj)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-		v = v.Elem()
-	}
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if field.Tag.Get(tagName) == tagValue {
+	meta := getGlobalMeta(reflect.TypeOf(obj))
+	for i, tag := range meta.Tags {
+		if tag.Get(tagName) == tagValue {
+			v := reflect.ValueOf(obj)
+			if v.Kind() == reflect.Ptr {
+				v = v.Elem()
+			}
 			return v.Field(i).Interface(), true
 		}
 	}
@@ -55,3 +42,274 @@ func DirExists(path string) bool {
 	}
 	return true
 }
+
+// NewGenericConcurrentMap 创建并发安全的泛型map, 读多写少的场景下读操作无锁
+func NewGenericConcurrentMap[K comparable, V any]() *GenericConcurrentMap[K, V] {
+	return &GenericConcurrentMap[K, V]{exp: new(*V)}
+}
+
+// loadReadOnly 获取只读部分
+func (m *GenericConcurrentMap[K, V]) loadReadOnly() readOnlyGeneric[K, V] {
+	if p := m.read.Load(); p != nil {
+		return *p
+	}
+	return readOnlyGeneric[K, V]{}
+}
+
+// Load 获取key对应的值
+func (m *GenericConcurrentMap[K, V]) Load(key K) (value *V, ok bool) {
+	read := m.loadReadOnly()
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	if !ok {
+		return nil, false
+	}
+	return e.load(m.exp)
+}
+
+// Store 设置key对应的值
+func (m *GenericConcurrentMap[K, V]) Store(key K, value *V) {
+	_, _ = m.Swap(key, value)
+}
+
+// Swap 设置key对应的值, 返回之前的值
+func (m *GenericConcurrentMap[K, V]) Swap(key K, value *V) (previous *V, loaded bool) {
+	read := m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if v, ok := e.trySwap(&value, m.exp); ok {
+			if v == nil {
+				return nil, false
+			}
+			return *v, true
+		}
+	}
+
+	m.mu.Lock()
+	read = m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked(m.exp) {
+			m.dirty[key] = e
+		}
+		if v := e.p.Swap(&value); v != nil {
+			loaded = true
+			previous = *v
+		}
+	} else if e, ok := m.dirty[key]; ok {
+		if v := e.p.Swap(&value); v != nil {
+			loaded = true
+			previous = *v
+		}
+	} else {
+		if !read.amended {
+			m.dirtyLocked()
+			m.read.Store(&readOnlyGeneric[K, V]{m: read.m, amended: true})
+		}
+		m.dirty[key] = newEntryGeneric(value)
+	}
+	m.mu.Unlock()
+	return previous, loaded
+}
+
+// LoadOrStore key存在时返回已有的值, 否则设置并返回 value
+func (m *GenericConcurrentMap[K, V]) LoadOrStore(key K, value *V) (actual *V, loaded bool) {
+	read := m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		actual, loaded, ok := e.tryLoadOrStore(value, m.exp)
+		if ok {
+			return actual, loaded
+		}
+	}
+
+	m.mu.Lock()
+	read = m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked(m.exp) {
+			m.dirty[key] = e
+		}
+		actual, loaded, _ = e.tryLoadOrStore(value, m.exp)
+	} else if e, ok := m.dirty[key]; ok {
+		actual, loaded, _ = e.tryLoadOrStore(value, m.exp)
+		m.missLocked()
+	} else {
+		if !read.amended {
+			m.dirtyLocked()
+			m.read.Store(&readOnlyGeneric[K, V]{m: read.m, amended: true})
+		}
+		m.dirty[key] = newEntryGeneric(value)
+		actual, loaded = value, false
+	}
+	m.mu.Unlock()
+	return actual, loaded
+}
+
+// LoadAndDelete 删除key, 返回之前的值
+func (m *GenericConcurrentMap[K, V]) LoadAndDelete(key K) (value *V, loaded bool) {
+	read := m.loadReadOnly()
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			delete(m.dirty, key)
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	if ok {
+		return e.delete(m.exp)
+	}
+	return nil, false
+}
+
+// Delete 删除key
+func (m *GenericConcurrentMap[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
+}
+
+// Range 遍历所有key, f 返回false时停止
+func (m *GenericConcurrentMap[K, V]) Range(f func(key K, value *V) bool) {
+	read := m.loadReadOnly()
+	if read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		if read.amended {
+			read = readOnlyGeneric[K, V]{m: m.dirty}
+			copyRead := read
+			m.read.Store(&copyRead)
+			m.dirty = nil
+			m.misses = 0
+		}
+		m.mu.Unlock()
+	}
+
+	for k, e := range read.m {
+		v, ok := e.load(m.exp)
+		if !ok {
+			continue
+		}
+		if !f(k, v) {
+			break
+		}
+	}
+}
+
+// missLocked 只读部分未命中次数达到dirty长度时, 将dirty提升为只读部分
+func (m *GenericConcurrentMap[K, V]) missLocked() {
+	m.misses++
+	if m.misses < len(m.dirty) {
+		return
+	}
+	m.read.Store(&readOnlyGeneric[K, V]{m: m.dirty})
+	m.dirty = nil
+	m.misses = 0
+}
+
+// dirtyLocked 从只读部分复制dirty, 已删除的条目标记为 expunged
+func (m *GenericConcurrentMap[K, V]) dirtyLocked() {
+	if m.dirty != nil {
+		return
+	}
+
+	read := m.loadReadOnly()
+	m.dirty = make(map[K]*entryGeneric[V], len(read.m))
+	for k, e := range read.m {
+		if !e.tryExpungeLocked(m.exp) {
+			m.dirty[k] = e
+		}
+	}
+}
+
+// newEntryGeneric 创建条目
+func newEntryGeneric[V any](value *V) *entryGeneric[V] {
+	e := &entryGeneric[V]{}
+	e.p.Store(&value)
+	return e
+}
+
+// load 获取条目的值
+func (e *entryGeneric[V]) load(exp **V) (value *V, ok bool) {
+	p := e.p.Load()
+	if p == nil || p == exp {
+		return nil, false
+	}
+	return *p, true
+}
+
+// trySwap 条目未被 expunged 时替换值
+func (e *entryGeneric[V]) trySwap(i **V, exp **V) (**V, bool) {
+	for {
+		p := e.p.Load()
+		if p == exp {
+			return nil, false
+		}
+		if e.p.CompareAndSwap(p, i) {
+			return p, true
+		}
+	}
+}
+
+// unexpungeLocked 取消 expunged 标记, 返回之前是否被 expunged
+func (e *entryGeneric[V]) unexpungeLocked(exp **V) (wasExpunged bool) {
+	return e.p.CompareAndSwap(exp, nil)
+}
+
+// tryLoadOrStore 条目未被 expunged 时获取或设置值
+func (e *entryGeneric[V]) tryLoadOrStore(value *V, exp **V) (actual *V, loaded, ok bool) {
+	p := e.p.Load()
+	if p == exp {
+		return nil, false, false
+	}
+	if p != nil {
+		return *p, true, true
+	}
+
+	ic := value
+	for {
+		if e.p.CompareAndSwap(nil, &ic) {
+			return value, false, true
+		}
+		p = e.p.Load()
+		if p == exp {
+			return nil, false, false
+		}
+		if p != nil {
+			return *p, true, true
+		}
+	}
+}
+
+// delete 删除条目的值
+func (e *entryGeneric[V]) delete(exp **V) (value *V, ok bool) {
+	for {
+		p := e.p.Load()
+		if p == nil || p == exp {
+			return nil, false
+		}
+		if e.p.CompareAndSwap(p, nil) {
+			return *p, true
+		}
+	}
+}
+
+// tryExpungeLocked 已删除的条目标记为 expunged
+func (e *entryGeneric[V]) tryExpungeLocked(exp **V) (isExpunged bool) {
+	p := e.p.Load()
+	for p == nil {
+		if e.p.CompareAndSwap(nil, exp) {
+			return true
+		}
+		p = e.p.Load()
+	}
+	return p == exp
+}
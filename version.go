@@ -0,0 +1,249 @@
+package persist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+)
+
+const eGlobalOpVersionFlag = 0x40 // 序列化时操作类型的标记位, 之后是修改前的版本号
+const eGlobalConflictRetry = 3    // 合并冲突时的重试次数
+
+// GlobalConflictPolicy 版本号冲突处理策略, 写回修改时数据库中的版本号与修改前不一致
+type GlobalConflictPolicy int8
+
+const (
+	EGlobalConflictReload         GlobalConflictPolicy = 0 // 放弃本地修改, 内存数据按数据库重新读取
+	EGlobalConflictLastWriterWins GlobalConflictPolicy = 1 // 不检查版本号, 本地修改覆盖数据库
+	EGlobalConflictMerge          GlobalConflictPolicy = 2 // 调用合并函数合并本地修改和数据库数据后写回
+)
+
+// SetConflict 设置版本号冲突处理策略, merge 只在 EGlobalConflictMerge 时使用, 应在 Run 之前调用
+// merge 的返回值作为新数据写回, local 和 db 可以修改
+func (g *GlobalManager[T]) SetConflict(policy GlobalConflictPolicy, merge func(local, db *T) *T) {
+	g.conflictPolicy = policy
+	g.conflictMerge = merge
+}
+
+// hasVersion 是否有版本号字段
+func (g *GlobalManager[T]) hasVersion() bool {
+	return g.meta.Version >= 0
+}
+
+// versionOf 版本号字段的值
+func (g *GlobalManager[T]) versionOf(cls *T) int64 {
+	v := reflect.ValueOf(cls).Elem().Field(g.meta.Version)
+	if v.CanInt() {
+		return v.Int()
+	}
+	return int64(v.Uint())
+}
+
+// setVersion 设置版本号字段的值
+func (g *GlobalManager[T]) setVersion(cls *T, version int64) {
+	v := reflect.ValueOf(cls).Elem().Field(g.meta.Version)
+	if v.CanInt() {
+		v.SetInt(version)
+	} else {
+		v.SetUint(uint64(version))
+	}
+}
+
+// versionColumn 版本号列名
+func (g *GlobalManager[T]) versionColumn() string {
+	return g.engine.Quote(g.dbFieldMap[g.meta.Version])
+}
+
+// appendSyncOp 序列化操作类型, 有版本号的修改附带修改前的版本号
+func (g *GlobalManager[T]) appendSyncOp(data []byte, persistSync *GlobalSync[T]) []byte {
+	if persistSync.Op != EGlobalOpUpdate || !g.hasVersion() {
+		return append(data, uint8(persistSync.Op))
+	}
+	data = append(data, uint8(persistSync.Op)|eGlobalOpVersionFlag)
+	return binary.AppendVarint(data, persistSync.Version)
+}
+
+// readSyncOp 反序列化操作类型和修改前的版本号, 返回剩余数据
+func readSyncOp[T any](data []byte, persistSync *GlobalSync[T]) ([]byte, error) {
+	op := data[0]
+	data = data[1:]
+	persistSync.Op = int8(op &^ eGlobalOpVersionFlag)
+	if op&eGlobalOpVersionFlag != 0 {
+		version, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("%w: bad version", EPersistErrorInvalidData)
+		}
+		persistSync.Version = version
+		data = data[n:]
+	}
+	return data, nil
+}
+
+// updateVersion 有版本号的修改写回, 数据库版本号与修改前一致时写入, 否则按冲突策略处理
+func (g *GlobalManager[T]) updateVersion(session *xorm.Session, persistSync *GlobalSync[T], nameList []string) error {
+	cls := persistSync.Data
+	pk := schemas.PK(g.pkValues(cls))
+	if nameList == nil {
+		nameList = g.Cols(g.bitSetAll)
+	}
+	affected, err := g.on(session, cls).ID(pk).NoVersionCheck().Cols(nameList...).
+		Where(g.versionColumn()+" = ?", persistSync.Version).Update(cls)
+	if err != nil || affected > 0 {
+		return err
+	}
+	return g.resolveConflict(session, persistSync, nameList)
+}
+
+// resolveConflict 版本号冲突处理
+// 重新读取和合并后内存中的版本号大于本地和数据库的版本号, 本地过期的数据修改时返回 EPersistErrorOutOfDate
+func (g *GlobalManager[T]) resolveConflict(session *xorm.Session, persistSync *GlobalSync[T], nameList []string) error {
+	cls := persistSync.Data
+	pk := schemas.PK(g.pkValues(cls))
+	for range eGlobalConflictRetry {
+		db := new(T)
+		has, err := g.on(session, cls).ID(pk).NoVersionCheck().Get(db)
+		if err != nil {
+			return err
+		}
+		if has && g.applied(db, persistSync) {
+			// 修改已经写入, 重放bomb文件时出现
+			return nil
+		}
+		if !has {
+			// 其它进程已经删除
+			g.logError("update", fmt.Errorf("%w: deleted", EPersistErrorOutOfDate), persistSync)
+			if g.conflictPolicy == EGlobalConflictLastWriterWins {
+				return g.Upsert(session, cls)
+			}
+			g.reloadRow(g.pkKey(cls), -1, nil)
+			return nil
+		}
+
+		version := g.versionOf(db)
+		next := max(version, g.versionOf(cls)) + 1
+		switch g.conflictPolicy {
+		case EGlobalConflictLastWriterWins:
+			g.logError("update", fmt.Errorf("%w: overwrite", EPersistErrorOutOfDate), persistSync)
+			local := g.versionOf(cls)
+			g.setVersion(cls, next)
+			if _, err = g.on(session, cls).ID(pk).NoVersionCheck().Cols(nameList...).Update(cls); err != nil {
+				g.setVersion(cls, local)
+				return err
+			}
+			g.overwriteVersion(g.pkKey(cls), local, next)
+			return nil
+
+		case EGlobalConflictMerge:
+			if g.conflictMerge == nil {
+				break
+			}
+			local := new(T)
+			*local = *cls
+			merged := g.conflictMerge(local, db)
+			if merged == nil {
+				break
+			}
+			g.setVersion(merged, next)
+			affected, err := g.on(session, cls).ID(pk).NoVersionCheck().Cols(g.Cols(g.bitSetAll)...).
+				Where(g.versionColumn()+" = ?", version).Update(merged)
+			if err != nil {
+				return err
+			}
+			if affected == 0 {
+				// 合并期间数据库再次被修改, 重新读取后合并
+				continue
+			}
+			g.logError("update", fmt.Errorf("%w: merged", EPersistErrorOutOfDate), persistSync)
+			g.reloadRow(g.pkKey(cls), g.versionOf(cls), merged)
+			return nil
+		}
+
+		// 放弃本地修改, 只读取数据库, 不修改数据库中的版本号
+		g.logError("update", fmt.Errorf("%w: reload", EPersistErrorOutOfDate), persistSync)
+		g.reloadConflict(g.pkKey(cls), db, next)
+		return nil
+	}
+	return EPersistErrorOutOfDate
+}
+
+// applied 数据库中的数据是否已经包含这次修改: 版本号相同且修改的列都相同
+func (g *GlobalManager[T]) applied(db *T, persistSync *GlobalSync[T]) bool {
+	if g.versionOf(db) != g.versionOf(persistSync.Data) {
+		return false
+	}
+	diff := DiffPersist(db, persistSync.Data)
+	for i := range diff.Intersect(persistSync.BitSet).Fields() {
+		if g.dbFieldMap[i] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// reloadRow 冲突处理后更新内存数据, 只更新在内存中的数据, row 为 nil 时删除
+// expect 不小于0时只在内存数据的版本号等于 expect 时替换, 内存中更新的修改写回时会再次按冲突策略处理
+func (g *GlobalManager[T]) reloadRow(key any, expect int64, row *T) {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	cur, ok := g.rows.Load(key)
+	if !ok || (expect >= 0 && g.versionOf(cur) != expect) {
+		return
+	}
+	delete(g.reloaded, key)
+	if row == nil {
+		g.rows.Delete(key)
+		return
+	}
+	cp := new(T)
+	*cp = *row
+	g.rows.Store(key, cp)
+}
+
+// overwriteVersion 覆盖写入后内存数据使用写入的版本号, 只在内存数据的版本号等于 expect 时更新
+func (g *GlobalManager[T]) overwriteVersion(key any, expect, version int64) {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	cur, ok := g.rows.Load(key)
+	if !ok || g.versionOf(cur) != expect {
+		return
+	}
+	cp := new(T)
+	*cp = *cur
+	g.setVersion(cp, version)
+	g.rows.Store(key, cp)
+}
+
+// globalReload 冲突重新读取后的版本号
+type globalReload struct {
+	memory int64 // 内存中的版本号, 大于本地所有副本的版本号
+	db     int64 // 数据库中的版本号
+}
+
+// reloadConflict 放弃本地修改后内存数据按数据库数据替换, 不在内存中时不处理
+// 内存版本号使用 version, 本地过期的副本修改时返回 EPersistErrorOutOfDate; 下一次修改写回时按数据库版本号检查
+func (g *GlobalManager[T]) reloadConflict(key any, db *T, version int64) {
+	g.opMu.Lock()
+	defer g.opMu.Unlock()
+	if _, ok := g.rows.Load(key); !ok {
+		return
+	}
+	g.reloaded[key] = globalReload{memory: version, db: g.versionOf(db)}
+	g.setVersion(db, version)
+	g.rows.Store(key, db)
+}
+
+// syncVersion 修改写回时检查的数据库版本号, 调用时持有 opMu
+func (g *GlobalManager[T]) syncVersion(key any, version int64) int64 {
+	reload, ok := g.reloaded[key]
+	if !ok {
+		return version
+	}
+	delete(g.reloaded, key)
+	if reload.memory != version {
+		return version
+	}
+	return reload.db
+}
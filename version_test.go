@@ -0,0 +1,234 @@
+package persist_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+	"xorm.io/xorm"
+)
+
+type VersionGlobal struct {
+	Id   int64  `xorm:"pk"`
+	Name string `xorm:""`
+	Gold int64  `xorm:""`
+	Ver  int    `xorm:"version"`
+}
+
+// newVersionManager 创建有版本号字段的管理器, 导入已有的一行数据
+func newVersionManager(t *testing.T, policy persist.GlobalConflictPolicy, merge func(local, db *VersionGlobal) *VersionGlobal) (*persist.GlobalManager[VersionGlobal], *xorm.Engine) {
+	t.Helper()
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[VersionGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Insert(&VersionGlobal{Id: 1, Name: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	g.SetConflict(policy, merge)
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	return g, engine
+}
+
+// remoteUpdate 模拟其它进程修改数据
+func remoteUpdate(t *testing.T, engine *xorm.Engine) {
+	t.Helper()
+	if _, err := engine.Exec("UPDATE version_global SET name = 'remote', ver = ver + 1 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getVersionRow 读取数据库中的数据
+func getVersionRow(t *testing.T, engine *xorm.Engine) *VersionGlobal {
+	t.Helper()
+	row := new(VersionGlobal)
+	if _, err := engine.ID(1).NoVersionCheck().Get(row); err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+// TestGlobalManager_Version 测试修改时版本号加1, 过期数据返回 EPersistErrorOutOfDate.
+func TestGlobalManager_Version(t *testing.T) {
+	g, engine := newVersionManager(t, persist.EGlobalConflictReload, nil)
+	cls := &VersionGlobal{Id: 2, Name: "new"}
+	if err := g.Insert(cls); err != nil || cls.Ver != 1 {
+		t.Fatalf("Insert() = %v, Ver = %d", err, cls.Ver)
+	}
+	stale, _ := g.Get(int64(2))
+	for i := 0; i < 2; i++ {
+		cls.Gold += 10
+		if err := g.Update(cls, persist.GlobalBitSet[VersionGlobal]{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cls.Ver != 3 {
+		t.Errorf("Ver = %d, want 3", cls.Ver)
+	}
+	if err := g.Update(stale, persist.GlobalBitSet[VersionGlobal]{}); err != persist.EPersistErrorOutOfDate {
+		t.Errorf("Update() stale error = %v", err)
+	}
+
+	row, _ := g.Get(int64(1))
+	bitSet := persist.InitGlobalBitSet[VersionGlobal]()
+	row.Gold = 5
+	if err := g.Update(row, *bitSet.Set(2)); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	var rows []VersionGlobal
+	if err := engine.NewSession().NoVersionCheck().Asc("id").Find(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Ver != 2 || rows[0].Gold != 5 || rows[1].Ver != 3 || rows[1].Gold != 20 {
+		t.Errorf("rows = %+v", rows)
+	}
+}
+
+// TestGlobalManager_ConflictReload 测试冲突时放弃本地修改, 内存按数据库重新读取, 不修改数据库.
+func TestGlobalManager_ConflictReload(t *testing.T) {
+	g, engine := newVersionManager(t, persist.EGlobalConflictReload, nil)
+	remoteUpdate(t, engine)
+
+	cls, _ := g.Get(int64(1))
+	cls.Gold = 100
+	if err := g.Update(cls, persist.GlobalBitSet[VersionGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		row, _ := g.Get(int64(1))
+		return row.Name == "remote"
+	})
+	// 内存版本号大于本地和数据库的版本号, 本地过期的数据不能再修改
+	row, _ := g.Get(int64(1))
+	if row.Ver != 3 || row.Gold != 0 {
+		t.Errorf("reloaded row = %+v", row)
+	}
+	if err := g.Update(cls, persist.GlobalBitSet[VersionGlobal]{}); err != persist.EPersistErrorOutOfDate {
+		t.Errorf("Update() after reload error = %v", err)
+	}
+	if db := getVersionRow(t, engine); db.Gold != 0 || db.Name != "remote" || db.Ver != 2 {
+		t.Errorf("db row after reload = %+v", db)
+	}
+
+	// 重新读取后的修改按数据库版本号写回
+	row.Gold = 5
+	if err := g.Update(row, persist.GlobalBitSet[VersionGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+	if db := getVersionRow(t, engine); db.Gold != 5 || db.Name != "remote" || db.Ver != 4 {
+		t.Errorf("db row = %+v", db)
+	}
+}
+
+// TestGlobalManager_ConflictLastWriterWins 测试冲突时本地修改覆盖数据库.
+func TestGlobalManager_ConflictLastWriterWins(t *testing.T) {
+	g, engine := newVersionManager(t, persist.EGlobalConflictLastWriterWins, nil)
+	remoteUpdate(t, engine)
+
+	cls, _ := g.Get(int64(1))
+	cls.Gold = 100
+	bitSet := persist.InitGlobalBitSet[VersionGlobal]()
+	if err := g.Update(cls, *bitSet.Set(2)); err != nil {
+		t.Fatal(err)
+	}
+	// 覆盖写入的版本号大于数据库和本地的版本号, 内存数据同步更新
+	waitFor(t, func() bool {
+		row, _ := g.Get(int64(1))
+		return row.Ver == 3
+	})
+	if db := getVersionRow(t, engine); db.Gold != 100 || db.Name != "remote" || db.Ver != 3 {
+		t.Errorf("db row after overwrite = %+v", db)
+	}
+
+	// 之后的修改不再冲突
+	conflictAt := g.Health().LastErrorAt
+	row, _ := g.Get(int64(1))
+	row.Gold = 200
+	if err := g.Update(row, *bitSet.Set(2)); err != nil {
+		t.Fatal(err)
+	}
+	g.Exit(&sync.WaitGroup{})
+	if db := getVersionRow(t, engine); db.Gold != 200 || db.Name != "remote" || db.Ver != 4 {
+		t.Errorf("db row = %+v", db)
+	}
+	if at := g.Health().LastErrorAt; !at.Equal(conflictAt) {
+		t.Errorf("conflict after overwrite at %v", at)
+	}
+}
+
+// TestGlobalManager_ConflictMerge 测试冲突时调用合并函数.
+func TestGlobalManager_ConflictMerge(t *testing.T) {
+	g, engine := newVersionManager(t, persist.EGlobalConflictMerge, func(local, db *VersionGlobal) *VersionGlobal {
+		db.Gold += local.Gold
+		return db
+	})
+	remoteUpdate(t, engine)
+	if _, err := engine.Exec("UPDATE version_global SET gold = 7 WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	cls, _ := g.Get(int64(1))
+	cls.Gold = 100
+	if err := g.Update(cls, persist.GlobalBitSet[VersionGlobal]{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		row, _ := g.Get(int64(1))
+		return row.Name == "remote"
+	})
+	if row, _ := g.Get(int64(1)); row.Gold != 107 || row.Ver != 3 || row.Ver == cls.Ver {
+		t.Errorf("merged row = %+v", row)
+	}
+	g.Exit(&sync.WaitGroup{})
+
+	if db := getVersionRow(t, engine); db.Gold != 107 || db.Name != "remote" || db.Ver != 3 {
+		t.Errorf("db row = %+v", db)
+	}
+}
+
+// TestGlobalManager_VersionReplay 测试已经写入的修改重放时不会冲突, 序列化保留修改前的版本号.
+func TestGlobalManager_VersionReplay(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[VersionGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.NewSession().NoVersionCheck().Insert(&VersionGlobal{Id: 1, Name: "applied", Ver: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	bitSet := persist.InitGlobalBitSet[VersionGlobal]()
+	bitSet.SetAll()
+	src := &persist.GlobalSync[VersionGlobal]{
+		Data:    &VersionGlobal{Id: 1, Name: "applied", Ver: 2},
+		Op:      persist.EGlobalOpUpdate,
+		BitSet:  bitSet,
+		Version: 1,
+	}
+	got, err := g.BytesToPersistSync(g.PersistSyncToBytes(src))
+	if err != nil || got.Version != 1 || got.Op != persist.EGlobalOpUpdate || got.Data.Ver != 2 {
+		t.Fatalf("BytesToPersistSync() = %+v, %v", got, err)
+	}
+
+	g.FailQueue = []*persist.GlobalSync[VersionGlobal]{src}
+	if err = g.SaveFile(); err != nil {
+		t.Fatal(err)
+	}
+	restarted := persist.NewGlobalManager[VersionGlobal](engine)
+	if err = restarted.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	restarted.Exit(&sync.WaitGroup{})
+	if db := getVersionRow(t, engine); db.Name != "applied" || db.Ver != 2 {
+		t.Errorf("db row = %+v", db)
+	}
+}
@@ -0,0 +1,344 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+const eWarmupChunkSize = 1000 // 默认全导入分块大小
+
+// WarmupKind 预热阶段
+type WarmupKind int8
+
+const (
+	EWarmupKindTable WarmupKind = 1 // 全导入全局表
+	EWarmupKindUser  WarmupKind = 2 // 预导入热点用户
+)
+
+// WarmupProgress 预热进度
+type WarmupProgress struct {
+	Kind  WarmupKind // 预热阶段
+	Name  string     // 全导入的persist名, 预导入用户时为空
+	Done  int64      // 已导入的行数或用户数, 包括导入失败的用户
+	Total int64      // 总行数或总用户数
+}
+
+// WarmupConfig 启动预热配置
+type WarmupConfig struct {
+	Tables    []string                      // 全导入的persist名, 为空时全导入所有未开启按主键导入的全局表
+	ChunkSize int                           // 全导入分块大小, 为0时按 eWarmupChunkSize
+	Parallel  int                           // 并行查询的数量, 为0时按CPU数量
+	Uids      []int32                       // 预导入的热点用户
+	UidQuery  func() ([]int32, error)       // 查询热点用户, 结果与 Uids 合并
+	Progress  func(progress WarmupProgress) // 进度回调, 同一时间只有一个调用
+}
+
+// IPersistWarmup 支持分块全导入的persist
+type IPersistWarmup interface {
+	LoadAllChunks(chunkSize, parallel int, progress func(done, total int64)) (err error) // 分块并行全导入
+}
+
+var (
+	gWarmupConfig *WarmupConfig // InitPersists 时的预热配置, 为 nil 时不预热
+	gReady        atomic.Bool   // 预热完成, 可以接收请求
+)
+
+// SetWarmup 设置 InitPersists 时的预热, 应在 InitPersists 之前调用
+func SetWarmup(config WarmupConfig) error {
+	if config.ChunkSize < 0 || config.Parallel < 0 {
+		return fmt.Errorf("%w: warmup chunk size %d, parallel %d", EPersistErrorInvalidConfig, config.ChunkSize, config.Parallel)
+	}
+	gWarmupConfig = &config
+	return nil
+}
+
+// Ready 预热是否完成, 可用于就绪探针
+func Ready() bool {
+	return gReady.Load()
+}
+
+// CheckReady 预热完成前返回 EPersistErrorNotInMemory, 请求处理前调用以快速失败
+func CheckReady() error {
+	if !gReady.Load() {
+		return EPersistErrorNotInMemory
+	}
+	return nil
+}
+
+// Warmup 分块并行全导入全局表, 再并行预导入热点用户, 完成后设置就绪
+// 全局表导入失败时返回错误并保持未就绪; 用户导入失败只输出错误, 不影响就绪
+func Warmup(config WarmupConfig) error {
+	gReady.Store(false)
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = eWarmupChunkSize
+	}
+	if config.Parallel <= 0 {
+		config.Parallel = runtime.NumCPU()
+	}
+	var progressMu sync.Mutex
+	report := func(progress WarmupProgress) {
+		if config.Progress == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		config.Progress(progress)
+	}
+
+	for _, name := range warmupTables(config.Tables) {
+		persist, ok := gPersistMap[name].(IPersistWarmup)
+		if !ok {
+			return fmt.Errorf("%w: %s does not support warmup", EPersistErrorInvalidConfig, name)
+		}
+		err := persist.LoadAllChunks(config.ChunkSize, config.Parallel, func(done, total int64) {
+			report(WarmupProgress{Kind: EWarmupKindTable, Name: name, Done: done, Total: total})
+		})
+		if err != nil && !errors.Is(err, EPersistErrorIncorrectState) {
+			return fmt.Errorf("warmup %s: %w", name, err)
+		}
+	}
+
+	uids := slices.Clone(config.Uids)
+	if config.UidQuery != nil {
+		queried, err := config.UidQuery()
+		if err != nil {
+			return fmt.Errorf("warmup uid query: %w", err)
+		}
+		uids = append(uids, queried...)
+	}
+	slices.Sort(uids)
+	uids = slices.Compact(uids)
+	warmupUsers(uids, config.Parallel, func(done int64) {
+		report(WarmupProgress{Kind: EWarmupKindUser, Done: done, Total: int64(len(uids))})
+	})
+
+	gReady.Store(true)
+	return nil
+}
+
+// warmupTables 需要全导入的persist名, 未指定时为所有支持分块全导入且未开启按主键导入的persist
+func warmupTables(tables []string) []string {
+	if len(tables) > 0 {
+		return tables
+	}
+	for name, persist := range gPersistMap {
+		if _, ok := persist.(IPersistWarmup); !ok {
+			continue
+		}
+		if lazy, ok := persist.(interface{ ReadThrough() bool }); ok && lazy.ReadThrough() {
+			continue
+		}
+		tables = append(tables, name)
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+// warmupUsers 并行导入用户, 失败的用户输出错误后跳过
+func warmupUsers(uids []int32, parallel int, progress func(done int64)) {
+	var mu sync.Mutex
+	var done int64
+	ch := make(chan int32)
+	var wg sync.WaitGroup
+	for range min(parallel, len(uids)) {
+		wg.Go(func() {
+			for uid := range ch {
+				if err := Load(uid); err != nil {
+					msg := &Error{Err: fmt.Errorf("warmup user %d: %w", uid, err), Type: ErrorTypeOp}
+					msg.SetMeta(H{"uid": uid})
+					msg.Println(DefaultErrorWriter)
+				}
+				mu.Lock()
+				done++
+				progress(done)
+				mu.Unlock()
+			}
+		})
+	}
+	for _, uid := range uids {
+		ch <- uid
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// ReadThrough 是否开启按主键导入
+func (g *GlobalManager[T]) ReadThrough() bool {
+	return g.readThrough
+}
+
+// LoadAllChunks 按主键范围分块并行全导入, 导入期间 Get 返回false, 修改返回 EPersistErrorNotInMemory
+// 按主键顺序查找每块的上界, 每块按主键范围查询, 不使用 OFFSET; 最后一块不设上界, 导入期间新增在末尾的数据同样导入
+// progress 在每块导入后调用, 同一时间只有一个调用, total 为开始时的行数
+func (g *GlobalManager[T]) LoadAllChunks(chunkSize, parallel int, progress func(done, total int64)) (err error) {
+	if g.engine == nil {
+		return EPersistErrorEngineNil
+	}
+	if chunkSize <= 0 || parallel <= 0 {
+		return fmt.Errorf("%w: chunk size %d, parallel %d", EPersistErrorInvalidConfig, chunkSize, parallel)
+	}
+	if !atomic.CompareAndSwapInt32(&g.loadState, EGlobalTableStateDisk, EGlobalTableStateLoading) {
+		return EPersistErrorIncorrectState
+	}
+	g.warming.Store(true)
+	defer g.warming.Store(false)
+
+	table := g.loadTable()
+	engine := g.readEngine()
+	newSession := func() *xorm.Session {
+		session := engine.NewSession()
+		if table != "" {
+			session.Table(table)
+		}
+		return session
+	}
+	session := newSession()
+	total, err := session.Count(new(T))
+	session.Close()
+	if err != nil {
+		atomic.StoreInt32(&g.loadState, EGlobalTableStateDisk)
+		return err
+	}
+	pkCols := make([]string, 0, len(g.meta.PkIndex))
+	for _, idx := range g.meta.PkIndex {
+		pkCols = append(pkCols, g.dbFieldMap[idx])
+	}
+
+	var mu sync.Mutex
+	var done int64
+	var failed atomic.Bool
+	chunks := make(chan globalChunk)
+	errs := make([]error, parallel+1)
+	lists := make([][]*T, parallel)
+	var wg sync.WaitGroup
+	for i := range parallel {
+		wg.Go(func() {
+			for chunk := range chunks {
+				if failed.Load() {
+					continue
+				}
+				var list []*T
+				session := newSession()
+				if chunk.lo != nil {
+					session.Where(g.pkCompare(pkCols, chunk.lo, ">"))
+				}
+				if chunk.hi != nil {
+					session.Where(g.pkCompare(pkCols, chunk.hi, "<="))
+				}
+				errs[i] = session.Asc(pkCols...).Find(&list)
+				session.Close()
+				if errs[i] != nil {
+					failed.Store(true)
+					continue
+				}
+				lists[i] = append(lists[i], list...)
+				if progress != nil {
+					mu.Lock()
+					done += int64(len(list))
+					progress(done, max(total, done))
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	// 按主键顺序查找每块的上界, 任一块失败后不再分发
+	var lo []any
+	for !failed.Load() {
+		hi := new(T)
+		session := newSession()
+		if lo != nil {
+			session.Where(g.pkCompare(pkCols, lo, ">"))
+		}
+		has, err := session.Cols(pkCols...).Asc(pkCols...).Limit(1, chunkSize-1).Get(hi)
+		session.Close()
+		if err != nil {
+			errs[parallel] = err
+			break
+		}
+		if !has {
+			chunks <- globalChunk{lo: lo}
+			break
+		}
+		chunks <- globalChunk{lo: lo, hi: g.pkValues(hi)}
+		lo = g.pkValues(hi)
+	}
+	close(chunks)
+	wg.Wait()
+
+	if err = errors.Join(errs...); err != nil {
+		atomic.StoreInt32(&g.loadState, EGlobalTableStateDisk)
+		return err
+	}
+	// 所有块成功后才写入内存
+	for _, list := range lists {
+		for _, cls := range list {
+			// 导入期间新建的数据以内存为准
+			g.rows.LoadOrStore(g.pkKey(cls), cls)
+		}
+	}
+	if total == 0 && done == 0 && progress != nil {
+		progress(0, 0)
+	}
+	atomic.StoreInt32(&g.loadState, EGlobalTableStateMemory)
+	return nil
+}
+
+// globalChunk 分块导入的主键范围 (lo, hi], 为 nil 时不限制
+type globalChunk struct {
+	lo, hi []any
+}
+
+// pkCompare 主键比较条件, 联合主键按字段顺序比较, op 为 ">" 或 "<="
+func (g *GlobalManager[T]) pkCompare(cols []string, values []any, op string) builder.Cond {
+	strict := op
+	if op == "<=" {
+		strict = "<"
+	}
+	var cond builder.Cond = builder.Expr(g.engine.Quote(cols[len(cols)-1])+" "+op+" ?", values[len(cols)-1])
+	for i := len(cols) - 2; i >= 0; i-- {
+		col := g.engine.Quote(cols[i])
+		cond = builder.Or(
+			builder.Expr(col+" "+strict+" ?", values[i]),
+			builder.And(builder.Expr(col+" = ?", values[i]), cond),
+		)
+	}
+	return cond
+}
+
+// loadTable 全导入的表名, 分表时为当前周期的表, 为空时按结构体映射
+func (g *GlobalManager[T]) loadTable() string {
+	if g.segment != nil {
+		return g.SegmentTable(time.Now())
+	}
+	return g.table
+}
+
+// LoadAllChunks 所有分片分块全导入, 进度为所有分片的合计
+func (s *ShardedManager[T]) LoadAllChunks(chunkSize, parallel int, progress func(done, total int64)) error {
+	var mu sync.Mutex
+	dones, totals := make([]int64, len(s.shards)), make([]int64, len(s.shards))
+	return s.each(func(i int, g *GlobalManager[T]) error {
+		return g.LoadAllChunks(chunkSize, parallel, func(done, total int64) {
+			if progress == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			dones[i], totals[i] = done, total
+			var sumDone, sumTotal int64
+			for j := range dones {
+				sumDone += dones[j]
+				sumTotal += totals[j]
+			}
+			progress(sumDone, sumTotal)
+		})
+	})
+}
@@ -0,0 +1,213 @@
+package persist_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/spelens-gud/persist"
+)
+
+// TestGlobalManager_LoadAllChunks 测试分块并行全导入所有数据, 进度递增, 导入期间读取和修改快速失败.
+func TestGlobalManager_LoadAllChunks(t *testing.T) {
+	g, engine := newTestManager(t)
+	rows := make([]*ManagerGlobal, 0, 25)
+	for id := int64(1); id <= 25; id++ {
+		rows = append(rows, &ManagerGlobal{AuthId: id, Name: "row"})
+	}
+	if _, err := engine.Insert(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAllChunks(0, 1, nil); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("LoadAllChunks() zero chunk error = %v", err)
+	}
+
+	var last, calls int64
+	err := g.LoadAllChunks(7, 3, func(done, total int64) {
+		calls++
+		if total != 25 || done <= last {
+			t.Errorf("progress = %d/%d after %d", done, total, last)
+		}
+		last = done
+		if _, ok := g.Get(int64(1)); ok {
+			t.Error("Get() during warmup ok = true")
+		}
+		if err := g.Update(&ManagerGlobal{AuthId: 1}, persist.GlobalBitSet[ManagerGlobal]{}); !errors.Is(err, persist.EPersistErrorNotInMemory) {
+			t.Errorf("Update() during warmup error = %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 || last != 25 {
+		t.Errorf("progress calls = %d, last = %d", calls, last)
+	}
+	if g.LoadState() != persist.EGlobalTableStateMemory {
+		t.Errorf("LoadState() = %d", g.LoadState())
+	}
+	n := 0
+	g.Range(func(*ManagerGlobal) bool { n++; return true })
+	if n != 25 {
+		t.Errorf("rows = %d, want 25", n)
+	}
+	if err = g.LoadAllChunks(7, 3, nil); !errors.Is(err, persist.EPersistErrorIncorrectState) {
+		t.Errorf("LoadAllChunks() twice error = %v", err)
+	}
+	g.Exit(&sync.WaitGroup{})
+}
+
+// TestGlobalManager_LoadAllChunksFail 测试任一块导入失败时不再分发, 已导入的块不写入内存, 修复后可以重新导入.
+func TestGlobalManager_LoadAllChunksFail(t *testing.T) {
+	g, engine := newTestManager(t)
+	rows := make([]*ManagerGlobal, 0, 25)
+	for id := int64(1); id <= 25; id++ {
+		rows = append(rows, &ManagerGlobal{AuthId: id, Name: "row"})
+	}
+	if _, err := engine.Insert(rows); err != nil {
+		t.Fatal(err)
+	}
+	// 第三块的数据无法解析
+	if _, err := engine.Exec("UPDATE manager_global SET sort = 'bad' WHERE auth_id = 15"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	var calls int
+	if err := g.LoadAllChunks(7, 1, func(int64, int64) { calls++ }); err == nil {
+		t.Fatal("LoadAllChunks() with bad chunk error = nil")
+	}
+	if calls != 2 {
+		t.Errorf("progress calls = %d, want 2", calls)
+	}
+	if g.LoadState() != persist.EGlobalTableStateDisk {
+		t.Errorf("LoadState() = %d", g.LoadState())
+	}
+	n := 0
+	g.Range(func(*ManagerGlobal) bool { n++; return true })
+	if n != 0 {
+		t.Errorf("rows after failed load = %d, want 0", n)
+	}
+
+	if _, err := engine.Exec("UPDATE manager_global SET sort = 0 WHERE auth_id = 15"); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.LoadAllChunks(7, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	g.Range(func(*ManagerGlobal) bool { n++; return true })
+	if n != 25 {
+		t.Errorf("rows = %d, want 25", n)
+	}
+}
+
+// TestGlobalManager_LoadAllChunksConcurrent 测试导入期间删除和新增数据时按主键范围导入, 不跳过也不重复.
+func TestGlobalManager_LoadAllChunksConcurrent(t *testing.T) {
+	g, engine := newTestManager(t)
+	rows := make([]*ManagerGlobal, 0, 25)
+	for id := int64(1); id <= 25; id++ {
+		rows = append(rows, &ManagerGlobal{AuthId: id, Name: "row"})
+	}
+	if _, err := engine.Insert(rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Exit(&sync.WaitGroup{})
+
+	var once sync.Once
+	err := g.LoadAllChunks(7, 1, func(int64, int64) {
+		once.Do(func() {
+			// 第一块导入后删除后面的数据, 并在末尾新增数据
+			if _, err := engine.ID(10).Delete(new(ManagerGlobal)); err != nil {
+				t.Error(err)
+			}
+			if _, err := engine.Insert(&ManagerGlobal{AuthId: 100, Name: "late"}); err != nil {
+				t.Error(err)
+			}
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	g.Range(func(*ManagerGlobal) bool { n++; return true })
+	if n != 25 {
+		t.Errorf("rows = %d, want 25", n)
+	}
+	for _, id := range []int64{15, 21, 22, 100} {
+		if _, ok := g.Get(id); !ok {
+			t.Errorf("Get(%d) not loaded", id)
+		}
+	}
+	if _, ok := g.Get(int64(10)); ok {
+		t.Error("Get(10) deleted row loaded")
+	}
+}
+
+type WarmupGlobal struct {
+	Id   int64  `xorm:"pk"`
+	Name string `xorm:""`
+}
+
+// TestWarmup 测试预热全导入注册的全局表并预导入去重后的热点用户, 完成后就绪.
+func TestWarmup(t *testing.T) {
+	_, engine := newTestManager(t)
+	g := persist.NewGlobalManager[WarmupGlobal](engine)
+	if err := g.Sync(&sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Insert([]*WarmupGlobal{{Id: 1}, {Id: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if persist.GetIPersistByName(g.PersistName()) == nil {
+		persist.RegisterPersist(g)
+	} else {
+		persist.ChangeRegister(g)
+	}
+	if err := persist.SetWarmup(persist.WarmupConfig{Parallel: -1}); !errors.Is(err, persist.EPersistErrorInvalidConfig) {
+		t.Errorf("SetWarmup() negative error = %v", err)
+	}
+
+	var progress []persist.WarmupProgress
+	err := persist.Warmup(persist.WarmupConfig{
+		Tables:   []string{g.PersistName()},
+		Uids:     []int32{3, 1, 3},
+		UidQuery: func() ([]int32, error) { return []int32{2}, nil },
+		Progress: func(p persist.WarmupProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !persist.Ready() || persist.CheckReady() != nil {
+		t.Error("Ready() = false after warmup")
+	}
+	if g.LoadState() != persist.EGlobalTableStateMemory {
+		t.Errorf("LoadState() = %d", g.LoadState())
+	}
+	want := []persist.WarmupProgress{
+		{Kind: persist.EWarmupKindTable, Name: g.PersistName(), Done: 2, Total: 2},
+		{Kind: persist.EWarmupKindUser, Done: 1, Total: 3},
+		{Kind: persist.EWarmupKindUser, Done: 2, Total: 3},
+		{Kind: persist.EWarmupKindUser, Done: 3, Total: 3},
+	}
+	if len(progress) != len(want) {
+		t.Fatalf("progress = %+v", progress)
+	}
+	for i := range want {
+		if progress[i] != want[i] {
+			t.Errorf("progress[%d] = %+v, want %+v", i, progress[i], want[i])
+		}
+	}
+
+	err = persist.Warmup(persist.WarmupConfig{UidQuery: func() ([]int32, error) { return nil, errors.New("down") }})
+	if err == nil || persist.Ready() || !errors.Is(persist.CheckReady(), persist.EPersistErrorNotInMemory) {
+		t.Errorf("Warmup() failed query error = %v, ready = %v", err, persist.Ready())
+	}
+}